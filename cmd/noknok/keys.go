@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/primal-host/noknok/internal/atproto"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// loadSigningKeys records the configured OAUTH_KEY (if any) and returns the
+// published signing keys, active key first. Only the active key carries its
+// private half: OAUTH_KEY itself, or a rotated key opened with env.
+func loadSigningKeys(ctx context.Context, db *database.DB, env *atproto.Envelope, envKey string, grace time.Duration) ([]atproto.SigningKey, error) {
+	envPub, err := ensureEnvKey(ctx, db, env, envKey, grace)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.ListOAuthKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no signing key: set OAUTH_KEY or run \"noknok keys rotate\"")
+	}
+	keys := make([]atproto.SigningKey, len(rows))
+	for i, k := range rows {
+		keys[i] = atproto.SigningKey{ID: k.KID, Public: k.PublicKey}
+	}
+	active := rows[0]
+	switch {
+	case envPub != "" && active.PublicKey == envPub:
+		keys[0].Multibase = envKey
+	case active.PrivateKey != "":
+		if keys[0].Multibase, err = env.OpenSigningKey(active.KID, active.PrivateKey); err != nil {
+			return nil, fmt.Errorf("open signing key %s: %w", active.KID, err)
+		}
+	default:
+		return nil, fmt.Errorf("active signing key %s is only published: set OAUTH_KEY to its private key", active.KID)
+	}
+	return keys, nil
+}
+
+// ensureEnvKey migrates stored keys and records OAUTH_KEY's public key,
+// returning it ("" when OAUTH_KEY is unset).
+func ensureEnvKey(ctx context.Context, db *database.DB, env *atproto.Envelope, envKey string, grace time.Duration) (string, error) {
+	if err := migrateSigningKeys(ctx, db, env, envKey); err != nil {
+		return "", err
+	}
+	if envKey == "" {
+		return "", nil
+	}
+	pub, err := atproto.PublicMultibase(envKey)
+	if err != nil {
+		return "", fmt.Errorf("parse OAUTH_KEY: %w", err)
+	}
+	if err := db.EnsureOAuthKey(ctx, pub, grace); err != nil {
+		return "", fmt.Errorf("store OAUTH_KEY: %w", err)
+	}
+	return pub, nil
+}
+
+// migrateSigningKeys brings rows written before keys were sealed up to date:
+// retired keys are deleted, public keys are filled in, a stored copy of
+// OAUTH_KEY is dropped and other private keys are sealed with env.
+func migrateSigningKeys(ctx context.Context, db *database.DB, env *atproto.Envelope, envKey string) error {
+	if err := db.PruneOAuthKeys(ctx); err != nil {
+		return fmt.Errorf("prune signing keys: %w", err)
+	}
+	rows, err := db.ListOAuthKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range rows {
+		pub, priv := k.PublicKey, k.PrivateKey
+		if pub == "" {
+			if pub, err = atproto.PublicMultibase(priv); err != nil {
+				return fmt.Errorf("signing key %s: %w", k.KID, err)
+			}
+		}
+		switch {
+		case priv != "" && priv == envKey:
+			priv = ""
+		case priv != "" && env != nil:
+			if priv, _, err = env.RewrapSigningKey(k.KID, priv); err != nil {
+				return fmt.Errorf("seal signing key %s: %w", k.KID, err)
+			}
+		case priv != "":
+			if _, err := env.OpenSigningKey(k.KID, priv); err == nil {
+				slog.Warn("OAUTH_STORE_KEK is unset: signing key is stored unencrypted", "kid", k.KID)
+			}
+		}
+		if pub != k.PublicKey || priv != k.PrivateKey {
+			if err := db.UpdateOAuthKey(ctx, k.KID, pub, priv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runKeys implements the "noknok keys" subcommand:
+//
+//	noknok keys list
+//	noknok keys rotate [-grace 168h]
+//
+// Rotation publishes the new key immediately; running instances start
+// signing with it after a restart. Previous keys stay in the JWKS until
+// their retirement time so existing sessions keep refreshing.
+func runKeys(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: noknok keys list|rotate [-grace duration]")
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config load failed:", err)
+		return 1
+	}
+
+	grace, err := time.ParseDuration(cfg.OAuthKeyGrace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid OAUTH_KEY_GRACE:", err)
+		return 1
+	}
+	env, err := loadEnvelope(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load KEK:", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db, err := database.Open(ctx, cfg.DSN())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "database open failed:", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "list":
+		if _, err := ensureEnvKey(ctx, db, env, cfg.OAuthPrivateKey, grace); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		keys, err := db.ListOAuthKeys(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "list keys:", err)
+			return 1
+		}
+		for _, k := range keys {
+			status := "active"
+			if k.RetireAt != nil {
+				status = "retires " + k.RetireAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%s\tcreated %s\t%s\n", k.KID, k.CreatedAt.UTC().Format(time.RFC3339), status)
+		}
+		return 0
+
+	case "rotate":
+		fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+		fs.DurationVar(&grace, "grace", grace, "how long the previous key stays published (default OAUTH_KEY_GRACE)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if env == nil {
+			fmt.Fprintln(os.Stderr, "OAUTH_STORE_KEK is not set: rotated keys are stored in the database and must be sealed")
+			return 1
+		}
+		if _, err := ensureEnvKey(ctx, db, env, cfg.OAuthPrivateKey, grace); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		key, err := atproto.GenerateSigningKey()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "generate key:", err)
+			return 1
+		}
+		sealed, err := env.SealSigningKey(key.ID, key.Multibase)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "seal key:", err)
+			return 1
+		}
+		if err := db.RotateOAuthKey(ctx, key.ID, key.Public, sealed, grace); err != nil {
+			fmt.Fprintln(os.Stderr, "rotate key:", err)
+			return 1
+		}
+		fmt.Printf("new signing key %s; previous keys retire in %s\n", key.ID, grace)
+		fmt.Println("restart noknok to start signing with the new key")
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown keys command %q\n", args[0])
+	return 2
+}
@@ -2,21 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"log/slog"
+	"net/url"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/primal-host/noknok/internal/atproto"
 	"github.com/primal-host/noknok/internal/config"
 	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/devauth"
+	"github.com/primal-host/noknok/internal/discovery"
 	"github.com/primal-host/noknok/internal/server"
 	"github.com/primal-host/noknok/internal/session"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "store" {
+		os.Exit(runStore(os.Args[2:]))
+	}
+
 	slog.Info("noknok starting", "version", config.Version)
 
 	cfg, err := config.Load()
@@ -61,13 +72,70 @@ func main() {
 	slog.Info("services seeded and owner granted")
 
 	// OAuth client.
-	store := atproto.NewPgStore(db.Pool)
-	oauthClient, err := atproto.NewOAuthClient(cfg.PublicURL, cfg.OAuthPrivateKey, store)
+	env, err := loadEnvelope(cfg)
 	if err != nil {
-		slog.Error("OAuth client init failed", "error", err)
+		slog.Error("invalid OAuth store KEK", "error", err)
 		os.Exit(1)
 	}
-	slog.Info("OAuth client initialized")
+	if env == nil {
+		slog.Warn("OAUTH_STORE_KEK is unset: OAuth tokens and DPoP keys are stored unencrypted")
+	} else {
+		slog.Info("OAuth store encryption enabled", "kek", env.CurrentKEK())
+	}
+	store := atproto.NewPgStore(db.Pool, env)
+	policy := atproto.HostPolicy{Allow: cfg.OAuthHostAllow, Deny: cfg.OAuthHostDeny}
+	info := atproto.ClientInfo{
+		Name:      cfg.ClientName,
+		URI:       cfg.ClientURI,
+		LogoURI:   cfg.ClientLogoURI,
+		TOSURI:    cfg.ClientTOSURI,
+		PolicyURI: cfg.ClientPolicyURI,
+		UserAgent: "noknok/" + config.Version,
+	}
+	var oauthClient *atproto.OAuthClient
+	if cfg.DevMode {
+		oauthClient, err = atproto.NewLocalhostOAuthClient(cfg.DevCallbackURL, info, store, cfg.OAuthStoreTokens, policy)
+		if err != nil {
+			slog.Error("OAuth client init failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Warn("DEV_MODE: using atproto loopback OAuth client", "callback", cfg.DevCallbackURL)
+	} else {
+		// OAuth signing keys: OAUTH_KEY seeds the key table; rotation happens
+		// through "noknok keys rotate".
+		grace, err := time.ParseDuration(cfg.OAuthKeyGrace)
+		if err != nil {
+			slog.Error("invalid OAUTH_KEY_GRACE", "error", err)
+			os.Exit(1)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		keys, err := loadSigningKeys(ctx, db, env, cfg.OAuthPrivateKey, grace)
+		cancel()
+		if err != nil {
+			slog.Error("failed to load OAuth signing keys", "error", err)
+			os.Exit(1)
+		}
+		oauthClient, err = atproto.NewOAuthClient(cfg.PublicURL, keys, info, store, cfg.OAuthStoreTokens, policy)
+		if err != nil {
+			slog.Error("OAuth client init failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("OAuth client initialized", "kid", oauthClient.KeyID(), "published_keys", len(keys))
+	}
+
+	var dev *devauth.Server
+	if cfg.DevFakeATProto {
+		dev, err = devauth.New(cfg.PublicURL, cfg.DevIdentities)
+		if err != nil {
+			slog.Error("dev auth server init failed", "error", err)
+			os.Exit(1)
+		}
+		oauthClient.UseDevServer(dev)
+		slog.Warn("DEV_FAKE_ATPROTO: atproto traffic is served by the offline fake; logins are NOT authenticated", "pds", dev.PDSURL())
+		for _, id := range dev.Identities() {
+			slog.Info("dev identity", "handle", id.Handle, "did", id.DID)
+		}
+	}
 
 	// Session manager.
 	ttl, err := time.ParseDuration(cfg.SessionTTL)
@@ -75,11 +143,73 @@ func main() {
 		slog.Error("invalid SESSION_TTL", "error", err)
 		os.Exit(1)
 	}
-	secure := strings.HasPrefix(cfg.PublicURL, "https://")
-	sess := session.NewManager(db.Pool, ttl, cfg.CookieDomain, secure)
+	oauthTTL, err := time.ParseDuration(cfg.OAuthStateTTL)
+	if err != nil {
+		slog.Error("invalid OAUTH_STATE_TTL", "error", err)
+		os.Exit(1)
+	}
+	if cfg.SessionHashKey == "" {
+		// Only reachable in DEV_MODE; sessions do not survive a restart.
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			slog.Error("failed to generate session hash key", "error", err)
+			os.Exit(1)
+		}
+		cfg.SessionHashKey = hex.EncodeToString(key)
+		slog.Warn("DEV_MODE: SESSION_HASH_KEY is unset; using a random key for this process")
+	}
+	cookies := session.CookiePolicy{
+		Name:             cfg.CookieName,
+		SecurePrefix:     cfg.CookieSecurePrefix,
+		Secure:           cfg.CookieSecure,
+		SameSite:         cfg.CookieSameSite,
+		SameSiteByDomain: cfg.CookieSameSiteByDomain,
+		Partitioned:      cfg.CookiePartitioned,
+	}
+	if cfg.PortalHostCookie {
+		if u, err := url.Parse(cfg.PublicURL); err == nil {
+			cookies.PortalHost = u.Hostname()
+		}
+	}
+	bindAction, err := session.ParseBindingAction(cfg.SessionBinding)
+	if err != nil {
+		slog.Error("invalid SESSION_BINDING", "error", err)
+		os.Exit(1)
+	}
+	binding := session.BindingPolicy{Action: bindAction, Strict: cfg.SessionBindingFields}
+	sess := session.NewManager(db.Pool, ttl, oauthTTL, cfg.CookieDomain, cookies, []byte(cfg.SessionHashKey), binding)
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	if err := sess.MigrateTokens(ctx); err != nil {
+		cancel()
+		slog.Error("failed to hash legacy session tokens", "error", err)
+		os.Exit(1)
+	}
+	cancel()
 	sess.StartCleanup()
 
+	var docker *discovery.Docker
+	if cfg.DockerDiscovery {
+		interval, err := time.ParseDuration(cfg.DiscoveryInterval)
+		if err != nil || interval <= 0 {
+			slog.Error("invalid DISCOVERY_INTERVAL", "value", cfg.DiscoveryInterval)
+			os.Exit(1)
+		}
+		docker = discovery.NewDocker(db, cfg.DockerSocket, interval)
+		docker.Start()
+		slog.Info("docker discovery enabled", "socket", cfg.DockerSocket, "interval", interval)
+	}
+
+	if len(cfg.TrustedProxies) == 0 && cfg.TrustedProxySecret == "" {
+		slog.Warn("TRUSTED_PROXIES and TRUSTED_PROXY_SECRET are unset: forward-auth endpoints trust any caller's forwarded headers")
+	}
+	if len(cfg.TrustedProxies) == 0 && cfg.TrustedProxySecret != "" {
+		slog.Warn("TRUSTED_PROXY_SECRET cannot be sent by Traefik: /auth rejects every caller until TRUSTED_PROXIES is set")
+	}
+
 	srv := server.New(db, sess, cfg, oauthClient)
+	if dev != nil {
+		srv.MountDevAuth(dev)
+	}
 
 	go func() {
 		if err := srv.Start(); err != nil {
@@ -94,6 +224,9 @@ func main() {
 	slog.Info("shutting down", "signal", sig.String())
 
 	sess.StopCleanup()
+	if docker != nil {
+		docker.Stop()
+	}
 
 	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/primal-host/noknok/internal/atproto"
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// loadEnvelope returns the OAuth store envelope, or nil when no
+// OAUTH_STORE_KEK is configured.
+func loadEnvelope(cfg *config.Config) (*atproto.Envelope, error) {
+	if cfg.OAuthStoreKEK == "" {
+		if len(cfg.OAuthStoreKEKPrevious) > 0 {
+			return nil, fmt.Errorf("OAUTH_STORE_KEK_PREVIOUS is set without OAUTH_STORE_KEK")
+		}
+		return nil, nil
+	}
+	return atproto.NewEnvelope(cfg.OAuthStoreKEK, cfg.OAuthStoreKEKPrevious)
+}
+
+// runStore implements the "noknok store" subcommand:
+//
+//	noknok store genkey      print a new OAUTH_STORE_KEK
+//	noknok store reencrypt   encrypt plaintext rows and rewrap old-KEK rows
+//
+// To rotate, move the current key to OAUTH_STORE_KEK_PREVIOUS, set a new
+// OAUTH_STORE_KEK, restart, run "noknok store reencrypt", then drop the
+// previous key.
+func runStore(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: noknok store genkey|reencrypt")
+		return 2
+	}
+
+	if args[0] == "genkey" {
+		key, err := atproto.GenerateKEK()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "generate key:", err)
+			return 1
+		}
+		fmt.Println(key)
+		return 0
+	}
+	if args[0] != "reencrypt" {
+		fmt.Fprintf(os.Stderr, "unknown store command %q\n", args[0])
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config load failed:", err)
+		return 1
+	}
+	env, err := loadEnvelope(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load KEK:", err)
+		return 1
+	}
+	if env == nil {
+		fmt.Fprintln(os.Stderr, "OAUTH_STORE_KEK is not set")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	db, err := database.Open(ctx, cfg.DSN())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "database open failed:", err)
+		return 1
+	}
+	defer db.Close()
+
+	n, err := atproto.NewPgStore(db.Pool, env).Reencrypt(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reencrypt failed after %d rows: %v\n", n, err)
+		return 1
+	}
+	fmt.Printf("rewrote %d rows under KEK %s\n", n, env.CurrentKEK())
+	return 0
+}
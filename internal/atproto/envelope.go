@@ -0,0 +1,217 @@
+package atproto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Envelope encrypts stored OAuth blobs: each blob gets a random data key
+// (AES-256-GCM), and the data key is wrapped with a key encryption key.
+// Several KEKs may be loaded so rows sealed before a rotation still open;
+// new rows always use the current one.
+type Envelope struct {
+	current string            // id of the KEK used for sealing
+	keks    map[string][]byte // id -> 32-byte KEK
+}
+
+// sealed is the JSON stored in place of a plaintext blob.
+type sealed struct {
+	Enc   int    `json:"noknok_enc"` // format version, always 1
+	KEK   string `json:"kek"`        // KEK id
+	DEK   string `json:"dek"`        // wrapped data key (nonce || ciphertext)
+	Nonce string `json:"nonce"`
+	Data  string `json:"data"`
+}
+
+// ErrNoKEK is returned when a sealed blob names a KEK that is not loaded.
+var ErrNoKEK = errors.New("envelope: key encryption key not loaded")
+
+// NewEnvelope loads base64-encoded 32-byte KEKs. current seals new data;
+// previous keys are only used to open existing rows.
+func NewEnvelope(current string, previous []string) (*Envelope, error) {
+	e := &Envelope{keks: map[string][]byte{}}
+	id, err := e.add(current)
+	if err != nil {
+		return nil, fmt.Errorf("current KEK: %w", err)
+	}
+	e.current = id
+	for i, p := range previous {
+		if _, err := e.add(p); err != nil {
+			return nil, fmt.Errorf("previous KEK %d: %w", i+1, err)
+		}
+	}
+	return e, nil
+}
+
+func (e *Envelope) add(b64 string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", fmt.Errorf("decode: %w", err)
+	}
+	if len(key) != 32 {
+		return "", fmt.Errorf("want 32 bytes, got %d", len(key))
+	}
+	sum := sha256.Sum256(key)
+	id := hex.EncodeToString(sum[:4])
+	e.keks[id] = key
+	return id, nil
+}
+
+// CurrentKEK returns the id of the KEK used for sealing.
+func (e *Envelope) CurrentKEK() string {
+	return e.current
+}
+
+// GenerateKEK returns a new random KEK in the format NewEnvelope expects.
+func GenerateKEK() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Seal encrypts plain. aad binds the blob to its row (e.g. did and session
+// id) so a sealed value cannot be moved to another row.
+func (e *Envelope) Seal(plain []byte, aad string) ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	nonce, ct, err := gcmSeal(dek, plain, []byte(aad))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := e.wrap(e.current, dek)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed{
+		Enc:   1,
+		KEK:   e.current,
+		DEK:   base64.StdEncoding.EncodeToString(wrapped),
+		Nonce: base64.StdEncoding.EncodeToString(nonce),
+		Data:  base64.StdEncoding.EncodeToString(ct),
+	})
+}
+
+// Open decrypts a blob written by Seal. Blobs that are not sealed (rows
+// written before encryption was enabled) are returned unchanged.
+func (e *Envelope) Open(data []byte, aad string) ([]byte, error) {
+	s, ok := parseSealed(data)
+	if !ok {
+		return data, nil
+	}
+	if e == nil {
+		return nil, ErrNoKEK
+	}
+	dek, err := e.unwrap(s)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(s.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: nonce: %w", err)
+	}
+	ct, err := base64.StdEncoding.DecodeString(s.Data)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: data: %w", err)
+	}
+	return gcmOpen(dek, nonce, ct, []byte(aad))
+}
+
+// Rewrap brings a stored blob up to date: plaintext is sealed and a data key
+// wrapped under an old KEK is rewrapped under the current one. It reports
+// whether the blob changed.
+func (e *Envelope) Rewrap(data []byte, aad string) ([]byte, bool, error) {
+	s, ok := parseSealed(data)
+	if !ok {
+		out, err := e.Seal(data, aad)
+		return out, err == nil, err
+	}
+	if s.KEK == e.current {
+		return data, false, nil
+	}
+	dek, err := e.unwrap(s)
+	if err != nil {
+		return nil, false, err
+	}
+	wrapped, err := e.wrap(e.current, dek)
+	if err != nil {
+		return nil, false, err
+	}
+	s.KEK = e.current
+	s.DEK = base64.StdEncoding.EncodeToString(wrapped)
+	out, err := json.Marshal(s)
+	return out, err == nil, err
+}
+
+func parseSealed(data []byte) (sealed, bool) {
+	var s sealed
+	if json.Unmarshal(data, &s) != nil || s.Enc != 1 {
+		return sealed{}, false
+	}
+	return s, true
+}
+
+func (e *Envelope) wrap(kid string, dek []byte) ([]byte, error) {
+	nonce, ct, err := gcmSeal(e.keks[kid], dek, []byte(kid))
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ct...), nil
+}
+
+func (e *Envelope) unwrap(s sealed) ([]byte, error) {
+	kek, ok := e.keks[s.KEK]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoKEK, s.KEK)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(s.DEK)
+	if err != nil || len(wrapped) < 12 {
+		return nil, errors.New("envelope: malformed data key")
+	}
+	return gcmOpen(kek, wrapped[:12], wrapped[12:], []byte(s.KEK))
+}
+
+func gcmSeal(key, plain, aad []byte) (nonce, ct []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plain, aad), nil
+}
+
+func gcmOpen(key, nonce, ct, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("envelope: bad nonce")
+	}
+	plain, err := gcm.Open(nil, nonce, ct, aad)
+	if err != nil {
+		return nil, errors.New("envelope: decryption failed")
+	}
+	return plain, nil
+}
@@ -0,0 +1,104 @@
+package atproto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+)
+
+// SigningKey is an ES256 key used for OAuth client assertions. Keys that
+// are only published (not used for signing) may carry just the public half.
+type SigningKey struct {
+	ID        string // JWK key id (kid)
+	Multibase string // multibase-encoded private key; empty for publish-only keys
+	Public    string // multibase-encoded public key
+}
+
+// GenerateSigningKey creates a new P-256 signing key with a time-based kid.
+func GenerateSigningKey() (SigningKey, error) {
+	priv, err := atcrypto.GeneratePrivateKeyP256()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{
+		ID:        "noknok-" + time.Now().UTC().Format("20060102150405"),
+		Multibase: priv.Multibase(),
+		Public:    pub.Multibase(),
+	}, nil
+}
+
+// PublicMultibase returns the multibase public key for a multibase private key.
+func PublicMultibase(private string) (string, error) {
+	priv, err := atcrypto.ParsePrivateMultibase(private)
+	if err != nil {
+		return "", err
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	return pub.Multibase(), nil
+}
+
+// signingKeyAAD binds a sealed private key to its kid.
+func signingKeyAAD(kid string) string {
+	return "oauth_keys:" + kid
+}
+
+// SealSigningKey encrypts a private key for the oauth_keys table.
+func (e *Envelope) SealSigningKey(kid, private string) (string, error) {
+	out, err := e.Seal([]byte(private), signingKeyAAD(kid))
+	return string(out), err
+}
+
+// OpenSigningKey decrypts a private key read from the oauth_keys table. A
+// nil Envelope only opens keys stored before sealing was introduced.
+func (e *Envelope) OpenSigningKey(kid, stored string) (string, error) {
+	out, err := e.Open([]byte(stored), signingKeyAAD(kid))
+	return string(out), err
+}
+
+// RewrapSigningKey seals a plaintext private key, or rewraps one sealed
+// under an old KEK, and reports whether it changed.
+func (e *Envelope) RewrapSigningKey(kid, stored string) (string, bool, error) {
+	out, changed, err := e.Rewrap([]byte(stored), signingKeyAAD(kid))
+	return string(out), changed, err
+}
+
+// KeyJWKS builds the public key set for the given signing keys. Every key is
+// published so assertions signed before a rotation still verify.
+func KeyJWKS(keys []SigningKey) (oauth.JWKS, error) {
+	jwks := oauth.JWKS{Keys: []atcrypto.JWK{}}
+	for _, k := range keys {
+		var pub atcrypto.PublicKey
+		if k.Public != "" {
+			p, err := atcrypto.ParsePublicMultibase(k.Public)
+			if err != nil {
+				return jwks, fmt.Errorf("parse public key %s: %w", k.ID, err)
+			}
+			pub = p
+		} else {
+			priv, err := atcrypto.ParsePrivateMultibase(k.Multibase)
+			if err != nil {
+				return jwks, fmt.Errorf("parse key %s: %w", k.ID, err)
+			}
+			if pub, err = priv.PublicKey(); err != nil {
+				return jwks, fmt.Errorf("public key %s: %w", k.ID, err)
+			}
+		}
+		jwk, err := pub.JWK()
+		if err != nil {
+			return jwks, fmt.Errorf("jwk %s: %w", k.ID, err)
+		}
+		kid := k.ID
+		jwk.KeyID = &kid
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+	return jwks, nil
+}
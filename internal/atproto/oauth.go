@@ -3,60 +3,327 @@ package atproto
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/atcrypto"
 	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 )
 
 // OAuthClient wraps the indigo OAuth ClientApp for AT Protocol login.
 type OAuthClient struct {
-	app *oauth.ClientApp
-	cfg *oauth.ClientConfig
+	app         *oauth.ClientApp
+	cfg         *oauth.ClientConfig
+	storeTokens bool
+	policy      HostPolicy
+	jwks        oauth.JWKS
+	info        ClientInfo
+	dev         DevServer
+}
+
+// DevServer is an in-process stand-in for the atproto network, used by the
+// offline development mode (see package devauth).
+type DevServer interface {
+	Transport() http.RoundTripper
+	Directory() identity.Directory
+	BrowserURL(authorizeURL string) string
+}
+
+// UseDevServer routes all identity resolution and OAuth traffic to dev.
+// It must be called before the client serves any request.
+func (c *OAuthClient) UseDevServer(dev DevServer) {
+	client := &http.Client{Transport: dev.Transport(), Timeout: 10 * time.Second}
+	c.app.Client = client
+	c.app.Resolver.Client = client
+	c.app.Dir = dev.Directory()
+	c.dev = dev
+}
+
+// browserURL maps an authorization URL to one the user's browser can reach.
+func (c *OAuthClient) browserURL(authURL string, err error) (string, error) {
+	if err != nil || c.dev == nil {
+		return authURL, err
+	}
+	return c.dev.BrowserURL(authURL), nil
+}
+
+// ClientInfo describes the deployment on authorization servers' consent
+// screens. Empty fields are omitted from the client metadata.
+type ClientInfo struct {
+	Name      string
+	URI       string // home page; should share the client_id origin
+	LogoURI   string // https only
+	TOSURI    string // https only
+	PolicyURI string // https only
+	UserAgent string // sent on outbound atproto requests
+}
+
+func (i ClientInfo) validate() error {
+	for name, u := range map[string]string{"logo_uri": i.LogoURI, "tos_uri": i.TOSURI, "policy_uri": i.PolicyURI} {
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("%s must be an https URL", name)
+		}
+	}
+	if i.URI != "" {
+		parsed, err := url.Parse(i.URI)
+		if err != nil || parsed.Host == "" {
+			return fmt.Errorf("client_uri must be an absolute URL")
+		}
+	}
+	return nil
 }
 
 // NewOAuthClient creates an OAuth client configured as a confidential web app.
-func NewOAuthClient(publicURL, privateKeyMultibase string, store oauth.ClientAuthStore) (*OAuthClient, error) {
+// The first key signs client assertions; all keys are published in the JWKS.
+// If storeTokens is false, the client only establishes identity: tokens from
+// the callback are revoked immediately and never written to the store.
+// policy restricts which PDS hosts and authorization servers are accepted.
+func NewOAuthClient(publicURL string, keys []SigningKey, info ClientInfo, store oauth.ClientAuthStore, storeTokens bool, policy HostPolicy) (*OAuthClient, error) {
 	clientID := publicURL + "/.well-known/oauth-client-metadata"
 	callbackURL := publicURL + "/oauth/callback"
 
+	if err := info.validate(); err != nil {
+		return nil, err
+	}
 	cfg := oauth.NewPublicConfig(clientID, callbackURL, []string{"atproto"})
-	cfg.UserAgent = "noknok/0.4.0"
+	if info.UserAgent != "" {
+		cfg.UserAgent = info.UserAgent
+	}
 
-	privKey, err := atcrypto.ParsePrivateMultibase(privateKeyMultibase)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no OAuth signing key")
+	}
+	privKey, err := atcrypto.ParsePrivateMultibase(keys[0].Multibase)
 	if err != nil {
 		return nil, fmt.Errorf("parse OAuth private key: %w", err)
 	}
-	if err := cfg.SetClientSecret(privKey, "noknok-1"); err != nil {
+	if err := cfg.SetClientSecret(privKey, keys[0].ID); err != nil {
 		return nil, fmt.Errorf("set client secret: %w", err)
 	}
+	jwks, err := KeyJWKS(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if !storeTokens {
+		store = identityOnlyStore{store}
+	}
+	app := oauth.NewClientApp(&cfg, store)
+	return &OAuthClient{app: app, cfg: &cfg, storeTokens: storeTokens, policy: policy, jwks: jwks, info: info}, nil
+}
 
+// NewLocalhostOAuthClient creates a public OAuth client that follows the
+// atproto loopback-client convention: an http://localhost client_id that
+// needs no metadata document and no signing key. callbackURL must use a
+// loopback IP (127.0.0.1 or [::1]). Intended for local development only.
+func NewLocalhostOAuthClient(callbackURL string, info ClientInfo, store oauth.ClientAuthStore, storeTokens bool, policy HostPolicy) (*OAuthClient, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.Scheme != "http" || (u.Hostname() != "127.0.0.1" && u.Hostname() != "::1") {
+		return nil, fmt.Errorf("loopback callback URL must be http://127.0.0.1 or http://[::1]")
+	}
+	cfg := oauth.NewLocalhostConfig(callbackURL, []string{"atproto"})
+	if info.UserAgent != "" {
+		cfg.UserAgent = info.UserAgent
+	}
+	if !storeTokens {
+		store = identityOnlyStore{store}
+	}
 	app := oauth.NewClientApp(&cfg, store)
-	return &OAuthClient{app: app, cfg: &cfg}, nil
+	return &OAuthClient{app: app, cfg: &cfg, storeTokens: storeTokens, policy: policy, jwks: cfg.PublicJWKS(), info: info}, nil
+}
+
+// IsLoopback reports whether this is a localhost development client.
+func (c *OAuthClient) IsLoopback() bool {
+	return !c.cfg.IsConfidential()
 }
 
-// StartLogin begins the OAuth flow for the given handle, returning the
-// authorization URL the user should be redirected to.
-func (c *OAuthClient) StartLogin(ctx context.Context, handle string) (string, error) {
-	return c.app.StartAuthFlow(ctx, handle)
+// CallbackURL returns the redirect URI registered with authorization servers.
+func (c *OAuthClient) CallbackURL() string {
+	return c.cfg.CallbackURL
+}
+
+// LoginKind identifies what a user entered on the login form.
+type LoginKind int
+
+const (
+	LoginHandle LoginKind = iota // a handle, e.g. alice.bsky.social
+	LoginDID                     // a did:plc or did:web identifier
+	LoginServer                  // a PDS or entryway URL
+)
+
+// LoginInput is a normalized login form entry.
+type LoginInput struct {
+	Kind  LoginKind
+	Value string
+}
+
+// ParseLoginInput classifies and normalizes login form input. Bare names
+// (no dot) default to .bsky.social; server URLs are reduced to their origin.
+func ParseLoginInput(raw string) (LoginInput, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, "did:"):
+		did, err := syntax.ParseDID(raw)
+		if err != nil {
+			return LoginInput{Kind: LoginDID}, fmt.Errorf("invalid DID: %w", err)
+		}
+		if m := did.Method(); m != "plc" && m != "web" {
+			return LoginInput{Kind: LoginDID}, fmt.Errorf("unsupported DID method %q", m)
+		}
+		return LoginInput{Kind: LoginDID, Value: did.String()}, nil
+
+	case strings.HasPrefix(raw, "https://"), strings.HasPrefix(raw, "http://"):
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return LoginInput{Kind: LoginServer}, fmt.Errorf("invalid server URL")
+		}
+		if u.Scheme != "https" {
+			return LoginInput{Kind: LoginServer}, fmt.Errorf("server URL must use https")
+		}
+		return LoginInput{Kind: LoginServer, Value: "https://" + u.Host}, nil
+	}
+
+	handle := strings.TrimPrefix(raw, "@")
+	if !strings.Contains(handle, ".") {
+		handle += ".bsky.social"
+	}
+	hdl, err := syntax.ParseHandle(handle)
+	if err != nil {
+		return LoginInput{Kind: LoginHandle}, fmt.Errorf("invalid handle: %w", err)
+	}
+	return LoginInput{Kind: LoginHandle, Value: hdl.Normalize().String()}, nil
+}
+
+// StartLogin begins the OAuth flow for a handle, DID, or server URL,
+// returning the authorization URL the user should be redirected to.
+// The PDS and authorization server are checked against the host policy
+// before any request is sent to them.
+func (c *OAuthClient) StartLogin(ctx context.Context, in LoginInput) (string, error) {
+	if in.Kind != LoginServer {
+		atid, err := syntax.ParseAtIdentifier(in.Value)
+		if err != nil {
+			return "", err
+		}
+		ident, err := c.app.Dir.Lookup(ctx, atid)
+		if err != nil {
+			return "", fmt.Errorf("resolve %s: %w", in.Value, err)
+		}
+		pds := ident.PDSEndpoint()
+		if pds == "" {
+			return "", fmt.Errorf("identity does not link to a PDS")
+		}
+		authServer, err := c.app.Resolver.ResolveAuthServerURL(ctx, pds)
+		if err != nil {
+			return "", fmt.Errorf("resolve auth server: %w", err)
+		}
+		if err := c.policy.check(pds, authServer); err != nil {
+			return "", err
+		}
+		return c.browserURL(c.app.StartAuthFlow(ctx, in.Value))
+	}
+
+	// A PDS points at its authorization server through protected resource
+	// metadata; an entryway is the authorization server itself.
+	pds := in.Value
+	authServer, err := c.app.Resolver.ResolveAuthServerURL(ctx, in.Value)
+	if err != nil {
+		authServer, pds = in.Value, ""
+	}
+	if err := c.policy.check(pds, authServer); err != nil {
+		return "", err
+	}
+	return c.browserURL(c.app.StartAuthFlow(ctx, authServer))
+}
+
+// CallbackResult describes a completed OAuth login.
+type CallbackResult struct {
+	DID        string
+	Handle     string
+	SessionID  string // key of the stored atproto OAuth session
+	PDSURL     string
+	AuthServer string // issuer that authenticated the account
 }
 
 // HandleCallback processes the OAuth callback parameters and returns
 // the authenticated DID and handle.
-func (c *OAuthClient) HandleCallback(ctx context.Context, params url.Values) (string, string, error) {
+func (c *OAuthClient) HandleCallback(ctx context.Context, params url.Values) (*CallbackResult, error) {
 	sess, err := c.app.ProcessCallback(ctx, params)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+
+	res := &CallbackResult{
+		DID:        sess.AccountDID.String(),
+		SessionID:  sess.SessionID,
+		PDSURL:     sess.HostURL,
+		AuthServer: sess.AuthServerURL,
+	}
+
+	// Re-check policy against what the token exchange actually established.
+	if err := c.policy.check(res.PDSURL, res.AuthServer); err != nil {
+		if c.storeTokens {
+			_ = c.Revoke(ctx, res.DID, res.SessionID)
+		} else {
+			c.revokeData(ctx, sess)
+		}
+		return nil, err
+	}
+
+	// Identity-only mode: the tokens were never stored, so revoke them now.
+	if !c.storeTokens {
+		c.revokeData(ctx, sess)
+		res.SessionID = ""
 	}
 
 	// Look up handle from DID (should be cached from ProcessCallback's lookup).
 	ident, err := c.app.Dir.LookupDID(ctx, sess.AccountDID)
+	if err == nil {
+		res.Handle = ident.Handle.String()
+	}
+	return res, nil
+}
+
+// Revoke revokes the tokens of a stored OAuth session at the authorization
+// server and deletes the session from the store.
+func (c *OAuthClient) Revoke(ctx context.Context, did, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+	d, err := syntax.ParseDID(did)
+	if err != nil {
+		return fmt.Errorf("invalid DID: %w", err)
+	}
+	return c.app.Logout(ctx, d, sessionID)
+}
+
+// revokeData revokes session tokens that exist only in memory.
+func (c *OAuthClient) revokeData(ctx context.Context, data *oauth.ClientSessionData) {
+	if data.AuthServerRevocationEndpoint == "" {
+		return
+	}
+	priv, err := atcrypto.ParsePrivateMultibase(data.DPoPPrivateKeyMultibase)
 	if err != nil {
-		return sess.AccountDID.String(), "", nil
+		slog.Warn("revoke: invalid DPoP key", "did", data.AccountDID, "error", err)
+		return
+	}
+	sess := &oauth.ClientSession{
+		Client:         c.app.Client,
+		Config:         c.cfg,
+		Data:           data,
+		DPoPPrivateKey: priv,
+	}
+	if err := sess.RevokeSession(ctx); err != nil {
+		slog.Warn("revoke: token revocation failed", "did", data.AccountDID, "error", err)
 	}
-	return sess.AccountDID.String(), ident.Handle.String(), nil
 }
 
 // ClientMetadata returns the OAuth client metadata document.
@@ -65,14 +332,34 @@ func (c *OAuthClient) ClientMetadata() oauth.ClientMetadata {
 	// Confidential clients must set JWKS URI after the fact.
 	jwksURI := c.cfg.ClientID[:len(c.cfg.ClientID)-len("/.well-known/oauth-client-metadata")] + "/oauth/jwks.json"
 	m.JWKSURI = &jwksURI
-	name := "noknok"
-	m.ClientName = &name
+	m.ClientName = optional(c.info.Name)
+	m.ClientURI = optional(c.info.URI)
+	m.LogoURI = optional(c.info.LogoURI)
+	m.TosURI = optional(c.info.TOSURI)
+	m.PolicyURI = optional(c.info.PolicyURI)
 	return m
 }
 
-// PublicJWKS returns the public key set for client assertion verification.
+// optional returns a pointer to s, or nil if s is empty.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// PublicJWKS returns the public key set loaded at startup.
 func (c *OAuthClient) PublicJWKS() oauth.JWKS {
-	return c.cfg.PublicJWKS()
+	return c.jwks
+}
+
+// KeyID returns the kid of the key currently signing client assertions,
+// or "" for a loopback client.
+func (c *OAuthClient) KeyID() string {
+	if c.cfg.KeyID == nil {
+		return ""
+	}
+	return *c.cfg.KeyID
 }
 
 // ResolveHandle resolves a handle to a DID and canonical handle.
@@ -0,0 +1,93 @@
+package atproto
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrHostNotAllowed is returned when an account's PDS or authorization
+// server is rejected by the configured HostPolicy.
+var ErrHostNotAllowed = errors.New("server not allowed by policy")
+
+// HostPolicy decides which authorization servers and PDS hosts noknok
+// trusts. Entries are hostnames; a leading "*." or "." also matches
+// subdomains. The allow list names trusted authorization servers (issuers):
+// "bsky.social" admits every account it authenticates, whichever
+// *.host.bsky.network PDS hosts it. The deny list applies to both issuers
+// and PDS hosts and wins over the allow list. An empty allow list allows any
+// issuer that is not denied.
+type HostPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// host returns the lowercased hostname of rawURL, or "" if it has none.
+func host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// denied reports whether host h is on the deny list.
+func (p HostPolicy) denied(h string) bool {
+	for _, d := range p.Deny {
+		if hostMatches(h, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// IssuerAllowed reports whether the authorization server at rawURL passes
+// the policy.
+func (p HostPolicy) IssuerAllowed(rawURL string) bool {
+	h := host(rawURL)
+	if h == "" || p.denied(h) {
+		return false
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, a := range p.Allow {
+		if hostMatches(h, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// PDSAllowed reports whether the PDS at rawURL passes the policy: it must not
+// be denied. PDS hosts are not checked against the allow list.
+func (p HostPolicy) PDSAllowed(rawURL string) bool {
+	h := host(rawURL)
+	return h != "" && !p.denied(h)
+}
+
+// check returns ErrHostNotAllowed if the PDS or the issuer fails the policy.
+// Either may be empty when it is not known yet.
+func (p HostPolicy) check(pds, issuer string) error {
+	if pds != "" && !p.PDSAllowed(pds) {
+		return ErrHostNotAllowed
+	}
+	if issuer != "" && !p.IssuerAllowed(issuer) {
+		return ErrHostNotAllowed
+	}
+	return nil
+}
+
+func hostMatches(host, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasPrefix(pattern, "*.") || strings.HasPrefix(pattern, ".") {
+		suffix := "." + strings.TrimLeft(pattern, "*.")
+		return strings.HasSuffix(host, suffix)
+	}
+	return host == pattern
+}
+
+// PDSAllowed reports whether the client's host policy accepts a PDS URL.
+func (c *OAuthClient) PDSAllowed(rawURL string) bool {
+	return c.policy.PDSAllowed(rawURL)
+}
@@ -0,0 +1,74 @@
+package atproto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Profile holds the public Bluesky profile fields noknok displays.
+type Profile struct {
+	DisplayName string
+	AvatarURL   string
+	Description string
+}
+
+// ProfileClient fetches Bluesky profiles from an AppView (or any server
+// implementing app.bsky.actor.getProfile).
+type ProfileClient struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+}
+
+// NewProfileClient creates a profile client for the given AppView base URL,
+// e.g. "https://public.api.bsky.app".
+func NewProfileClient(baseURL, userAgent string) *ProfileClient {
+	return &ProfileClient{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetTransport replaces the HTTP transport, e.g. to serve profiles from the
+// offline dev auth server.
+func (p *ProfileClient) SetTransport(rt http.RoundTripper) {
+	p.client.Transport = rt
+}
+
+// Fetch returns the profile for a DID.
+func (p *ProfileClient) Fetch(ctx context.Context, did string) (*Profile, error) {
+	u := p.baseURL + "/xrpc/app.bsky.actor.getProfile?actor=" + url.QueryEscape(did)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get profile %s: %w", did, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get profile %s: HTTP %d", did, resp.StatusCode)
+	}
+
+	var body struct {
+		DisplayName string `json:"displayName"`
+		Avatar      string `json:"avatar"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode profile %s: %w", did, err)
+	}
+	return &Profile{
+		DisplayName: body.DisplayName,
+		AvatarURL:   body.Avatar,
+		Description: body.Description,
+	}, nil
+}
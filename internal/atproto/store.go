@@ -4,20 +4,41 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strings"
 
 	"github.com/bluesky-social/indigo/atproto/auth/oauth"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// PgStore implements oauth.ClientAuthStore backed by Postgres JSONB.
+// PgStore implements oauth.ClientAuthStore backed by Postgres JSONB. With an
+// Envelope, session and request blobs are encrypted before they are stored.
 type PgStore struct {
 	pool *pgxpool.Pool
+	env  *Envelope
 }
 
-// NewPgStore creates a Postgres-backed OAuth store.
-func NewPgStore(pool *pgxpool.Pool) *PgStore {
-	return &PgStore{pool: pool}
+// NewPgStore creates a Postgres-backed OAuth store. env may be nil, in which
+// case blobs are stored in plaintext.
+func NewPgStore(pool *pgxpool.Pool, env *Envelope) *PgStore {
+	return &PgStore{pool: pool, env: env}
+}
+
+func sessionAAD(did, sessionID string) string {
+	return "oauth_sessions:" + did + ":" + sessionID
+}
+
+func requestAAD(state string) string {
+	return "oauth_requests:" + state
+}
+
+// seal encrypts a marshalled blob when an Envelope is configured.
+func (s *PgStore) seal(data []byte, aad string) ([]byte, error) {
+	if s.env == nil {
+		return data, nil
+	}
+	return s.env.Seal(data, aad)
 }
 
 func (s *PgStore) GetSession(ctx context.Context, did syntax.DID, sessionID string) (*oauth.ClientSessionData, error) {
@@ -28,6 +49,10 @@ func (s *PgStore) GetSession(ctx context.Context, did syntax.DID, sessionID stri
 	if err != nil {
 		return nil, fmt.Errorf("get oauth session: %w", err)
 	}
+	data, err = s.env.Open(data, sessionAAD(did.String(), sessionID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt oauth session: %w", err)
+	}
 	var sess oauth.ClientSessionData
 	if err := json.Unmarshal(data, &sess); err != nil {
 		return nil, fmt.Errorf("unmarshal oauth session: %w", err)
@@ -40,11 +65,21 @@ func (s *PgStore) SaveSession(ctx context.Context, sess oauth.ClientSessionData)
 	if err != nil {
 		return fmt.Errorf("marshal oauth session: %w", err)
 	}
+	data, err = s.seal(data, sessionAAD(sess.AccountDID.String(), sess.SessionID))
+	if err != nil {
+		return fmt.Errorf("encrypt oauth session: %w", err)
+	}
+	// Non-secret metadata stays readable for the admin view.
+	scopes := sess.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
 	_, err = s.pool.Exec(ctx, `
-		INSERT INTO oauth_sessions (did, session_id, data)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (did, session_id) DO UPDATE SET data = $3
-	`, sess.AccountDID.String(), sess.SessionID, data)
+		INSERT INTO oauth_sessions (did, session_id, data, host_url, authserver_url, scopes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (did, session_id) DO UPDATE SET
+			data = $3, host_url = $4, authserver_url = $5, scopes = $6, updated_at = now()
+	`, sess.AccountDID.String(), sess.SessionID, data, sess.HostURL, sess.AuthServerURL, scopes)
 	return err
 }
 
@@ -63,6 +98,10 @@ func (s *PgStore) GetAuthRequestInfo(ctx context.Context, state string) (*oauth.
 	if err != nil {
 		return nil, fmt.Errorf("get auth request: %w", err)
 	}
+	data, err = s.env.Open(data, requestAAD(state))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt auth request: %w", err)
+	}
 	var info oauth.AuthRequestData
 	if err := json.Unmarshal(data, &info); err != nil {
 		return nil, fmt.Errorf("unmarshal auth request: %w", err)
@@ -75,6 +114,10 @@ func (s *PgStore) SaveAuthRequestInfo(ctx context.Context, info oauth.AuthReques
 	if err != nil {
 		return fmt.Errorf("marshal auth request: %w", err)
 	}
+	data, err = s.seal(data, requestAAD(info.State))
+	if err != nil {
+		return fmt.Errorf("encrypt auth request: %w", err)
+	}
 	_, err = s.pool.Exec(ctx,
 		`INSERT INTO oauth_requests (state, data) VALUES ($1, $2)`,
 		info.State, data)
@@ -86,3 +129,101 @@ func (s *PgStore) DeleteAuthRequestInfo(ctx context.Context, state string) error
 		`DELETE FROM oauth_requests WHERE state = $1`, state)
 	return err
 }
+
+// Reencrypt seals any plaintext rows (including stored OAuth signing keys)
+// and rewraps rows sealed under an older KEK with the current one. It
+// returns the number of rows rewritten.
+func (s *PgStore) Reencrypt(ctx context.Context) (int, error) {
+	if s.env == nil {
+		return 0, ErrNoKEK
+	}
+	n, err := s.rewrapRows(ctx,
+		`SELECT did, session_id, data FROM oauth_sessions`,
+		`UPDATE oauth_sessions SET data = $3 WHERE did = $1 AND session_id = $2 AND data = $4`,
+		func(key []string) string { return sessionAAD(key[0], key[1]) })
+	if err != nil {
+		return n, fmt.Errorf("oauth_sessions: %w", err)
+	}
+	m, err := s.rewrapRows(ctx,
+		`SELECT state, data FROM oauth_requests`,
+		`UPDATE oauth_requests SET data = $2 WHERE state = $1 AND data = $3`,
+		func(key []string) string { return requestAAD(key[0]) })
+	if err != nil {
+		return n + m, fmt.Errorf("oauth_requests: %w", err)
+	}
+	k, err := s.rewrapRows(ctx,
+		`SELECT kid, convert_to(private_key, 'UTF8') FROM oauth_keys WHERE private_key <> ''`,
+		`UPDATE oauth_keys SET private_key = convert_from($2, 'UTF8') WHERE kid = $1 AND private_key = convert_from($3, 'UTF8')`,
+		func(key []string) string { return signingKeyAAD(key[0]) })
+	if err != nil {
+		return n + m + k, fmt.Errorf("oauth_keys: %w", err)
+	}
+	return n + m + k, nil
+}
+
+// rewrapRows runs Rewrap over rows from query, which selects the key columns
+// followed by data, and writes changed blobs back with update, whose
+// parameters are the key columns, the new blob and the blob that was read.
+// The last condition makes the write a no-op if a running instance saved the
+// row in the meantime (e.g. after a token refresh); that row is already
+// sealed under the current KEK.
+func (s *PgStore) rewrapRows(ctx context.Context, query, update string, aad func(key []string) string) (int, error) {
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	nkeys := len(rows.FieldDescriptions()) - 1
+	keys, blobs := [][]string{}, [][]byte{}
+	for rows.Next() {
+		key := make([]string, nkeys)
+		var data []byte
+		dest := make([]any, 0, nkeys+1)
+		for i := range key {
+			dest = append(dest, &key[i])
+		}
+		if err := rows.Scan(append(dest, &data)...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys, blobs = append(keys, key), append(blobs, data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i, key := range keys {
+		out, changed, err := s.env.Rewrap(blobs[i], aad(key))
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", strings.Join(key, " "), err)
+		}
+		if !changed {
+			continue
+		}
+		args := make([]any, 0, len(key)+2)
+		for _, k := range key {
+			args = append(args, k)
+		}
+		tag, err := s.pool.Exec(ctx, update, append(args, out, blobs[i])...)
+		if err != nil {
+			return n, err
+		}
+		if tag.RowsAffected() == 0 {
+			slog.Info("store reencrypt: row changed concurrently, skipped", "key", strings.Join(key, " "))
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// identityOnlyStore wraps a store and drops OAuth session data, so access and
+// refresh tokens are never persisted. Auth request state is still stored.
+type identityOnlyStore struct {
+	oauth.ClientAuthStore
+}
+
+func (identityOnlyStore) SaveSession(ctx context.Context, sess oauth.ClientSessionData) error {
+	return nil
+}
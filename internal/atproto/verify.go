@@ -0,0 +1,144 @@
+package atproto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// ErrAccountGone is returned by VerifyAccount when the DID no longer
+// resolves or the account's PDS no longer hosts the repository.
+var ErrAccountGone = errors.New("account no longer exists")
+
+// AccountStatus is the result of re-resolving an account's identity.
+type AccountStatus struct {
+	DID    string
+	Handle string // empty if the handle no longer verifies
+	PDS    string
+	Active bool
+	Status string // reason when inactive, e.g. "deactivated" or "takendown"
+}
+
+// VerifyAccount re-resolves the DID document for did, bypassing the identity
+// cache, and asks the account's PDS whether the repository is still active.
+// Transient resolution failures are returned as plain errors so callers can
+// tell them apart from ErrAccountGone.
+func (c *OAuthClient) VerifyAccount(ctx context.Context, did string) (*AccountStatus, error) {
+	d, err := syntax.ParseDID(did)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DID: %w", err)
+	}
+	_ = c.app.Dir.Purge(ctx, d.AtIdentifier())
+
+	ident, err := c.app.Dir.LookupDID(ctx, d)
+	if err != nil {
+		if errors.Is(err, identity.ErrDIDNotFound) {
+			return nil, ErrAccountGone
+		}
+		return nil, fmt.Errorf("resolve %s: %w", did, err)
+	}
+
+	st := &AccountStatus{DID: did, PDS: ident.PDSEndpoint()}
+	if !ident.Handle.IsInvalidHandle() {
+		st.Handle = ident.Handle.String()
+	}
+	if st.PDS == "" {
+		return nil, ErrAccountGone
+	}
+
+	st.Active, st.Status, err = c.repoStatus(ctx, st.PDS, did)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// repoStatus calls com.atproto.sync.getRepoStatus on the account's PDS.
+func (c *OAuthClient) repoStatus(ctx context.Context, pds, did string) (bool, string, error) {
+	u := strings.TrimRight(pds, "/") + "/xrpc/com.atproto.sync.getRepoStatus?did=" + url.QueryEscape(did)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	resp, err := c.app.Client.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("repo status %s: %w", did, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Active bool   `json:"active"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, "", fmt.Errorf("repo status %s: HTTP %d", did, resp.StatusCode)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return body.Active, body.Status, nil
+	case body.Error == "RepoNotFound":
+		return false, "", ErrAccountGone
+	case body.Error == "RepoDeactivated":
+		return false, "deactivated", nil
+	case body.Error == "RepoTakendown":
+		return false, "takendown", nil
+	}
+	return false, "", fmt.Errorf("repo status %s: HTTP %d %s", did, resp.StatusCode, body.Error)
+}
+
+// RefreshSession refreshes the tokens of a stored OAuth session when its
+// access token expires within the given window, and persists the result.
+// Other sessions are only checked to still load, which costs no request to
+// the auth server. It reports whether the tokens were refreshed.
+func (c *OAuthClient) RefreshSession(ctx context.Context, did, sessionID string, within time.Duration) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+	d, err := syntax.ParseDID(did)
+	if err != nil {
+		return false, fmt.Errorf("invalid DID: %w", err)
+	}
+	sess, err := c.app.ResumeSession(ctx, d, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("resume oauth session: %w", err)
+	}
+	if exp, ok := tokenExpiry(sess.Data.AccessToken); ok && time.Until(exp) > within {
+		return false, nil
+	}
+	if _, err := sess.RefreshTokens(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// tokenExpiry reads the exp claim of a JWT access token without verifying
+// it. Opaque tokens report false, and are refreshed as if expiring.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
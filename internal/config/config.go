@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -18,13 +21,73 @@ type Config struct {
 	DBPassword string
 	DBSSLMode  string
 	ListenAddr string
+	ExtAuthzAddr string // Envoy ext_authz gRPC listen address (empty = disabled)
+	ProxyAddr    string // built-in reverse proxy listen address (empty = disabled)
+	ProxyTLSCert string // PEM certificate for the proxy listener (empty = plain HTTP)
+	ProxyTLSKey  string
 
-	OAuthPrivateKey string // multibase-encoded ES256 private key
+	OAuthPrivateKey string // multibase-encoded ES256 private key (only its public key is stored)
+	OAuthKeyGrace   string // duration string; how long rotated keys stay published
 	SessionTTL      string // duration string, e.g. "24h"
+	SessionHashKey  string // HMAC key for session tokens and app passwords stored in the database (required unless DevMode)
+	SessionBinding  string   // off, allow, stepup or revoke when a bound client changes
+	SessionBindingFields []string // binding fields whose change triggers SessionBinding
+	OAuthStateTTL   string // duration string; lifetime of pending OAuth requests
+	OAuthStoreTokens bool  // keep atproto tokens after login (false = identity only)
+	OAuthStoreKEK   string   // base64 32-byte key encrypting stored OAuth data (empty = plaintext)
+	OAuthStoreKEKPrevious []string // retired KEKs still accepted for reading
+	VerifyInterval  string // duration string; "0" disables account re-verification
+	ProfileAPIURL   string // AppView serving app.bsky.actor.getProfile
+	ForwardUserName bool   // add X-User-Name (profile display name) in forwardAuth responses
+	OAuthHostAllow  []string // allowed authorization server (issuer) hosts (empty = any); PDS hosts are not checked
+	OAuthHostDeny   []string // denied issuer and PDS hosts; wins over OAuthHostAllow
+	ClientName      string   // client_name shown on the consent screen
+	ClientURI       string   // client_uri (defaults to PublicURL)
+	ClientLogoURI   string   // logo_uri (https)
+	ClientTOSURI    string   // tos_uri (https)
+	ClientPolicyURI string   // policy_uri (https)
+	DevMode         bool     // use an atproto loopback OAuth client (no key, no public URL)
+	DevCallbackURL  string   // loopback redirect URI used in DevMode
+	DevFakeATProto  bool     // serve an offline fake PLC/PDS/auth server (see internal/devauth)
+	DevIdentities   []string // test accounts for DevFakeATProto ("name" or "handle=did")
+	DockerDiscovery bool     // sync services from noknok.* container labels
+	DockerSocket    string   // Docker Engine API unix socket
+	DiscoveryInterval string // duration string; how often to poll Docker
+	TraefikProvider      bool     // serve /traefik/config for Traefik's HTTP provider
+	TraefikProviderToken string   // bearer token required on /traefik/config (optional)
+	TraefikConfigFile    string   // write the same config here as YAML for the file provider
+	TraefikEntryPoints   []string // entry points for generated routers
+	TraefikCertResolver  string   // certResolver for generated routers (empty = no TLS)
+	TraefikAuthAddress   string   // forwardAuth address Traefik uses to reach noknok (required with generation; also recognised by the audit)
+	TraefikTrustForward  bool     // set trustForwardHeader on the generated forwardAuth middleware
+	TraefikAPIURL        string   // Traefik API base URL for the router audit (empty = disabled)
+	TrustedProxies     []netip.Prefix // forward-auth callers allowed by source address (empty = any)
+	TrustedProxySecret string         // shared secret forward-auth callers must send (empty = none; Traefik cannot, so /auth relies on TrustedProxies)
+	TrustedProxyHeader string         // header (or gRPC metadata key) carrying the secret
+	NotifySMTPAddr     string   // SMTP server host:port for security notifications (empty = disabled)
+	NotifySMTPFrom     string
+	NotifySMTPTo       []string // recipients of notification mail
+	NotifySMTPUsername string
+	NotifySMTPPassword string
+	WebhookAllowPrivate bool // allow webhook URLs on loopback or private networks
+	SCIMToken          string // bearer token for the SCIM API (empty = disabled)
+	SCIMActorDID       string // DID of the user SCIM changes are made as; their role applies
+	LDAPAddr           string // read-only LDAP listen address (empty = disabled)
+	LDAPBaseDN         string
+	LDAPTLSCert        string // PEM certificate; when set the listener speaks LDAPS
+	LDAPTLSKey         string
+	LDAPAllowAnonymous bool   // allow searches without a bind
 	OwnerDID       string
 	OwnerUsername  string
 	CookieDomain   string   // primary cookie domain (first entry)
 	CookieDomains  []string // all cookie domains (parsed from COOKIE_DOMAINS)
+	CookieName         string                   // base session cookie name
+	CookieSecure       bool                     // Secure attribute (default: PublicURL is https)
+	CookieSecurePrefix bool                     // name the shared cookie "__Secure-<name>"
+	CookieSameSite     http.SameSite            // default SameSite mode
+	CookieSameSiteByDomain map[string]http.SameSite // per-domain overrides from COOKIE_SAMESITE
+	CookiePartitioned  bool                     // Partitioned (CHIPS) attribute
+	PortalHostCookie   bool                     // separate host-only "__Host-" cookie on the portal host
 	PublicURL      string
 }
 
@@ -38,11 +101,77 @@ func Load() (*Config, error) {
 		DBUser:       envOrDefault("DB_USER", "dba_noknok"),
 		DBSSLMode:    envOrDefault("DB_SSLMODE", "disable"),
 		ListenAddr:   envOrDefault("LISTEN_ADDR", ":4321"),
+		ExtAuthzAddr: os.Getenv("EXTAUTHZ_GRPC_ADDR"),
+		ProxyAddr:    os.Getenv("PROXY_ADDR"),
+		ProxyTLSCert: os.Getenv("PROXY_TLS_CERT"),
+		ProxyTLSKey:  os.Getenv("PROXY_TLS_KEY"),
 		SessionTTL:   envOrDefault("SESSION_TTL", "24h"),
+		OAuthStateTTL: envOrDefault("OAUTH_STATE_TTL", "30m"),
+		OAuthKeyGrace: envOrDefault("OAUTH_KEY_GRACE", "168h"),
+		OAuthStoreTokens: envBool("OAUTH_STORE_TOKENS", true),
+		VerifyInterval: envOrDefault("VERIFY_INTERVAL", "1h"),
+		ProfileAPIURL:  envOrDefault("PROFILE_API_URL", "https://public.api.bsky.app"),
+		ForwardUserName: envBool("FORWARD_USER_NAME", false),
 		OwnerDID:      os.Getenv("OWNER_DID"),
 		OwnerUsername: envOrDefault("OWNER_USERNAME", ""),
 		CookieDomain: envOrDefault("COOKIE_DOMAIN", ".localhost"),
 		PublicURL:     envOrDefault("PUBLIC_URL", "http://noknok.localhost"),
+		OAuthHostAllow: envList("OAUTH_HOST_ALLOW"),
+		OAuthHostDeny:  envList("OAUTH_HOST_DENY"),
+		ClientName:      envOrDefault("OAUTH_CLIENT_NAME", "noknok"),
+		ClientURI:       os.Getenv("OAUTH_CLIENT_URI"),
+		ClientLogoURI:   os.Getenv("OAUTH_LOGO_URI"),
+		ClientTOSURI:    os.Getenv("OAUTH_TOS_URI"),
+		ClientPolicyURI: os.Getenv("OAUTH_POLICY_URI"),
+	}
+	if c.ClientURI == "" {
+		c.ClientURI = c.PublicURL
+	}
+	c.DevMode = envBool("DEV_MODE", false)
+	c.DevCallbackURL = os.Getenv("DEV_CALLBACK_URL")
+	c.DevFakeATProto = envBool("DEV_FAKE_ATPROTO", false)
+	c.DevIdentities = envList("DEV_IDENTITIES")
+	c.DockerDiscovery = envBool("DOCKER_DISCOVERY", false)
+	c.DockerSocket = envOrDefault("DOCKER_SOCKET", "/var/run/docker.sock")
+	c.DiscoveryInterval = envOrDefault("DISCOVERY_INTERVAL", "30s")
+	c.TraefikProvider = envBool("TRAEFIK_PROVIDER", false)
+	c.TraefikConfigFile = os.Getenv("TRAEFIK_CONFIG_FILE")
+	c.TraefikEntryPoints = envList("TRAEFIK_ENTRYPOINTS")
+	if len(c.TraefikEntryPoints) == 0 {
+		c.TraefikEntryPoints = []string{"https"}
+	}
+	c.TraefikCertResolver = envOrDefault("TRAEFIK_CERT_RESOLVER", "letsencrypt")
+	c.TraefikAuthAddress = os.Getenv("TRAEFIK_AUTH_ADDRESS")
+	c.TraefikTrustForward = envBool("TRAEFIK_TRUST_FORWARD_HEADER", false)
+	c.TraefikAPIURL = os.Getenv("TRAEFIK_API_URL")
+	c.NotifySMTPAddr = os.Getenv("NOTIFY_SMTP_ADDR")
+	c.NotifySMTPFrom = os.Getenv("NOTIFY_SMTP_FROM")
+	c.NotifySMTPTo = envList("NOTIFY_SMTP_TO")
+	c.NotifySMTPUsername = os.Getenv("NOTIFY_SMTP_USERNAME")
+	c.WebhookAllowPrivate = envBool("WEBHOOK_ALLOW_PRIVATE", false)
+	c.SCIMActorDID = os.Getenv("SCIM_ACTOR_DID")
+	c.LDAPAddr = os.Getenv("LDAP_ADDR")
+	c.LDAPBaseDN = envOrDefault("LDAP_BASE_DN", "dc=noknok")
+	c.LDAPTLSCert = os.Getenv("LDAP_TLS_CERT")
+	c.LDAPTLSKey = os.Getenv("LDAP_TLS_KEY")
+	c.LDAPAllowAnonymous = envBool("LDAP_ALLOW_ANONYMOUS", false)
+	c.TrustedProxyHeader = envOrDefault("TRUSTED_PROXY_HEADER", "X-Noknok-Proxy-Secret")
+	for _, v := range envList("TRUSTED_PROXIES") {
+		p, err := parsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+		c.TrustedProxies = append(c.TrustedProxies, p)
+	}
+	if len(c.DevIdentities) == 0 {
+		c.DevIdentities = []string{"alice", "bob", "carol"}
+	}
+	if c.DevCallbackURL == "" {
+		port := "80"
+		if idx := strings.LastIndex(c.ListenAddr, ":"); idx != -1 {
+			port = c.ListenAddr[idx+1:]
+		}
+		c.DevCallbackURL = "http://127.0.0.1:" + port + "/oauth/callback"
 	}
 
 	// Parse COOKIE_DOMAINS (comma-separated). Falls back to single CookieDomain.
@@ -60,24 +189,146 @@ func Load() (*Config, error) {
 		c.CookieDomains = []string{c.CookieDomain}
 	}
 
+	c.SessionBinding = envOrDefault("SESSION_BINDING", "off")
+	c.SessionBindingFields = envList("SESSION_BINDING_FIELDS")
+	if len(c.SessionBindingFields) == 0 {
+		c.SessionBindingFields = []string{"ua", "hints"}
+	}
+	for _, f := range c.SessionBindingFields {
+		if f != "ip" && f != "ua" && f != "hints" {
+			return nil, fmt.Errorf("SESSION_BINDING_FIELDS: unknown field %q (want ip, ua or hints)", f)
+		}
+	}
+	c.CookieName = envOrDefault("SESSION_COOKIE_NAME", "noknok_session")
+	c.CookieSecure = envBool("COOKIE_SECURE", strings.HasPrefix(c.PublicURL, "https://"))
+	c.CookieSecurePrefix = envBool("COOKIE_SECURE_PREFIX", false)
+	c.CookiePartitioned = envBool("COOKIE_PARTITIONED", false)
+	c.PortalHostCookie = envBool("PORTAL_HOST_COOKIE", false)
+	// COOKIE_SAMESITE is a default mode plus optional per-domain overrides,
+	// e.g. "lax,.ker.ai=none".
+	c.CookieSameSite = http.SameSiteLaxMode
+	c.CookieSameSiteByDomain = map[string]http.SameSite{}
+	for _, v := range envList("COOKIE_SAMESITE") {
+		domain, mode, scoped := strings.Cut(v, "=")
+		if !scoped {
+			mode = v
+		}
+		ss, err := parseSameSite(mode)
+		if err != nil {
+			return nil, fmt.Errorf("COOKIE_SAMESITE: %w", err)
+		}
+		if scoped {
+			c.CookieSameSiteByDomain[strings.TrimSpace(domain)] = ss
+		} else {
+			c.CookieSameSite = ss
+		}
+	}
+	if !c.CookieSecure {
+		if c.CookieSecurePrefix {
+			return nil, fmt.Errorf("COOKIE_SECURE_PREFIX requires secure cookies")
+		}
+		if c.CookiePartitioned {
+			return nil, fmt.Errorf("COOKIE_PARTITIONED requires secure cookies")
+		}
+		if c.CookieSameSite == http.SameSiteNoneMode {
+			return nil, fmt.Errorf("COOKIE_SAMESITE=none requires secure cookies")
+		}
+		for d, ss := range c.CookieSameSiteByDomain {
+			if ss == http.SameSiteNoneMode {
+				return nil, fmt.Errorf("COOKIE_SAMESITE: %s=none requires secure cookies", d)
+			}
+		}
+	}
+
 	pw, err := envOrFile("DB_PASSWORD")
 	if err != nil {
 		return nil, fmt.Errorf("DB_PASSWORD: %w", err)
 	}
 	c.DBPassword = pw
 
+	kek, err := envOrFile("OAUTH_STORE_KEK")
+	if err != nil {
+		return nil, fmt.Errorf("OAUTH_STORE_KEK: %w", err)
+	}
+	c.OAuthStoreKEK = kek
+	prevKEK, err := envOrFile("OAUTH_STORE_KEK_PREVIOUS")
+	if err != nil {
+		return nil, fmt.Errorf("OAUTH_STORE_KEK_PREVIOUS: %w", err)
+	}
+	for _, k := range strings.Split(prevKEK, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			c.OAuthStoreKEKPrevious = append(c.OAuthStoreKEKPrevious, k)
+		}
+	}
+
+	hashKey, err := envOrFile("SESSION_HASH_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("SESSION_HASH_KEY: %w", err)
+	}
+	c.SessionHashKey = hashKey
+	// Tokens and app passwords are stored as HMACs under this key, so a copy
+	// of the database alone cannot be used to sign in.
+	if c.SessionHashKey == "" && !c.DevMode {
+		return nil, fmt.Errorf("SESSION_HASH_KEY is required")
+	}
+
+	proxySecret, err := envOrFile("TRUSTED_PROXY_SECRET")
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXY_SECRET: %w", err)
+	}
+	c.TrustedProxySecret = proxySecret
+
+	token, err := envOrFile("TRAEFIK_PROVIDER_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("TRAEFIK_PROVIDER_TOKEN: %w", err)
+	}
+	c.TraefikProviderToken = token
+	// The public URL would send every auth call back through the public
+	// entrypoint; Traefik must reach noknok directly.
+	if (c.TraefikProvider || c.TraefikConfigFile != "") && c.TraefikAuthAddress == "" {
+		return nil, fmt.Errorf("TRAEFIK_AUTH_ADDRESS is required with TRAEFIK_PROVIDER or TRAEFIK_CONFIG_FILE (e.g. http://noknok:4321/auth)")
+	}
+
+	smtpPassword, err := envOrFile("NOTIFY_SMTP_PASSWORD")
+	if err != nil {
+		return nil, fmt.Errorf("NOTIFY_SMTP_PASSWORD: %w", err)
+	}
+	c.NotifySMTPPassword = smtpPassword
+	if c.NotifySMTPAddr != "" && (c.NotifySMTPFrom == "" || len(c.NotifySMTPTo) == 0) {
+		return nil, fmt.Errorf("NOTIFY_SMTP_ADDR requires NOTIFY_SMTP_FROM and NOTIFY_SMTP_TO")
+	}
+
+	scimToken, err := envOrFile("SCIM_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("SCIM_TOKEN: %w", err)
+	}
+	c.SCIMToken = scimToken
+	if c.SCIMToken != "" && c.SCIMActorDID == "" {
+		return nil, fmt.Errorf("SCIM_TOKEN requires SCIM_ACTOR_DID")
+	}
+
 	oauthKey, err := envOrFile("OAUTH_KEY")
 	if err != nil {
 		return nil, fmt.Errorf("OAUTH_KEY: %w", err)
 	}
 	c.OAuthPrivateKey = oauthKey
 
+	if (c.ProxyTLSCert == "") != (c.ProxyTLSKey == "") {
+		return nil, fmt.Errorf("PROXY_TLS_CERT and PROXY_TLS_KEY must be set together")
+	}
+	if (c.LDAPTLSCert == "") != (c.LDAPTLSKey == "") {
+		return nil, fmt.Errorf("LDAP_TLS_CERT and LDAP_TLS_KEY must be set together")
+	}
+
 	if c.OwnerDID == "" {
 		return nil, fmt.Errorf("OWNER_DID is required")
 	}
 
-	if c.OAuthPrivateKey == "" {
-		return nil, fmt.Errorf("OAUTH_KEY is required")
+	// The fake atproto server lets anyone pick any identity, including one
+	// mapped to OWNER_DID, so it must never run on a reachable deployment,
+	// whatever DEV_MODE says.
+	if c.DevFakeATProto && !isLocalURL(c.PublicURL) {
+		return nil, fmt.Errorf("DEV_FAKE_ATPROTO requires a loopback, .localhost or .test PUBLIC_URL")
 	}
 
 	return c, nil
@@ -113,6 +364,21 @@ func (c *Config) IsExternalHost(host string) bool {
 	return c.DomainForHost(host) != c.CookieDomain
 }
 
+// isLocalURL reports whether u points at a loopback address or a
+// .localhost or .test host, which cannot be reached from the internet.
+func isLocalURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || host == "test" || strings.HasSuffix(host, ".test") {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
 func envOrDefault(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -120,6 +386,52 @@ func envOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// envList splits a comma-separated env var, dropping empty entries.
+func envList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// parsePrefix parses a CIDR, or a bare IP as a single-address prefix.
+func parsePrefix(v string) (netip.Prefix, error) {
+	if strings.Contains(v, "/") {
+		p, err := netip.ParsePrefix(v)
+		return p.Masked(), err
+	}
+	ip, err := netip.ParseAddr(v)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// parseSameSite parses "lax", "strict" or "none".
+func parseSameSite(v string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite mode %q", v)
+}
+
+// envBool parses a boolean env var, returning fallback if unset or invalid.
+func envBool(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
 // envOrFile reads a value from env var KEY, or from a file at KEY_FILE.
 func envOrFile(key string) (string, error) {
 	if v := os.Getenv(key); v != "" {
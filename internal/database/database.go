@@ -145,6 +145,7 @@ func (db *DB) SeedServices(ctx context.Context, path string) error {
 		URL         string `json:"url"`
 		IconURL     string `json:"icon_url"`
 		AdminRole   string `json:"admin_role"`
+		UpstreamURL string `json:"upstream_url"`
 	}
 	if err := json.Unmarshal(data, &svcs); err != nil {
 		return fmt.Errorf("parse %s: %w", path, err)
@@ -155,15 +156,16 @@ func (db *DB) SeedServices(ctx context.Context, path string) error {
 			s.AdminRole = "admin"
 		}
 		_, err := db.Pool.Exec(ctx, `
-			INSERT INTO services (slug, name, description, url, icon_url, admin_role)
-			VALUES ($1, $2, $3, $4, $5, $6)
+			INSERT INTO services (slug, name, description, url, icon_url, admin_role, upstream_url)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
 			ON CONFLICT (slug) DO UPDATE SET
 				name = EXCLUDED.name,
 				description = EXCLUDED.description,
 				url = EXCLUDED.url,
 				icon_url = EXCLUDED.icon_url,
-				admin_role = EXCLUDED.admin_role`,
-			s.Slug, s.Name, s.Description, s.URL, s.IconURL, s.AdminRole)
+				admin_role = EXCLUDED.admin_role,
+				upstream_url = EXCLUDED.upstream_url`,
+			s.Slug, s.Name, s.Description, s.URL, s.IconURL, s.AdminRole, s.UpstreamURL)
 		if err != nil {
 			return fmt.Errorf("seed service %s: %w", s.Slug, err)
 		}
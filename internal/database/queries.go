@@ -2,29 +2,45 @@ package database
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // User represents a row in the users table.
 // DID and Handle are populated from the primary identity via JOINs.
 type User struct {
-	ID        int64     `json:"id"`
-	DID       string    `json:"did"`
-	Handle    string    `json:"handle"`
-	Username  string    `json:"username"`
-	Role      string    `json:"role"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          int64     `json:"id"`
+	DID         string    `json:"did"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	PDSURL      string    `json:"pds_url"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 // Identity represents a row in the user_identities table.
+// DisplayName, AvatarURL and Description are cached from the Bluesky profile.
+// PDSURL is the PDS the DID resolved to at last login or verification.
 type Identity struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	DID       string    `json:"did"`
-	Handle    string    `json:"handle"`
-	IsPrimary bool      `json:"is_primary"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	DID         string    `json:"did"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	Description string    `json:"description"`
+	PDSURL      string    `json:"pds_url"`
+	IsPrimary   bool      `json:"is_primary"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // Service represents a row in the services table.
@@ -38,6 +54,7 @@ type Service struct {
 	AdminRole   string    `json:"admin_role"`
 	Enabled     bool      `json:"enabled"`
 	Public      bool      `json:"public"`
+	UpstreamURL string    `json:"upstream_url"` // backend for the built-in proxy
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -53,11 +70,108 @@ type Grant struct {
 	ServiceName string    `json:"service_name,omitempty"`
 }
 
+// OAuthSession summarizes a row in the oauth_sessions table. Tokens and keys
+// are never exposed; Linked reports whether a live session still uses it.
+type OAuthSession struct {
+	DID           string    `json:"did"`
+	Handle        string    `json:"handle"`
+	SessionID     string    `json:"session_id"`
+	HostURL       string    `json:"host_url"`
+	AuthServerURL string    `json:"authserver_url"`
+	Scopes        []string  `json:"scopes"`
+	Linked        bool      `json:"linked"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// OAuthKey represents a row in the oauth_keys table. RetireAt is nil for the
+// active key; retiring keys stay published in the JWKS until RetireAt.
+// PrivateKey is sealed with the OAuth store envelope, or empty for the key
+// configured in OAUTH_KEY, whose private half is never stored.
+type OAuthKey struct {
+	KID        string     `json:"kid"`
+	PublicKey  string     `json:"public_key"`
+	PrivateKey string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RetireAt   *time.Time `json:"retire_at"`
+}
+
+// AuditEntry represents a row in the audit_log table.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Action    string          `json:"action"`
+	DID       string          `json:"did"`
+	SessionID *int64          `json:"session_id"`
+	Detail    json.RawMessage `json:"detail"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Notification represents a row in the notifications table (a user's
+// in-portal inbox).
+type Notification struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"user_id"`
+	Kind      string          `json:"kind"`
+	Title     string          `json:"title"`
+	Body      string          `json:"body"`
+	Detail    json.RawMessage `json:"detail"`
+	ReadAt    *time.Time      `json:"read_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Webhook represents a row in the webhooks table. Events filters which event
+// types are delivered ("user.created", "user.*", "*"); empty means all.
+type Webhook struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Secret      string    `json:"-"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookDelivery represents a row in the webhook_deliveries table: one
+// event queued for one webhook, and the outcome of its latest attempt.
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	WebhookID     int64           `json:"webhook_id"`
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"` // pending, delivered, failed
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastStatus    int             `json:"last_status"`
+	LastError     string          `json:"last_error"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at"`
+}
+
+// AppPassword represents a row in the app_passwords table: a generated
+// password a user binds with over LDAP. Only its hash is stored.
+type AppPassword struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// WebhookJob is a claimed delivery together with its target.
+type WebhookJob struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
 // --- Users ---
 
 func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
 	rows, err := db.Pool.Query(ctx, `
 		SELECT u.id, COALESCE(pi.did, ''), COALESCE(pi.handle, ''),
+		       COALESCE(pi.display_name, ''), COALESCE(pi.avatar_url, ''), COALESCE(pi.pds_url, ''),
 		       u.username, u.role, u.created_at, u.updated_at
 		FROM users u
 		LEFT JOIN user_identities pi ON pi.user_id = u.id AND pi.is_primary = true
@@ -70,7 +184,7 @@ func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.DID, &u.Handle, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.DID, &u.Handle, &u.DisplayName, &u.AvatarURL, &u.PDSURL, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -78,15 +192,49 @@ func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
 	return users, rows.Err()
 }
 
+// GetUser returns a user with their primary identity.
+func (db *DB) GetUser(ctx context.Context, id int64) (*User, error) {
+	var u User
+	err := db.Pool.QueryRow(ctx, `
+		SELECT u.id, COALESCE(pi.did, ''), COALESCE(pi.handle, ''),
+		       COALESCE(pi.display_name, ''), COALESCE(pi.avatar_url, ''), COALESCE(pi.pds_url, ''),
+		       u.username, u.role, u.created_at, u.updated_at
+		FROM users u
+		LEFT JOIN user_identities pi ON pi.user_id = u.id AND pi.is_primary = true
+		WHERE u.id = $1`, id).
+		Scan(&u.ID, &u.DID, &u.Handle, &u.DisplayName, &u.AvatarURL, &u.PDSURL, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
 // GetUserByIdentityDID finds a user by any of their linked DIDs.
 func (db *DB) GetUserByIdentityDID(ctx context.Context, did string) (*User, error) {
 	var u User
 	err := db.Pool.QueryRow(ctx, `
-		SELECT u.id, ui.did, ui.handle, u.username, u.role, u.created_at, u.updated_at
+		SELECT u.id, ui.did, ui.handle, ui.display_name, ui.avatar_url, ui.pds_url, u.username, u.role, u.created_at, u.updated_at
 		FROM users u
 		JOIN user_identities ui ON ui.user_id = u.id
 		WHERE ui.did = $1`, did).
-		Scan(&u.ID, &u.DID, &u.Handle, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+		Scan(&u.ID, &u.DID, &u.Handle, &u.DisplayName, &u.AvatarURL, &u.PDSURL, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUserByUsername returns a user by their (non-empty) username.
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	var u User
+	err := db.Pool.QueryRow(ctx, `
+		SELECT u.id, COALESCE(pi.did, ''), COALESCE(pi.handle, ''),
+		       COALESCE(pi.display_name, ''), COALESCE(pi.avatar_url, ''), COALESCE(pi.pds_url, ''),
+		       u.username, u.role, u.created_at, u.updated_at
+		FROM users u
+		LEFT JOIN user_identities pi ON pi.user_id = u.id AND pi.is_primary = true
+		WHERE u.username = $1 AND u.username != ''`, username).
+		Scan(&u.ID, &u.DID, &u.Handle, &u.DisplayName, &u.AvatarURL, &u.PDSURL, &u.Username, &u.Role, &u.CreatedAt, &u.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -126,6 +274,27 @@ func (db *DB) UpdateUserUsername(ctx context.Context, id int64, username string)
 	return err
 }
 
+// UpdateUserProfile sets a user's username and role in one transaction,
+// propagating the username to active sessions.
+func (db *DB) UpdateUserProfile(ctx context.Context, id int64, username, role string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE users SET username = $1, role = $2, updated_at = now() WHERE id = $3`, username, role, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE sessions SET username = $1
+		WHERE user_id = $2 AND expires_at > now()`, username, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
 func (db *DB) DeleteUser(ctx context.Context, id int64) error {
 	_, err := db.Pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
 	return err
@@ -145,9 +314,9 @@ func (db *DB) AddIdentity(ctx context.Context, userID int64, did, handle string,
 	err := db.Pool.QueryRow(ctx, `
 		INSERT INTO user_identities (user_id, did, handle, is_primary)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, user_id, did, handle, is_primary, created_at`,
+		RETURNING id, user_id, did, handle, display_name, avatar_url, description, pds_url, is_primary, created_at`,
 		userID, did, handle, isPrimary).
-		Scan(&id.ID, &id.UserID, &id.DID, &id.Handle, &id.IsPrimary, &id.CreatedAt)
+		Scan(&id.ID, &id.UserID, &id.DID, &id.Handle, &id.DisplayName, &id.AvatarURL, &id.Description, &id.PDSURL, &id.IsPrimary, &id.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -156,7 +325,7 @@ func (db *DB) AddIdentity(ctx context.Context, userID int64, did, handle string,
 
 func (db *DB) ListIdentities(ctx context.Context, userID int64) ([]Identity, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT id, user_id, did, handle, is_primary, created_at
+		SELECT id, user_id, did, handle, display_name, avatar_url, description, pds_url, is_primary, created_at
 		FROM user_identities WHERE user_id = $1
 		ORDER BY is_primary DESC, created_at`, userID)
 	if err != nil {
@@ -167,7 +336,30 @@ func (db *DB) ListIdentities(ctx context.Context, userID int64) ([]Identity, err
 	var ids []Identity
 	for rows.Next() {
 		var id Identity
-		if err := rows.Scan(&id.ID, &id.UserID, &id.DID, &id.Handle, &id.IsPrimary, &id.CreatedAt); err != nil {
+		if err := rows.Scan(&id.ID, &id.UserID, &id.DID, &id.Handle, &id.DisplayName, &id.AvatarURL, &id.Description, &id.PDSURL, &id.IsPrimary, &id.CreatedAt); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListAllIdentities returns every identity, grouped by user with the
+// primary one first.
+func (db *DB) ListAllIdentities(ctx context.Context) ([]Identity, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, did, handle, display_name, avatar_url, description, pds_url, is_primary, created_at
+		FROM user_identities
+		ORDER BY user_id, is_primary DESC, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []Identity
+	for rows.Next() {
+		var id Identity
+		if err := rows.Scan(&id.ID, &id.UserID, &id.DID, &id.Handle, &id.DisplayName, &id.AvatarURL, &id.Description, &id.PDSURL, &id.IsPrimary, &id.CreatedAt); err != nil {
 			return nil, err
 		}
 		ids = append(ids, id)
@@ -175,16 +367,577 @@ func (db *DB) ListIdentities(ctx context.Context, userID int64) ([]Identity, err
 	return ids, rows.Err()
 }
 
+// ListIdentitiesByDID returns the identities for the given DIDs, keyed by DID.
+func (db *DB) ListIdentitiesByDID(ctx context.Context, dids []string) (map[string]Identity, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, did, handle, display_name, avatar_url, description, pds_url, is_primary, created_at
+		FROM user_identities WHERE did = ANY($1)`, dids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]Identity, len(dids))
+	for rows.Next() {
+		var id Identity
+		if err := rows.Scan(&id.ID, &id.UserID, &id.DID, &id.Handle, &id.DisplayName, &id.AvatarURL, &id.Description, &id.PDSURL, &id.IsPrimary, &id.CreatedAt); err != nil {
+			return nil, err
+		}
+		ids[id.DID] = id
+	}
+	return ids, rows.Err()
+}
+
+// UpdateIdentityProfile caches Bluesky profile fields for a DID and
+// propagates the display name to active sessions.
+func (db *DB) UpdateIdentityProfile(ctx context.Context, did, displayName, avatarURL, description string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE user_identities
+		SET display_name = $2, avatar_url = $3, description = $4, profile_updated_at = now()
+		WHERE did = $1`, did, displayName, avatarURL, description)
+	if err != nil {
+		return err
+	}
+	_, err = db.Pool.Exec(ctx, `
+		UPDATE sessions SET display_name = $2
+		WHERE did = $1 AND expires_at > now()`, did, displayName)
+	return err
+}
+
+// UpdateIdentityPDS records the PDS that currently hosts a DID.
+func (db *DB) UpdateIdentityPDS(ctx context.Context, did, pdsURL string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE user_identities SET pds_url = $2
+		WHERE did = $1 AND pds_url != $2`, did, pdsURL)
+	return err
+}
+
 func (db *DB) RemoveIdentity(ctx context.Context, identityID int64) error {
 	_, err := db.Pool.Exec(ctx, `DELETE FROM user_identities WHERE id = $1`, identityID)
 	return err
 }
 
+// --- OAuth sessions ---
+
+// ListOAuthSessions returns the stored atproto OAuth sessions for every
+// identity linked to the given user.
+func (db *DB) ListOAuthSessions(ctx context.Context, userID int64) ([]OAuthSession, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT o.did, ui.handle, o.session_id,
+		       COALESCE(NULLIF(o.host_url, ''), o.data->>'host_url', ''),
+		       COALESCE(NULLIF(o.authserver_url, ''), o.data->>'authserver_url', ''),
+		       CASE WHEN cardinality(o.scopes) > 0 THEN o.scopes
+		            ELSE COALESCE(ARRAY(SELECT jsonb_array_elements_text(o.data->'scopes')), '{}') END,
+		       EXISTS (
+		           SELECT 1 FROM sessions s
+		           WHERE s.did = o.did AND s.oauth_session_id = o.session_id AND s.expires_at > now()
+		       ),
+		       o.created_at, o.updated_at
+		FROM oauth_sessions o
+		JOIN user_identities ui ON ui.did = o.did
+		WHERE ui.user_id = $1
+		ORDER BY ui.is_primary DESC, o.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []OAuthSession
+	for rows.Next() {
+		var o OAuthSession
+		if err := rows.Scan(&o.DID, &o.Handle, &o.SessionID, &o.HostURL, &o.AuthServerURL,
+			&o.Scopes, &o.Linked, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// --- Audit log ---
+
+// ListAuditLog returns the most recent audit entries, newest first, optionally
+// filtered to one DID and/or an action prefix (e.g. "session.").
+func (db *DB) ListAuditLog(ctx context.Context, did, actionPrefix string, limit int) ([]AuditEntry, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, action, did, session_id, detail, created_at
+		FROM audit_log
+		WHERE ($1 = '' OR did = $1) AND action LIKE $2 || '%'
+		ORDER BY id DESC
+		LIMIT $3`, did, actionPrefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Action, &e.DID, &e.SessionID, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// --- Notifications ---
+
+// CreateNotification adds an entry to a user's inbox.
+func (db *DB) CreateNotification(ctx context.Context, n Notification) (*Notification, error) {
+	if n.Detail == nil {
+		n.Detail = json.RawMessage(`{}`)
+	}
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO notifications (user_id, kind, title, body, detail)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		n.UserID, n.Kind, n.Title, n.Body, n.Detail).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ListNotifications returns a user's most recent notifications, newest first.
+func (db *DB) ListNotifications(ctx context.Context, userID int64, limit int) ([]Notification, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, kind, title, body, detail, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1
+		ORDER BY id DESC
+		LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.Title, &n.Body, &n.Detail, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// CountUnreadNotifications returns how many of a user's notifications are unread.
+func (db *DB) CountUnreadNotifications(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := db.Pool.QueryRow(ctx, `
+		SELECT count(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// MarkNotificationsRead marks a user's notifications read: the given ids, or
+// all of them when ids is empty.
+func (db *DB) MarkNotificationsRead(ctx context.Context, userID int64, ids []int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE notifications SET read_at = now()
+		WHERE user_id = $1 AND read_at IS NULL AND (cardinality($2::bigint[]) = 0 OR id = ANY($2))`,
+		userID, ids)
+	return err
+}
+
+// ListUserIDsByRole returns the ids of all users with the given role.
+func (db *DB) ListUserIDsByRole(ctx context.Context, role string) ([]int64, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT id FROM users WHERE role = $1 ORDER BY id`, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RecordDevice notes that a user signed in from a client (browser family and
+// client hint fingerprint). It reports whether the client is new and whether
+// the user had any known clients before.
+func (db *DB) RecordDevice(ctx context.Context, userID int64, uaFamily, hints, ipPrefix string) (isNew, hadDevices bool, err error) {
+	err = db.Pool.QueryRow(ctx, `
+		WITH prior AS (SELECT count(*) AS n FROM user_devices WHERE user_id = $1)
+		INSERT INTO user_devices (user_id, ua_family, hints, ip_prefix)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, ua_family, hints) DO UPDATE SET last_seen = now(), ip_prefix = EXCLUDED.ip_prefix
+		RETURNING (xmax = 0), (SELECT n FROM prior) > 0`,
+		userID, uaFamily, hints, ipPrefix).Scan(&isNew, &hadDevices)
+	return isNew, hadDevices, err
+}
+
+// --- App passwords ---
+
+// ListAppPasswords returns a user's app passwords, newest first.
+func (db *DB) ListAppPasswords(ctx context.Context, userID int64) ([]AppPassword, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, name, hash, created_at, last_used_at
+		FROM app_passwords
+		WHERE user_id = $1
+		ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AppPassword
+	for rows.Next() {
+		var p AppPassword
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.Hash, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// CreateAppPassword stores a new app password by its hash.
+func (db *DB) CreateAppPassword(ctx context.Context, userID int64, name, hash string) (*AppPassword, error) {
+	p := AppPassword{UserID: userID, Name: name, Hash: hash}
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO app_passwords (user_id, name, hash)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`,
+		userID, name, hash).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeleteAppPassword removes one of a user's app passwords and returns it.
+func (db *DB) DeleteAppPassword(ctx context.Context, userID, id int64) (*AppPassword, error) {
+	var p AppPassword
+	err := db.Pool.QueryRow(ctx, `
+		DELETE FROM app_passwords WHERE id = $1 AND user_id = $2
+		RETURNING id, user_id, name, hash, created_at, last_used_at`, id, userID).
+		Scan(&p.ID, &p.UserID, &p.Name, &p.Hash, &p.CreatedAt, &p.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CheckAppPassword reports whether hash is one of a user's app passwords,
+// recording its use if so.
+func (db *DB) CheckAppPassword(ctx context.Context, userID int64, hash string) (bool, error) {
+	var id int64
+	err := db.Pool.QueryRow(ctx, `
+		UPDATE app_passwords SET last_used_at = now()
+		WHERE user_id = $1 AND hash = $2
+		RETURNING id`, userID, hash).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteAppPasswordsByDID removes every app password of the user owning
+// the identity did, returning how many were removed.
+func (db *DB) DeleteAppPasswordsByDID(ctx context.Context, did string) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		DELETE FROM app_passwords
+		WHERE user_id IN (SELECT user_id FROM user_identities WHERE did = $1)`, did)
+	return tag.RowsAffected(), err
+}
+
+// --- Webhooks ---
+
+const webhookColumns = `id, url, description, events, secret, enabled, created_at`
+
+func scanWebhook(row pgx.Row) (*Webhook, error) {
+	var w Webhook
+	if err := row.Scan(&w.ID, &w.URL, &w.Description, &w.Events, &w.Secret, &w.Enabled, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (db *DB) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *w)
+	}
+	return out, rows.Err()
+}
+
+func (db *DB) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	return scanWebhook(db.Pool.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+}
+
+func (db *DB) CreateWebhook(ctx context.Context, url, description string, events []string, secret string) (*Webhook, error) {
+	return scanWebhook(db.Pool.QueryRow(ctx, `
+		INSERT INTO webhooks (url, description, events, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+webhookColumns, url, description, events, secret))
+}
+
+func (db *DB) UpdateWebhook(ctx context.Context, id int64, url, description string, events []string, enabled bool) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE webhooks SET url = $2, description = $3, events = $4, enabled = $5 WHERE id = $1`,
+		id, url, description, events, enabled)
+	return err
+}
+
+func (db *DB) SetWebhookSecret(ctx context.Context, id int64, secret string) error {
+	_, err := db.Pool.Exec(ctx, `UPDATE webhooks SET secret = $2 WHERE id = $1`, id, secret)
+	return err
+}
+
+func (db *DB) DeleteWebhook(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	return err
+}
+
+// EnqueueWebhookEvent queues an event for every enabled webhook whose filter
+// matches its type, returning how many deliveries were queued.
+func (db *DB) EnqueueWebhookEvent(ctx context.Context, eventID, eventType string, payload []byte) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhooks
+		WHERE enabled AND (
+		    cardinality(events) = 0 OR $2 = ANY(events) OR '*' = ANY(events)
+		    OR split_part($2, '.', 1) || '.*' = ANY(events)
+		)`, eventID, eventType, payload)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// EnqueueWebhookDelivery queues a test event for one webhook regardless of
+// its filter. Test deliveries are sent even while the webhook is disabled.
+func (db *DB) EnqueueWebhookDelivery(ctx context.Context, webhookID int64, eventID, eventType string, payload []byte) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, test)
+		VALUES ($1, $2, $3, $4, true)
+		RETURNING id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+		          last_status, last_error, created_at, delivered_at`,
+		webhookID, eventID, eventType, payload).
+		Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ClaimWebhookJobs leases up to limit due deliveries by pushing their next
+// attempt lease into the future, so concurrent workers (or a worker that
+// crashes mid-send) never double-send before the lease expires. Deliveries
+// of disabled webhooks stay queued until it is re-enabled, except tests.
+func (db *DB) ClaimWebhookJobs(ctx context.Context, limit int, lease time.Duration) ([]WebhookJob, error) {
+	rows, err := db.Pool.Query(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+		    SELECT d2.id FROM webhook_deliveries d2
+		    JOIN webhooks w2 ON w2.id = d2.webhook_id
+		    WHERE d2.status = 'pending' AND d2.next_attempt_at <= now()
+		      AND (w2.enabled OR d2.test)
+		    ORDER BY d2.next_attempt_at
+		    LIMIT $1
+		    FOR UPDATE OF d2 SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.attempts, w.url, w.secret`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []WebhookJob
+	for rows.Next() {
+		var j WebhookJob
+		if err := rows.Scan(&j.ID, &j.WebhookID, &j.EventID, &j.EventType, &j.Payload, &j.Attempts, &j.URL, &j.Secret); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// FinishWebhookDelivery records an attempt. A nil retryAt marks the delivery
+// final: delivered if errMsg is empty, failed otherwise.
+func (db *DB) FinishWebhookDelivery(ctx context.Context, id int64, status int, errMsg string, retryAt *time.Time) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE webhook_deliveries SET
+		    attempts = attempts + 1,
+		    last_status = $2,
+		    last_error = $3,
+		    status = CASE WHEN $4::timestamptz IS NOT NULL THEN 'pending' WHEN $3 = '' THEN 'delivered' ELSE 'failed' END,
+		    next_attempt_at = COALESCE($4, next_attempt_at),
+		    delivered_at = CASE WHEN $3 = '' THEN now() END
+		WHERE id = $1`, id, status, errMsg, retryAt)
+	return err
+}
+
+// ListWebhookDeliveries returns a webhook's most recent deliveries.
+func (db *DB) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+		       last_status, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// RetryWebhookDelivery puts a finished delivery back in the queue.
+func (db *DB) RetryWebhookDelivery(ctx context.Context, webhookID, id int64) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE webhook_deliveries SET status = 'pending', next_attempt_at = now()
+		WHERE id = $1 AND webhook_id = $2 AND status <> 'pending'`, id, webhookID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// PruneWebhookDeliveries deletes finished deliveries older than age.
+func (db *DB) PruneWebhookDeliveries(ctx context.Context, age time.Duration) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status <> 'pending' AND created_at < now() - make_interval(secs => $1)`, age.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// --- OAuth keys ---
+
+// ListOAuthKeys returns the OAuth client signing keys that are still
+// published: the active key first, then keys scheduled for retirement.
+func (db *DB) ListOAuthKeys(ctx context.Context) ([]OAuthKey, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT kid, public_key, private_key, created_at, retire_at
+		FROM oauth_keys
+		WHERE retire_at IS NULL OR retire_at > now()
+		ORDER BY retire_at IS NOT NULL, created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []OAuthKey
+	for rows.Next() {
+		var k OAuthKey
+		if err := rows.Scan(&k.KID, &k.PublicKey, &k.PrivateKey, &k.CreatedAt, &k.RetireAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// EnsureOAuthKey records the public key of the configured signing key if it
+// is not already known, scheduling the previously active key for retirement
+// after grace. The first key ever stored keeps the historical kid "noknok-1".
+func (db *DB) EnsureOAuthKey(ctx context.Context, publicKey string, grace time.Duration) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var known bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM oauth_keys WHERE public_key = $1)`, publicKey).Scan(&known); err != nil {
+		return err
+	}
+	if known {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE oauth_keys SET retire_at = now() + make_interval(secs => $1)
+		WHERE retire_at IS NULL`, grace.Seconds()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO oauth_keys (kid, public_key)
+		SELECT CASE WHEN EXISTS (SELECT 1 FROM oauth_keys) THEN 'noknok-' || to_char(now() AT TIME ZONE 'UTC', 'YYYYMMDDHH24MISS')
+		            ELSE 'noknok-1' END, $1`, publicKey); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RotateOAuthKey stores a new active signing key and schedules every
+// previously active key for retirement after grace. privateKey must already
+// be sealed.
+func (db *DB) RotateOAuthKey(ctx context.Context, kid, publicKey, privateKey string, grace time.Duration) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE oauth_keys SET retire_at = now() + make_interval(secs => $1)
+		WHERE retire_at IS NULL`, grace.Seconds()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO oauth_keys (kid, public_key, private_key) VALUES ($1, $2, $3)`, kid, publicKey, privateKey); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UpdateOAuthKey rewrites a stored key's public and private halves, for
+// migrating rows written before keys were sealed.
+func (db *DB) UpdateOAuthKey(ctx context.Context, kid, publicKey, privateKey string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE oauth_keys SET public_key = $2, private_key = $3 WHERE kid = $1`, kid, publicKey, privateKey)
+	return err
+}
+
+// PruneOAuthKeys deletes keys whose retirement time has passed.
+func (db *DB) PruneOAuthKeys(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM oauth_keys WHERE retire_at <= now()`)
+	return err
+}
+
 // --- Services ---
 
 func (db *DB) ListServices(ctx context.Context) ([]Service, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at
+		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, upstream_url, created_at
 		FROM services ORDER BY name`)
 	if err != nil {
 		return nil, err
@@ -194,7 +947,7 @@ func (db *DB) ListServices(ctx context.Context) ([]Service, error) {
 	var svcs []Service
 	for rows.Next() {
 		var s Service
-		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.UpstreamURL, &s.CreatedAt); err != nil {
 			return nil, err
 		}
 		svcs = append(svcs, s)
@@ -204,7 +957,7 @@ func (db *DB) ListServices(ctx context.Context) ([]Service, error) {
 
 func (db *DB) ListServicesForUser(ctx context.Context, userID int64) ([]Service, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT s.id, s.slug, s.name, s.description, s.url, COALESCE(s.icon_url, ''), s.admin_role, s.enabled, s.public, s.created_at
+		SELECT s.id, s.slug, s.name, s.description, s.url, COALESCE(s.icon_url, ''), s.admin_role, s.enabled, s.public, s.upstream_url, s.created_at
 		FROM services s
 		JOIN grants g ON g.service_id = s.id
 		WHERE g.user_id = $1
@@ -217,7 +970,7 @@ func (db *DB) ListServicesForUser(ctx context.Context, userID int64) ([]Service,
 	var svcs []Service
 	for rows.Next() {
 		var s Service
-		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.UpstreamURL, &s.CreatedAt); err != nil {
 			return nil, err
 		}
 		svcs = append(svcs, s)
@@ -227,7 +980,7 @@ func (db *DB) ListServicesForUser(ctx context.Context, userID int64) ([]Service,
 
 func (db *DB) ListPublicServices(ctx context.Context) ([]Service, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at
+		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, upstream_url, created_at
 		FROM services WHERE public = true AND enabled = true ORDER BY name`)
 	if err != nil {
 		return nil, err
@@ -237,7 +990,7 @@ func (db *DB) ListPublicServices(ctx context.Context) ([]Service, error) {
 	var svcs []Service
 	for rows.Next() {
 		var s Service
-		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.UpstreamURL, &s.CreatedAt); err != nil {
 			return nil, err
 		}
 		svcs = append(svcs, s)
@@ -245,30 +998,30 @@ func (db *DB) ListPublicServices(ctx context.Context) ([]Service, error) {
 	return svcs, rows.Err()
 }
 
-func (db *DB) CreateService(ctx context.Context, slug, name, description, url, iconURL, adminRole string) (*Service, error) {
+func (db *DB) CreateService(ctx context.Context, slug, name, description, url, iconURL, adminRole, upstreamURL string) (*Service, error) {
 	if adminRole == "" {
 		adminRole = "admin"
 	}
 	var s Service
 	err := db.Pool.QueryRow(ctx, `
-		INSERT INTO services (slug, name, description, url, icon_url, admin_role)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at`,
-		slug, name, description, url, iconURL, adminRole).
-		Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt)
+		INSERT INTO services (slug, name, description, url, icon_url, admin_role, upstream_url)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, upstream_url, created_at`,
+		slug, name, description, url, iconURL, adminRole, upstreamURL).
+		Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.UpstreamURL, &s.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &s, nil
 }
 
-func (db *DB) UpdateService(ctx context.Context, id int64, name, description, url, iconURL, adminRole string) error {
+func (db *DB) UpdateService(ctx context.Context, id int64, name, description, url, iconURL, adminRole, upstreamURL string) error {
 	if adminRole == "" {
 		adminRole = "admin"
 	}
 	_, err := db.Pool.Exec(ctx, `
-		UPDATE services SET name = $1, description = $2, url = $3, icon_url = $4, admin_role = $5
-		WHERE id = $6`, name, description, url, iconURL, adminRole, id)
+		UPDATE services SET name = $1, description = $2, url = $3, icon_url = $4, admin_role = $5, upstream_url = $6
+		WHERE id = $7`, name, description, url, iconURL, adminRole, upstreamURL, id)
 	return err
 }
 
@@ -293,6 +1046,47 @@ func (db *DB) DeleteService(ctx context.Context, id int64) error {
 	return err
 }
 
+// UpsertDiscoveredService creates or updates a service owned by a discovery
+// source. A service that source had disabled as missing is re-enabled. A slug
+// already taken by a hand-made service or another source is left alone, and
+// owned reports false.
+func (db *DB) UpsertDiscoveredService(ctx context.Context, source string, s Service) (owned bool, err error) {
+	var id int64
+	err = db.Pool.QueryRow(ctx, `
+		INSERT INTO services (slug, name, description, url, icon_url, admin_role, public, upstream_url, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (slug) DO UPDATE SET
+			name = EXCLUDED.name,
+			description = EXCLUDED.description,
+			url = EXCLUDED.url,
+			icon_url = EXCLUDED.icon_url,
+			admin_role = EXCLUDED.admin_role,
+			public = EXCLUDED.public,
+			upstream_url = EXCLUDED.upstream_url,
+			enabled = services.enabled OR services.missing_since IS NOT NULL,
+			missing_since = NULL
+		WHERE services.source = EXCLUDED.source
+		RETURNING id`,
+		s.Slug, s.Name, s.Description, s.URL, s.IconURL, s.AdminRole, s.Public, s.UpstreamURL, source).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DisableMissingServices disables services from source whose slug is not in
+// present, returning how many were newly disabled.
+func (db *DB) DisableMissingServices(ctx context.Context, source string, present []string) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE services SET enabled = false, missing_since = now()
+		WHERE source = $1 AND missing_since IS NULL AND NOT (slug = ANY($2))`,
+		source, present)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 // --- Grants ---
 
 func (db *DB) ListGrants(ctx context.Context) ([]Grant, error) {
@@ -338,56 +1132,160 @@ func (db *DB) CreateGrant(ctx context.Context, userID, serviceID, grantedBy int6
 	return &g, nil
 }
 
-func (db *DB) DeleteGrant(ctx context.Context, id int64) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM grants WHERE id = $1`, id)
-	return err
+// DeleteGrant removes a grant and returns it, or nil if it did not exist.
+func (db *DB) DeleteGrant(ctx context.Context, id int64) (*Grant, error) {
+	var g Grant
+	err := db.Pool.QueryRow(ctx, `
+		DELETE FROM grants WHERE id = $1
+		RETURNING id, user_id, service_id, role, granted_by, created_at`, id).
+		Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
 }
 
-func (db *DB) DeleteGrantByUserService(ctx context.Context, userID, serviceID int64) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM grants WHERE user_id = $1 AND service_id = $2`, userID, serviceID)
-	return err
+// DeleteGrantByUserService removes a user's grant for a service and returns
+// it, or nil if there was none.
+func (db *DB) DeleteGrantByUserService(ctx context.Context, userID, serviceID int64) (*Grant, error) {
+	var g Grant
+	err := db.Pool.QueryRow(ctx, `
+		DELETE FROM grants WHERE user_id = $1 AND service_id = $2
+		RETURNING id, user_id, service_id, role, granted_by, created_at`, userID, serviceID).
+		Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
 }
 
-// GetServiceByHost returns the service whose URL contains the given host.
-// Returns nil (no error) if no service matches.
-func (db *DB) GetServiceByHost(ctx context.Context, host string) (*Service, error) {
+// SyncServiceMembers renames a service and removes and adds user grants in
+// one transaction. It returns the grants that were deleted and created.
+func (db *DB) SyncServiceMembers(ctx context.Context, serviceID int64, name string, grantedBy int64, remove, add []int64) (removed, added []Grant, err error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE services SET name = $1 WHERE id = $2 AND name <> $1`, name, serviceID); err != nil {
+		return nil, nil, err
+	}
+	for _, uid := range remove {
+		var g Grant
+		err := tx.QueryRow(ctx, `
+			DELETE FROM grants WHERE user_id = $1 AND service_id = $2
+			RETURNING id, user_id, service_id, role, granted_by, created_at`, uid, serviceID).
+			Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.CreatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		removed = append(removed, g)
+	}
+	for _, uid := range add {
+		var g Grant
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO grants (user_id, service_id, role, granted_by)
+			VALUES ($1, $2, 'user', $3)
+			ON CONFLICT (user_id, service_id) DO UPDATE SET role = EXCLUDED.role
+			RETURNING id, user_id, service_id, role, granted_by, created_at`, uid, serviceID, grantedBy).
+			Scan(&g.ID, &g.UserID, &g.ServiceID, &g.Role, &g.GrantedBy, &g.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		added = append(added, g)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, err
+	}
+	return removed, added, nil
+}
+
+// GetService returns a service by id.
+func (db *DB) GetService(ctx context.Context, id int64) (*Service, error) {
 	var s Service
 	err := db.Pool.QueryRow(ctx, `
-		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, created_at
-		FROM services WHERE url LIKE '%' || $1 || '%'
-		LIMIT 1`, host).
-		Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.CreatedAt)
+		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, upstream_url, created_at
+		FROM services WHERE id = $1`, id).
+		Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.UpstreamURL, &s.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &s, nil
 }
 
-// GetUserServiceRole returns the role a user has for a service whose URL
-// contains the given host. For owner/admin users, returns the service's
-// admin_role. For regular users, returns the grant's role.
-func (db *DB) GetUserServiceRole(ctx context.Context, did, host string) (string, error) {
-	var userRole, grantRole, adminRole string
+// GetServiceByHost returns the service whose URL host equals host, compared
+// case-insensitively and ignoring ports. Returns nil (no error) if no service
+// matches; the lowest id wins if several do.
+func (db *DB) GetServiceByHost(ctx context.Context, host string) (*Service, error) {
+	host = NormalizeHost(host)
+	if host == "" {
+		return nil, nil
+	}
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, slug, name, description, url, COALESCE(icon_url, ''), admin_role, enabled, public, upstream_url, created_at
+		FROM services ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Service
+		if err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.Description, &s.URL, &s.IconURL, &s.AdminRole, &s.Enabled, &s.Public, &s.UpstreamURL, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		if u, err := url.Parse(s.URL); err == nil && NormalizeHost(u.Host) == host {
+			return &s, rows.Err()
+		}
+	}
+	return nil, rows.Err()
+}
+
+// NormalizeHost lower-cases a host and drops its port, IPv6 brackets and
+// trailing dot.
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return strings.ToLower(host)
+}
+
+// GetUserServiceRole returns the role a user has for svc. For owner/admin
+// users, returns the service's admin_role ("admin" when svc is nil). For
+// regular users, returns the grant's role, or "" without a grant.
+func (db *DB) GetUserServiceRole(ctx context.Context, did string, svc *Service) (string, error) {
+	var serviceID int64
+	adminRole := "admin"
+	if svc != nil {
+		serviceID = svc.ID
+		if svc.AdminRole != "" {
+			adminRole = svc.AdminRole
+		}
+	}
+	var userRole, grantRole string
 	err := db.Pool.QueryRow(ctx, `
-		SELECT u.role,
-		       COALESCE(g.role, ''),
-		       COALESCE(s.admin_role, 'admin')
+		SELECT u.role, COALESCE(g.role, '')
 		FROM user_identities ui
 		JOIN users u ON u.id = ui.user_id
-		LEFT JOIN services s ON s.url LIKE '%' || $2 || '%'
-		LEFT JOIN grants g ON g.user_id = u.id AND g.service_id = s.id
-		WHERE ui.did = $1
-		LIMIT 1`, did, host).Scan(&userRole, &grantRole, &adminRole)
+		LEFT JOIN grants g ON g.user_id = u.id AND g.service_id = $2
+		WHERE ui.did = $1`, did, serviceID).Scan(&userRole, &grantRole)
 	if err != nil {
 		return "", err
 	}
 	if userRole == "owner" || userRole == "admin" {
 		return adminRole, nil
 	}
-	if grantRole != "" {
-		return grantRole, nil
-	}
-	return "", nil
+	return grantRole, nil
 }
 
 func (db *DB) GrantAllServices(ctx context.Context, userID, grantedBy int64) error {
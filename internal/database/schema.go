@@ -16,6 +16,14 @@ ALTER TABLE sessions ADD COLUMN IF NOT EXISTS username TEXT NOT NULL DEFAULT '';
 ALTER TABLE sessions ADD COLUMN IF NOT EXISTS group_id TEXT NOT NULL DEFAULT '';
 CREATE INDEX IF NOT EXISTS idx_sessions_group_id ON sessions (group_id) WHERE group_id != '';
 ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS oauth_session_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token_hash TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_token_hash ON sessions (token_hash);
+ALTER TABLE sessions ALTER COLUMN token DROP NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS bind_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS bind_ua TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS bind_hints TEXT NOT NULL DEFAULT '';
 
 CREATE TABLE IF NOT EXISTS users (
     id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
@@ -36,6 +44,11 @@ CREATE TABLE IF NOT EXISTS user_identities (
     created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+ALTER TABLE user_identities ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_identities ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_identities ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_identities ADD COLUMN IF NOT EXISTS profile_updated_at TIMESTAMPTZ;
+ALTER TABLE user_identities ADD COLUMN IF NOT EXISTS pds_url TEXT NOT NULL DEFAULT '';
 
 CREATE TABLE IF NOT EXISTS services (
     id          BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
@@ -50,6 +63,9 @@ CREATE TABLE IF NOT EXISTS services (
 ALTER TABLE services ADD COLUMN IF NOT EXISTS admin_role TEXT NOT NULL DEFAULT 'admin';
 ALTER TABLE services ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT true;
 ALTER TABLE services ADD COLUMN IF NOT EXISTS public BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS upstream_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS missing_since TIMESTAMPTZ;
 
 CREATE TABLE IF NOT EXISTS grants (
     id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
@@ -67,6 +83,7 @@ CREATE TABLE IF NOT EXISTS oauth_requests (
     data       JSONB NOT NULL,
     created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
+CREATE INDEX IF NOT EXISTS idx_oauth_requests_created_at ON oauth_requests (created_at);
 
 CREATE TABLE IF NOT EXISTS oauth_sessions (
     did        TEXT NOT NULL,
@@ -75,4 +92,89 @@ CREATE TABLE IF NOT EXISTS oauth_sessions (
     created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
     PRIMARY KEY (did, session_id)
 );
+ALTER TABLE oauth_sessions ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE oauth_sessions ADD COLUMN IF NOT EXISTS host_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE oauth_sessions ADD COLUMN IF NOT EXISTS authserver_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE oauth_sessions ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS oauth_keys (
+    kid         TEXT PRIMARY KEY,
+    private_key TEXT NOT NULL UNIQUE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    retire_at   TIMESTAMPTZ
+);
+ALTER TABLE oauth_keys ADD COLUMN IF NOT EXISTS public_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE oauth_keys ALTER COLUMN private_key SET DEFAULT '';
+ALTER TABLE oauth_keys DROP CONSTRAINT IF EXISTS oauth_keys_private_key_key;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    action     TEXT NOT NULL,
+    did        TEXT NOT NULL DEFAULT '',
+    session_id BIGINT,
+    detail     JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_did ON audit_log (did);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind       TEXT NOT NULL,
+    title      TEXT NOT NULL,
+    body       TEXT NOT NULL DEFAULT '',
+    detail     JSONB NOT NULL DEFAULT '{}',
+    read_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id, id);
+
+CREATE TABLE IF NOT EXISTS user_devices (
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ua_family  TEXT NOT NULL,
+    hints      TEXT NOT NULL,
+    ip_prefix  TEXT NOT NULL DEFAULT '',
+    first_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, ua_family, hints)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id          BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    url         TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    events      TEXT[] NOT NULL DEFAULT '{}',
+    secret      TEXT NOT NULL,
+    enabled     BOOLEAN NOT NULL DEFAULT true,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    webhook_id      BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id        TEXT NOT NULL,
+    event_type      TEXT NOT NULL,
+    payload         JSONB NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending',
+    attempts        INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_status     INT NOT NULL DEFAULT 0,
+    last_error      TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS test BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS app_passwords (
+    id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    hash         TEXT NOT NULL UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_app_passwords_user_id ON app_passwords (user_id);
 `
@@ -0,0 +1,239 @@
+package devauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serveAPI handles the server-to-server side of the fake: DID documents,
+// handle well-known files, PDS endpoints, and the OAuth endpoints that the
+// client calls directly (PAR, token, revoke).
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Hostname()
+	path := r.URL.Path
+
+	switch {
+	case host == "plc."+Domain && r.Method == http.MethodGet:
+		s.handleDIDDoc(w, strings.TrimPrefix(path, "/"))
+
+	case host == pdsHost:
+		switch path {
+		case "/.well-known/oauth-protected-resource":
+			writeJSON(w, http.StatusOK, map[string]any{
+				"resource":              pdsURL,
+				"authorization_servers": []string{pdsURL},
+			})
+		case "/.well-known/oauth-authorization-server":
+			writeJSON(w, http.StatusOK, s.serverMetadata())
+		case "/oauth/par":
+			s.handlePAR(w, r)
+		case "/oauth/token":
+			s.handleToken(w, r)
+		case "/oauth/revoke":
+			s.handleRevoke(w, r)
+		case "/xrpc/com.atproto.sync.getRepoStatus":
+			s.handleRepoStatus(w, r)
+		case "/xrpc/app.bsky.actor.getProfile":
+			s.handleProfile(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+
+	case path == "/.well-known/atproto-did":
+		id, ok := s.lookupHandle(host)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(id.DID))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleDIDDoc(w http.ResponseWriter, did string) {
+	id, ok := s.lookupDID(did)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "DID not registered: " + did})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"@context":    []string{"https://www.w3.org/ns/did/v1"},
+		"id":          id.DID,
+		"alsoKnownAs": []string{"at://" + id.Handle},
+		"service": []map[string]string{{
+			"id":              "#atproto_pds",
+			"type":            "AtprotoPersonalDataServer",
+			"serviceEndpoint": pdsURL,
+		}},
+	})
+}
+
+func (s *Server) serverMetadata() map[string]any {
+	return map[string]any{
+		"issuer":                                           pdsURL,
+		"authorization_endpoint":                           pdsURL + "/oauth/authorize",
+		"token_endpoint":                                   pdsURL + "/oauth/token",
+		"pushed_authorization_request_endpoint":            pdsURL + "/oauth/par",
+		"revocation_endpoint":                              pdsURL + "/oauth/revoke",
+		"response_types_supported":                         []string{"code"},
+		"grant_types_supported":                            []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":                 []string{"S256"},
+		"token_endpoint_auth_methods_supported":            []string{"none", "private_key_jwt"},
+		"token_endpoint_auth_signing_alg_values_supported": []string{"ES256"},
+		"scopes_supported":                                 []string{"atproto", "transition:generic"},
+		"authorization_response_iss_parameter_supported":   true,
+		"require_pushed_authorization_requests":            true,
+		"dpop_signing_alg_values_supported":                []string{"ES256"},
+		"client_id_metadata_document_supported":            true,
+	}
+}
+
+// handlePAR stores a pushed authorization request. Client assertions and
+// DPoP proofs are accepted without verification.
+func (s *Server) handlePAR(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("code_challenge_method") != "S256" || r.PostForm.Get("code_challenge") == "" {
+		oauthError(w, "invalid_request", "PKCE S256 challenge required")
+		return
+	}
+	req := &authRequest{
+		clientID:      r.PostForm.Get("client_id"),
+		redirectURI:   r.PostForm.Get("redirect_uri"),
+		state:         r.PostForm.Get("state"),
+		scope:         r.PostForm.Get("scope"),
+		loginHint:     r.PostForm.Get("login_hint"),
+		codeChallenge: r.PostForm.Get("code_challenge"),
+		created:       time.Now(),
+	}
+	if req.clientID == "" || req.redirectURI == "" || req.state == "" {
+		oauthError(w, "invalid_request", "client_id, redirect_uri and state are required")
+		return
+	}
+
+	requestURI := "urn:ietf:params:oauth:request_uri:req-" + randomToken(16)
+	s.mu.Lock()
+	s.expire()
+	s.requests[requestURI] = req
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"request_uri": requestURI,
+		"expires_in":  int(requestTTL.Seconds()),
+	})
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, "invalid_request", err.Error())
+		return
+	}
+
+	var did, scope string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		s.mu.Lock()
+		req, ok := s.codes[r.PostForm.Get("code")]
+		delete(s.codes, r.PostForm.Get("code"))
+		s.mu.Unlock()
+		if !ok {
+			oauthError(w, "invalid_grant", "unknown or expired code")
+			return
+		}
+		if r.PostForm.Get("redirect_uri") != req.redirectURI || r.PostForm.Get("client_id") != req.clientID {
+			oauthError(w, "invalid_grant", "client or redirect_uri mismatch")
+			return
+		}
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != req.codeChallenge {
+			oauthError(w, "invalid_grant", "PKCE verification failed")
+			return
+		}
+		did, scope = req.did, req.scope
+
+	case "refresh_token":
+		rt := r.PostForm.Get("refresh_token")
+		s.mu.Lock()
+		d, ok := s.tokens[rt]
+		delete(s.tokens, rt)
+		s.mu.Unlock()
+		if !ok {
+			oauthError(w, "invalid_grant", "unknown refresh token")
+			return
+		}
+		did, scope = d, "atproto"
+
+	default:
+		oauthError(w, "unsupported_grant_type", "")
+		return
+	}
+
+	refresh := "dev-rt-" + randomToken(24)
+	s.mu.Lock()
+	s.tokens[refresh] = did
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  "dev-at-" + randomToken(24),
+		"refresh_token": refresh,
+		"token_type":    "DPoP",
+		"expires_in":    3600,
+		"scope":         scope,
+		"sub":           did,
+	})
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err == nil {
+		s.mu.Lock()
+		delete(s.tokens, r.PostForm.Get("token"))
+		s.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleRepoStatus(w http.ResponseWriter, r *http.Request) {
+	did := r.URL.Query().Get("did")
+	if _, ok := s.lookupDID(did); !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "RepoNotFound"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"did": did, "active": true})
+}
+
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	actor := r.URL.Query().Get("actor")
+	id, ok := s.lookupDID(actor)
+	if !ok {
+		id, ok = s.lookupHandle(actor)
+	}
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "InvalidRequest", "message": "Profile not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"did":         id.DID,
+		"handle":      id.Handle,
+		"displayName": id.DisplayName,
+		"description": "Test account served by the noknok dev auth server.",
+	})
+}
+
+func oauthError(w http.ResponseWriter, code, desc string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": desc})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
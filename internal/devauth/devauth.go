@@ -0,0 +1,196 @@
+// Package devauth is an in-process fake of the atproto services noknok talks
+// to during login: a PLC directory, handle resolution, a PDS and its OAuth
+// authorization server. It exists so the full login flow can run offline in
+// development and CI. It performs no real authentication and must never be
+// enabled in production.
+package devauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+)
+
+// Domain is the reserved .test domain the fake services live under. Requests
+// to any other host through Transport fail, so nothing leaves the process.
+const Domain = "noknok.test"
+
+const (
+	plcURL  = "https://plc." + Domain
+	pdsHost = "pds." + Domain
+	pdsURL  = "https://" + pdsHost
+
+	requestTTL = 10 * time.Minute
+)
+
+// Identity is a predefined test account.
+type Identity struct {
+	DID         string
+	Handle      string
+	DisplayName string
+}
+
+// Server holds the fake services' state. All state is in memory.
+type Server struct {
+	publicURL  string
+	identities []Identity
+
+	mu       sync.Mutex
+	requests map[string]*authRequest // keyed by request_uri
+	codes    map[string]*authRequest // keyed by authorization code
+	tokens   map[string]string       // refresh token -> DID
+}
+
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	state         string
+	scope         string
+	loginHint     string
+	codeChallenge string
+	did           string
+	created       time.Time
+}
+
+// New creates a fake server with the given test accounts. Entries are either
+// "name" (handle name.noknok.test with a derived DID) or "handle=did".
+// publicURL is noknok's own URL, under which the account picker is mounted.
+func New(publicURL string, accounts []string) (*Server, error) {
+	s := &Server{
+		publicURL: strings.TrimRight(publicURL, "/"),
+		requests:  make(map[string]*authRequest),
+		codes:     make(map[string]*authRequest),
+		tokens:    make(map[string]string),
+	}
+	for _, a := range accounts {
+		handle, did, _ := strings.Cut(a, "=")
+		handle = strings.ToLower(strings.TrimSpace(handle))
+		if handle == "" {
+			continue
+		}
+		name := handle
+		if !strings.Contains(handle, ".") {
+			handle += "." + Domain
+		} else {
+			name, _, _ = strings.Cut(handle, ".")
+		}
+		if !strings.HasSuffix(handle, "."+Domain) {
+			return nil, fmt.Errorf("test handle %q must be under .%s", handle, Domain)
+		}
+		if did == "" {
+			did = deriveDID(handle)
+		}
+		s.identities = append(s.identities, Identity{
+			DID:         strings.TrimSpace(did),
+			Handle:      handle,
+			DisplayName: strings.ToUpper(name[:1]) + name[1:],
+		})
+	}
+	if len(s.identities) == 0 {
+		return nil, fmt.Errorf("no test identities configured")
+	}
+	return s, nil
+}
+
+// deriveDID returns a stable did:plc for a handle so OWNER_DID and grants
+// survive restarts.
+func deriveDID(handle string) string {
+	sum := sha256.Sum256([]byte(handle))
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:15])
+	return "did:plc:" + strings.ToLower(enc)
+}
+
+// Identities returns the configured test accounts.
+func (s *Server) Identities() []Identity {
+	return s.identities
+}
+
+// PDSURL is the URL of the fake PDS (also its authorization server).
+func (s *Server) PDSURL() string {
+	return pdsURL
+}
+
+// Transport returns an http.RoundTripper that serves every *.noknok.test
+// request in-process and refuses all others.
+func (s *Server) Transport() http.RoundTripper {
+	return transport{s}
+}
+
+// Directory returns an identity directory that resolves handles and DIDs
+// against the fake PLC and handle hosts.
+func (s *Server) Directory() identity.Directory {
+	return &identity.BaseDirectory{
+		PLCURL:                plcURL,
+		HTTPClient:            http.Client{Transport: s.Transport(), Timeout: 10 * time.Second},
+		SkipDNSDomainSuffixes: []string{"." + Domain},
+		UserAgent:             "noknok-devauth",
+	}
+}
+
+// BrowserURL maps the fake authorization endpoint, which only exists inside
+// the process, to the account picker mounted on noknok itself.
+func (s *Server) BrowserURL(authorizeURL string) string {
+	return strings.Replace(authorizeURL, pdsURL+"/oauth/authorize", s.publicURL+AuthorizePath, 1)
+}
+
+type transport struct{ s *Server }
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := r.URL.Hostname()
+	if host != Domain && !strings.HasSuffix(host, "."+Domain) {
+		return nil, fmt.Errorf("devauth: refusing request to %s while offline", host)
+	}
+	rec := httptest.NewRecorder()
+	t.s.serveAPI(rec, r)
+	resp := rec.Result()
+	resp.Request = r
+	return resp, nil
+}
+
+func (s *Server) lookupHandle(handle string) (Identity, bool) {
+	for _, id := range s.identities {
+		if id.Handle == handle {
+			return id, true
+		}
+	}
+	return Identity{}, false
+}
+
+func (s *Server) lookupDID(did string) (Identity, bool) {
+	for _, id := range s.identities {
+		if id.DID == did {
+			return id, true
+		}
+	}
+	return Identity{}, false
+}
+
+// expire drops stale requests and codes. Callers must hold s.mu.
+func (s *Server) expire() {
+	cutoff := time.Now().Add(-requestTTL)
+	for k, r := range s.requests {
+		if r.created.Before(cutoff) {
+			delete(s.requests, k)
+		}
+	}
+	for k, r := range s.codes {
+		if r.created.Before(cutoff) {
+			delete(s.codes, k)
+		}
+	}
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,141 @@
+package devauth
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// AuthorizePath is where noknok mounts the fake authorization endpoint.
+const AuthorizePath = "/__dev/oauth/authorize"
+
+// HandleAuthorize is the browser-facing authorization endpoint. GET shows a
+// picker of test accounts; POST completes the request for the chosen account
+// and redirects back to the client with a code.
+func (s *Server) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	requestURI := r.Form.Get("request_uri")
+
+	s.mu.Lock()
+	req, ok := s.requests[requestURI]
+	s.mu.Unlock()
+	if !ok || (r.Form.Get("client_id") != "" && r.Form.Get("client_id") != req.clientID) {
+		http.Error(w, "unknown or expired authorization request", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(s.pickerHTML(requestURI, req)))
+		return
+	}
+
+	redirect, err := url.Parse(req.redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := redirect.Query()
+	q.Set("state", req.state)
+	q.Set("iss", pdsURL)
+
+	if r.PostForm.Get("deny") != "" {
+		q.Set("error", "access_denied")
+		q.Set("error_description", "The user denied the request")
+	} else {
+		id, ok := s.lookupDID(r.PostForm.Get("did"))
+		if !ok {
+			http.Error(w, "unknown test account", http.StatusBadRequest)
+			return
+		}
+		code := "dev-code-" + randomToken(16)
+		granted := *req
+		granted.did = id.DID
+		granted.created = time.Now()
+		s.mu.Lock()
+		s.codes[code] = &granted
+		s.mu.Unlock()
+		q.Set("code", code)
+	}
+
+	s.mu.Lock()
+	delete(s.requests, requestURI)
+	s.mu.Unlock()
+
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) pickerHTML(requestURI string, req *authRequest) string {
+	// A flow started from a handle or DID is bound to that account, so only
+	// offer it; otherwise the client rejects the token subject.
+	ids := s.identities
+	if id, ok := s.lookupHandle(req.loginHint); ok {
+		ids = []Identity{id}
+	} else if id, ok := s.lookupDID(req.loginHint); ok {
+		ids = []Identity{id}
+	}
+
+	accounts := ""
+	for _, id := range ids {
+		accounts += `
+    <button type="submit" name="did" value="` + html.EscapeString(id.DID) + `" class="account">
+      <span class="name">` + html.EscapeString(id.DisplayName) + `</span>
+      <span class="handle">@` + html.EscapeString(id.Handle) + `</span>
+      <span class="did">` + html.EscapeString(id.DID) + `</span>
+    </button>`
+	}
+
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>dev sign in</title>
+<style>
+  *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
+  body {
+    font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+    background: #0f172a;
+    color: #e2e8f0;
+    min-height: 100vh;
+    display: flex;
+    align-items: center;
+    justify-content: center;
+    padding: 2rem;
+  }
+  .box { width: 100%; max-width: 420px; }
+  h1 { font-size: 1.125rem; margin-bottom: 0.25rem; }
+  .warn { color: #f59e0b; font-size: 0.8125rem; margin-bottom: 1rem; }
+  .client { color: #64748b; font-size: 0.75rem; margin-bottom: 1rem; word-break: break-all; }
+  .account {
+    display: flex; flex-direction: column; align-items: flex-start; gap: 0.125rem;
+    width: 100%; margin-bottom: 0.5rem; padding: 0.75rem 1rem;
+    background: #1e293b; border: 1px solid #334155; border-radius: 8px;
+    color: inherit; font: inherit; text-align: left; cursor: pointer;
+  }
+  .account:hover { border-color: #3b82f6; }
+  .name { font-weight: 600; }
+  .handle { color: #94a3b8; font-size: 0.8125rem; }
+  .did { color: #64748b; font-size: 0.6875rem; }
+  .deny {
+    margin-top: 0.5rem; background: none; border: none; color: #f87171;
+    font: inherit; font-size: 0.8125rem; cursor: pointer;
+  }
+</style>
+</head>
+<body>
+<form class="box" method="post" action="` + AuthorizePath + `">
+  <h1>Sign in as a test account</h1>
+  <p class="warn">noknok dev auth server &mdash; no real authentication.</p>
+  <p class="client">` + html.EscapeString(req.clientID) + `</p>
+  <input type="hidden" name="request_uri" value="` + html.EscapeString(requestURI) + `">` + accounts + `
+  <button type="submit" name="deny" value="1" class="deny">Deny</button>
+</form>
+</body>
+</html>`
+}
@@ -0,0 +1,301 @@
+// Package discovery keeps the services table in sync with running Docker
+// containers that carry noknok.* labels.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// Source is the services.source value for services managed by Docker discovery.
+const Source = "docker"
+
+// Labels read from containers. A container is discovered when it has at
+// least one of them; everything but the URL has a default.
+//
+//	noknok.slug         service slug (default: container name)
+//	noknok.name         display name (default: slug)
+//	noknok.description
+//	noknok.icon         icon URL
+//	noknok.admin_role   role given to owners/admins (default: admin)
+//	noknok.public       "true" to skip authentication
+//	noknok.url          public URL (default: https://<first Traefik Host rule>)
+//	noknok.upstream     backend for the built-in proxy
+const labelPrefix = "noknok."
+
+var hostRule = regexp.MustCompile("Host\\(`([^`]+)`")
+
+// Store is the part of the database discovery writes to.
+type Store interface {
+	UpsertDiscoveredService(ctx context.Context, source string, s database.Service) (owned bool, err error)
+	DisableMissingServices(ctx context.Context, source string, present []string) (int64, error)
+}
+
+// Docker polls the Docker Engine API over its unix socket, and syncs early
+// when the event stream reports a labelled container starting or stopping.
+type Docker struct {
+	db       Store
+	client   *http.Client
+	events   *http.Client // no timeout: the event stream stays open
+	interval time.Duration
+	wake     chan struct{}
+	stop     chan struct{}
+}
+
+// NewDocker returns a discoverer talking to the Engine API at socketPath.
+func NewDocker(db Store, socketPath string, interval time.Duration) *Docker {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &Docker{
+		db:       db,
+		client:   &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		events:   &http.Client{Transport: transport},
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start syncs immediately and then every interval, or sooner on a container
+// event, until Stop.
+func (d *Docker) Start() {
+	events, stopEvents := context.WithCancel(context.Background())
+	go d.watchEvents(events)
+	go func() {
+		defer stopEvents()
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := d.Sync(ctx); err != nil {
+				slog.Error("docker discovery failed", "error", err)
+			}
+			cancel()
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the polling loop and the event stream.
+func (d *Docker) Stop() {
+	close(d.stop)
+}
+
+// trigger asks the loop for a sync without blocking; events arriving while
+// one is pending are folded into it.
+func (d *Docker) trigger() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Sync upserts a service for every labelled running container and disables
+// discovered services whose container is gone.
+func (d *Docker) Sync(ctx context.Context) error {
+	containers, err := d.listContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	slugs := []string{}
+	for _, ct := range containers {
+		svc, ok := serviceFromLabels(ct)
+		if !ok {
+			continue
+		}
+		// Count the container as present even if the upsert fails, so a
+		// transient error does not disable a running service.
+		slugs = append(slugs, svc.Slug)
+		owned, err := d.db.UpsertDiscoveredService(ctx, Source, svc)
+		if err != nil {
+			slog.Warn("docker discovery: upsert failed", "slug", svc.Slug, "error", err)
+		} else if !owned {
+			slog.Warn("docker discovery: slug belongs to a service not managed by discovery, skipping",
+				"slug", svc.Slug, "container", ct.Names)
+		}
+	}
+
+	disabled, err := d.db.DisableMissingServices(ctx, Source, slugs)
+	if err != nil {
+		return fmt.Errorf("disable vanished services: %w", err)
+	}
+	if disabled > 0 {
+		slog.Info("docker discovery: disabled vanished services", "count", disabled)
+	}
+	return nil
+}
+
+// eventFilters limits /events to container lifecycle changes that can add
+// or remove a service.
+const eventFilters = `{"type":["container"],"event":["start","die","destroy","rename"]}`
+
+// event is one message from the Engine API event stream. For containers the
+// actor attributes include the container labels.
+type event struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// watchEvents follows the event stream until ctx ends, reconnecting after
+// errors. A reconnect triggers a sync, since events may have been missed.
+func (d *Docker) watchEvents(ctx context.Context) {
+	for {
+		err := d.streamEvents(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("docker discovery: event stream ended, reconnecting", "error", err)
+		select {
+		case <-time.After(5 * time.Second):
+			d.trigger()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// streamEvents reads the event stream and triggers a sync for each event of
+// a container with noknok labels.
+func (d *Docker) streamEvents(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://docker/events?filters="+url.QueryEscape(eventFilters), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.events.Do(req)
+	if err != nil {
+		return fmt.Errorf("events: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("events: docker returned %s", resp.Status)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev event
+		if err := dec.Decode(&ev); err != nil {
+			return fmt.Errorf("events: %w", err)
+		}
+		if ev.Type == "container" && hasLabels(ev.Actor.Attributes) {
+			slog.Debug("docker discovery: container event", "action", ev.Action, "id", ev.Actor.ID)
+			d.trigger()
+		}
+	}
+}
+
+type container struct {
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+}
+
+func (d *Docker) listContainers(ctx context.Context) ([]container, error) {
+	// The host is ignored by the unix dialer.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list containers: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list containers: docker returned %s", resp.Status)
+	}
+	var out []container
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode containers: %w", err)
+	}
+	return out, nil
+}
+
+// serviceFromLabels builds a service from a container's labels. It reports
+// false for containers without noknok labels or without a usable URL.
+func serviceFromLabels(ct container) (database.Service, bool) {
+	l := ct.Labels
+	if !hasLabels(l) {
+		return database.Service{}, false
+	}
+
+	svc := database.Service{
+		Slug:        l[labelPrefix+"slug"],
+		Name:        l[labelPrefix+"name"],
+		Description: l[labelPrefix+"description"],
+		IconURL:     l[labelPrefix+"icon"],
+		AdminRole:   l[labelPrefix+"admin_role"],
+		URL:         l[labelPrefix+"url"],
+		UpstreamURL: l[labelPrefix+"upstream"],
+	}
+	svc.Public, _ = strconv.ParseBool(l[labelPrefix+"public"])
+	if svc.Slug == "" && len(ct.Names) > 0 {
+		svc.Slug = strings.TrimPrefix(ct.Names[0], "/")
+	}
+	if svc.Name == "" {
+		svc.Name = svc.Slug
+	}
+	if svc.AdminRole == "" {
+		svc.AdminRole = "admin"
+	}
+	if svc.URL == "" {
+		svc.URL = traefikURL(l)
+	}
+	if svc.Slug == "" || svc.URL == "" {
+		slog.Warn("docker discovery: skipping container without slug or url", "names", ct.Names)
+		return database.Service{}, false
+	}
+	return svc, true
+}
+
+// hasLabels reports whether any label has the noknok. prefix.
+func hasLabels(labels map[string]string) bool {
+	for k := range labels {
+		if strings.HasPrefix(k, labelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// traefikURL derives a URL from the first Host(`...`) in the container's
+// Traefik router rules, taking routers in name order.
+func traefikURL(labels map[string]string) string {
+	var keys []string
+	for k := range labels {
+		if strings.HasPrefix(k, "traefik.http.routers.") && strings.HasSuffix(k, ".rule") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if m := hostRule.FindStringSubmatch(labels[k]); m != nil {
+			return "https://" + m[1]
+		}
+	}
+	return ""
+}
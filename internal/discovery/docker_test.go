@@ -0,0 +1,252 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// fakeStore records what discovery writes.
+type fakeStore struct {
+	mu       sync.Mutex
+	upserted []database.Service
+	notOwned map[string]bool
+	present  [][]string
+	synced   chan struct{}
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{notOwned: map[string]bool{}, synced: make(chan struct{}, 10)}
+}
+
+func (f *fakeStore) UpsertDiscoveredService(_ context.Context, source string, s database.Service) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.upserted = append(f.upserted, s)
+	return !f.notOwned[s.Slug], nil
+}
+
+func (f *fakeStore) DisableMissingServices(_ context.Context, source string, present []string) (int64, error) {
+	f.mu.Lock()
+	f.present = append(f.present, present)
+	f.mu.Unlock()
+	f.synced <- struct{}{}
+	return 0, nil
+}
+
+// fakeDocker serves handler on a unix socket, standing in for the Engine API.
+func fakeDocker(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return sock
+}
+
+func containersHandler(cts []container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(cts)
+	}
+}
+
+func TestServiceFromLabels(t *testing.T) {
+	cases := []struct {
+		name string
+		ct   container
+		want database.Service
+		ok   bool
+	}{
+		{
+			name: "all labels",
+			ct: container{Names: []string{"/gitea"}, Labels: map[string]string{
+				"noknok.slug": "git", "noknok.name": "Gitea", "noknok.description": "Code",
+				"noknok.icon": "https://git.example.com/icon.png", "noknok.admin_role": "owner",
+				"noknok.public": "true", "noknok.url": "https://git.example.com", "noknok.upstream": "http://gitea:3000",
+			}},
+			want: database.Service{Slug: "git", Name: "Gitea", Description: "Code",
+				IconURL: "https://git.example.com/icon.png", AdminRole: "owner", Public: true,
+				URL: "https://git.example.com", UpstreamURL: "http://gitea:3000"},
+			ok: true,
+		},
+		{
+			name: "defaults from container name and Traefik rule",
+			ct: container{Names: []string{"/wiki"}, Labels: map[string]string{
+				"noknok.public":                  "no",
+				"traefik.http.routers.b.rule":    "Host(`second.example.com`)",
+				"traefik.http.routers.a.rule":    "Host(`wiki.example.com`) && PathPrefix(`/`)",
+				"traefik.http.routers.a.service": "wiki",
+			}},
+			want: database.Service{Slug: "wiki", Name: "wiki", AdminRole: "admin", URL: "https://wiki.example.com"},
+			ok:   true,
+		},
+		{
+			name: "no noknok labels",
+			ct:   container{Names: []string{"/db"}, Labels: map[string]string{"traefik.http.routers.db.rule": "Host(`db.example.com`)"}},
+		},
+		{
+			name: "no url",
+			ct:   container{Names: []string{"/job"}, Labels: map[string]string{"noknok.name": "Job"}},
+		},
+		{
+			name: "no slug",
+			ct:   container{Labels: map[string]string{"noknok.url": "https://x.example.com"}},
+		},
+	}
+	for _, tc := range cases {
+		got, ok := serviceFromLabels(tc.ct)
+		if ok != tc.ok || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: serviceFromLabels = %+v, %v; want %+v, %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	sock := fakeDocker(t, containersHandler([]container{
+		{Names: []string{"/gitea"}, Labels: map[string]string{"noknok.url": "https://git.example.com"}},
+		{Names: []string{"/postgres"}, Labels: map[string]string{"com.docker.compose.service": "db"}},
+		{Names: []string{"/manual"}, Labels: map[string]string{"noknok.url": "https://manual.example.com"}},
+	}))
+	st := newFakeStore()
+	st.notOwned["manual"] = true
+	d := NewDocker(st, sock, time.Hour)
+
+	if err := d.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.upserted) != 2 || st.upserted[0].Slug != "gitea" || st.upserted[1].Slug != "manual" {
+		t.Errorf("upserted = %+v, want gitea and manual", st.upserted)
+	}
+	// A slug owned by another source still counts as present.
+	if want := [][]string{{"gitea", "manual"}}; !reflect.DeepEqual(st.present, want) {
+		t.Errorf("present = %q, want %q", st.present, want)
+	}
+}
+
+func TestSyncDockerError(t *testing.T) {
+	sock := fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	st := newFakeStore()
+	d := NewDocker(st, sock, time.Hour)
+
+	if err := d.Sync(context.Background()); err == nil {
+		t.Fatal("expected an error when Docker fails")
+	}
+	// Services must not be disabled because Docker could not be listed.
+	if len(st.present) != 0 {
+		t.Errorf("DisableMissingServices called %d times, want 0", len(st.present))
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	labelled := event{Type: "container", Action: "start"}
+	labelled.Actor.ID = "abc"
+	labelled.Actor.Attributes = map[string]string{"name": "gitea", "noknok.slug": "git"}
+	plain := event{Type: "container", Action: "start"}
+	plain.Actor.Attributes = map[string]string{"name": "postgres"}
+	network := event{Type: "network", Action: "connect"}
+	network.Actor.Attributes = map[string]string{"noknok.slug": "git"}
+
+	cases := []struct {
+		name   string
+		events []event
+		wake   bool
+	}{
+		{"labelled container", []event{plain, labelled}, true},
+		{"unlabelled container", []event{plain}, false},
+		{"not a container", []event{network}, false},
+	}
+	for _, tc := range cases {
+		var gotFilters string
+		sock := fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotFilters = r.URL.Query().Get("filters")
+			enc := json.NewEncoder(w)
+			for _, ev := range tc.events {
+				enc.Encode(ev)
+			}
+		}))
+		d := NewDocker(newFakeStore(), sock, time.Hour)
+
+		// The stream ends when the handler returns.
+		if err := d.streamEvents(context.Background()); err == nil {
+			t.Errorf("%s: expected an error at the end of the stream", tc.name)
+		}
+		if gotFilters != eventFilters {
+			t.Errorf("%s: filters = %q, want %q", tc.name, gotFilters, eventFilters)
+		}
+		if woke := len(d.wake) == 1; woke != tc.wake {
+			t.Errorf("%s: sync triggered = %v, want %v", tc.name, woke, tc.wake)
+		}
+	}
+}
+
+func TestStartSyncsOnEvent(t *testing.T) {
+	var mu sync.Mutex
+	cts := []container{}
+	send := make(chan event)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(cts)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case ev := <-send:
+				json.NewEncoder(w).Encode(ev)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	st := newFakeStore()
+	d := NewDocker(st, fakeDocker(t, mux), time.Hour)
+	d.Start()
+	defer d.Stop()
+
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case <-st.synced:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	wait("the initial sync")
+
+	mu.Lock()
+	cts = []container{{Names: []string{"/gitea"}, Labels: map[string]string{"noknok.url": "https://git.example.com"}}}
+	mu.Unlock()
+	ev := event{Type: "container", Action: "start"}
+	ev.Actor.Attributes = map[string]string{"noknok.url": "https://git.example.com"}
+	select {
+	case send <- ev:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event stream was never opened")
+	}
+	wait("the sync after the start event")
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if last := st.present[len(st.present)-1]; !reflect.DeepEqual(last, []string{"gitea"}) {
+		t.Errorf("present after event = %q, want [gitea]", last)
+	}
+}
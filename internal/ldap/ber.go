@@ -0,0 +1,199 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER classes and the universal tags LDAP uses.
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+
+	tagBoolean     = 1
+	tagInteger     = 2
+	tagOctetString = 4
+	tagNull        = 5
+	tagEnumerated  = 10
+	tagSequence    = 16
+	tagSet         = 17
+)
+
+// maxMessageSize bounds a single LDAP message read from a client.
+const maxMessageSize = 1 << 20
+
+// maxDepth bounds how deeply constructed elements may nest. LDAP messages
+// need a handful of levels; nested filters a few more.
+const maxDepth = 32
+
+// packet is a decoded BER element. Primitive elements carry value;
+// constructed ones carry children.
+type packet struct {
+	class       byte
+	constructed bool
+	tag         int
+	value       []byte
+	children    []*packet
+}
+
+func (p *packet) is(class byte, tag int) bool { return p.class == class && p.tag == tag }
+
+func (p *packet) str() string { return string(p.value) }
+
+func (p *packet) int() (int64, error) {
+	if len(p.value) == 0 || len(p.value) > 8 {
+		return 0, fmt.Errorf("invalid integer length %d", len(p.value))
+	}
+	n := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+func (p *packet) bool() bool { return len(p.value) > 0 && p.value[0] != 0 }
+
+// readMessage reads one complete BER element from r and returns its bytes.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if id&0x1f == 0x1f {
+		return nil, errors.New("multi-byte tags are not supported")
+	}
+	head := []byte{id}
+	l, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	head = append(head, l)
+	length := int(l)
+	if l&0x80 != 0 {
+		n := int(l & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errors.New("unsupported length encoding")
+		}
+		length = 0
+		for range n {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			head = append(head, b)
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds limit", length)
+	}
+	buf := make([]byte, len(head)+length)
+	copy(buf, head)
+	if _, err := io.ReadFull(r, buf[len(head):]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// decode parses one BER element from data and returns it with the bytes
+// that follow it.
+func decode(data []byte) (*packet, []byte, error) {
+	return decodeDepth(data, 0)
+}
+
+func decodeDepth(data []byte, depth int) (*packet, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, errors.New("elements nested too deeply")
+	}
+	if len(data) < 2 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	id := data[0]
+	if id&0x1f == 0x1f {
+		return nil, nil, errors.New("multi-byte tags are not supported")
+	}
+	p := &packet{class: id & 0xc0, constructed: id&0x20 != 0, tag: int(id & 0x1f)}
+
+	length, off := int(data[1]), 2
+	if data[1]&0x80 != 0 {
+		n := int(data[1] & 0x7f)
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return nil, nil, errors.New("invalid length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		off = 2 + n
+	}
+	if length < 0 || len(data)-off < length {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	content, rest := data[off:off+length], data[off+length:]
+
+	if !p.constructed {
+		p.value = content
+		return p, rest, nil
+	}
+	for len(content) > 0 {
+		child, r, err := decodeDepth(content, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.children = append(p.children, child)
+		content = r
+	}
+	return p, rest, nil
+}
+
+// encode serializes p.
+func (p *packet) encode() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.encode()...)
+		}
+	}
+	id := p.class | byte(p.tag)
+	if p.constructed {
+		id |= 0x20
+	}
+	out := []byte{id}
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+func seq(class byte, tag int, children ...*packet) *packet {
+	return &packet{class: class, constructed: true, tag: tag, children: children}
+}
+
+func octets(class byte, tag int, s string) *packet {
+	return &packet{class: class, tag: tag, value: []byte(s)}
+}
+
+func integer(class byte, tag int, n int64) *packet {
+	// Minimal two's complement: stop once the remaining bits are pure sign
+	// extension of the leading byte.
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return &packet{class: class, tag: tag, value: b}
+}
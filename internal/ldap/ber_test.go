@@ -0,0 +1,146 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	msg := seq(classUniversal, tagSequence,
+		integer(classUniversal, tagInteger, 7),
+		seq(classApplication, opBindRequest,
+			integer(classUniversal, tagInteger, 3),
+			octets(classUniversal, tagOctetString, "uid=alice,ou=users,dc=example"),
+			octets(classContext, 0, "secret"),
+		),
+	)
+	raw := msg.encode()
+
+	p, rest, err := decode(raw)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("rest = %d bytes, want 0", len(rest))
+	}
+	if !p.is(classUniversal, tagSequence) || len(p.children) != 2 {
+		t.Fatalf("unexpected envelope %+v", p)
+	}
+	if id, err := p.children[0].int(); err != nil || id != 7 {
+		t.Fatalf("message id = %d, %v", id, err)
+	}
+	bind := p.children[1]
+	if !bind.is(classApplication, opBindRequest) || len(bind.children) != 3 {
+		t.Fatalf("unexpected bind %+v", bind)
+	}
+	if got := bind.children[1].str(); got != "uid=alice,ou=users,dc=example" {
+		t.Errorf("dn = %q", got)
+	}
+	if got := bind.children[2].str(); got != "secret" {
+		t.Errorf("password = %q", got)
+	}
+	if !bytes.Equal(p.encode(), raw) {
+		t.Error("re-encoding differs from the original")
+	}
+}
+
+func TestDecodeLongLength(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 300))
+	raw := octets(classUniversal, tagOctetString, long).encode()
+	if raw[1] != 0x82 {
+		t.Fatalf("length byte = %#x, want 0x82", raw[1])
+	}
+	p, _, err := decode(raw)
+	if err != nil || p.str() != long {
+		t.Fatalf("decode = %q, %v", p.str(), err)
+	}
+}
+
+func TestIntegerEncoding(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40} {
+		p, _, err := decode(integer(classUniversal, tagInteger, n).encode())
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+		if got, err := p.int(); err != nil || got != n {
+			t.Errorf("%d: got %d, %v", n, got, err)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	cases := map[string][]byte{
+		"empty":           {},
+		"short":           {0x30},
+		"truncated":       {0x04, 0x05, 'a', 'b'},
+		"multi-byte tag":  {0x1f, 0x01, 0x00},
+		"indefinite":      {0x30, 0x80, 0x00, 0x00},
+		"length too long": {0x04, 0x85, 1, 0, 0, 0, 0},
+		"bad child":       {0x30, 0x03, 0x04, 0x05, 'a'},
+	}
+	for name, raw := range cases {
+		if _, _, err := decode(raw); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDecodeDepthLimit(t *testing.T) {
+	nest := func(depth int) []byte {
+		p := octets(classUniversal, tagOctetString, "x")
+		for range depth {
+			p = seq(classUniversal, tagSequence, p)
+		}
+		return p.encode()
+	}
+	if _, _, err := decode(nest(maxDepth)); err != nil {
+		t.Fatalf("depth %d: %v", maxDepth, err)
+	}
+	if _, _, err := decode(nest(maxDepth + 1)); err == nil {
+		t.Fatalf("depth %d: expected an error", maxDepth+1)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	one := seq(classUniversal, tagSequence, integer(classUniversal, tagInteger, 1)).encode()
+	two := seq(classUniversal, tagSequence, integer(classUniversal, tagInteger, 2)).encode()
+	r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, one...), two...)))
+	for _, want := range [][]byte{one, two} {
+		got, err := readMessage(r)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("readMessage = %x, %v; want %x", got, err, want)
+		}
+	}
+
+	huge := []byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xff}
+	if _, err := readMessage(bufio.NewReader(bytes.NewReader(huge))); err == nil {
+		t.Fatal("expected an error for an oversized message")
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(seq(classUniversal, tagSequence,
+		integer(classUniversal, tagInteger, 1),
+		seq(classApplication, opSearchRequest,
+			octets(classUniversal, tagOctetString, "dc=example"),
+			seq(classContext, 0, octets(classContext, 7, "uid")),
+		),
+	).encode())
+	f.Add([]byte{0x30, 0x80})
+	f.Add([]byte{0x04, 0x84, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, rest, err := decode(data)
+		if err != nil {
+			return
+		}
+		if len(rest) > len(data) {
+			t.Fatalf("rest longer than input")
+		}
+		// Whatever decodes must survive the code paths that walk packets.
+		p.int()
+		if f, err := parseFilter(p); err == nil {
+			f.match(&Entry{DN: "uid=a", Attrs: []Attribute{{Name: "uid", Values: []string{"a"}}}})
+		}
+	})
+}
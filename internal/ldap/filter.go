@@ -0,0 +1,134 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filter is a decoded search filter (RFC 4511 4.5.1.7).
+type filter struct {
+	tag      int // context tag: 0 and, 1 or, 2 not, 3 equality, 4 substrings, 5 ge, 6 le, 7 present, 8 approx, 9 extensible
+	children []*filter
+	attr     string
+	value    string
+	initial  string
+	any      []string
+	final    string
+}
+
+func parseFilter(p *packet) (*filter, error) {
+	if p.class != classContext {
+		return nil, fmt.Errorf("invalid filter class")
+	}
+	f := &filter{tag: p.tag}
+	switch p.tag {
+	case 0, 1:
+		for _, c := range p.children {
+			cf, err := parseFilter(c)
+			if err != nil {
+				return nil, err
+			}
+			f.children = append(f.children, cf)
+		}
+	case 2:
+		if len(p.children) != 1 {
+			return nil, fmt.Errorf("not filter needs one operand")
+		}
+		cf, err := parseFilter(p.children[0])
+		if err != nil {
+			return nil, err
+		}
+		f.children = []*filter{cf}
+	case 3, 5, 6, 8:
+		if len(p.children) != 2 {
+			return nil, fmt.Errorf("malformed attribute value assertion")
+		}
+		f.attr, f.value = p.children[0].str(), p.children[1].str()
+	case 4:
+		if len(p.children) != 2 {
+			return nil, fmt.Errorf("malformed substrings filter")
+		}
+		f.attr = p.children[0].str()
+		for _, s := range p.children[1].children {
+			switch s.tag {
+			case 0:
+				f.initial = s.str()
+			case 1:
+				f.any = append(f.any, s.str())
+			case 2:
+				f.final = s.str()
+			}
+		}
+	case 7:
+		f.attr = p.str()
+	case 9:
+		// Extensible matching is not supported and never matches.
+	default:
+		return nil, fmt.Errorf("unknown filter type %d", p.tag)
+	}
+	return f, nil
+}
+
+func (f *filter) match(e *Entry) bool {
+	switch f.tag {
+	case 0:
+		for _, c := range f.children {
+			if !c.match(e) {
+				return false
+			}
+		}
+		return true
+	case 1:
+		for _, c := range f.children {
+			if c.match(e) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return !f.children[0].match(e)
+	case 7:
+		return strings.EqualFold(f.attr, "objectClass") || len(e.Get(f.attr)) > 0
+	case 9:
+		return false
+	}
+
+	want := strings.ToLower(f.value)
+	for _, v := range e.Get(f.attr) {
+		v = strings.ToLower(v)
+		var ok bool
+		switch f.tag {
+		case 3, 8:
+			ok = v == want
+		case 4:
+			ok = f.matchSubstrings(v)
+		case 5:
+			ok = v >= want
+		case 6:
+			ok = v <= want
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSubstrings matches an already lowercased value against
+// initial*any*...*final.
+func (f *filter) matchSubstrings(v string) bool {
+	initial := strings.ToLower(f.initial)
+	if !strings.HasPrefix(v, initial) {
+		return false
+	}
+	v = v[len(initial):]
+	for _, a := range f.any {
+		a = strings.ToLower(a)
+		i := strings.Index(v, a)
+		if i < 0 {
+			return false
+		}
+		v = v[i+len(a):]
+	}
+	return strings.HasSuffix(v, strings.ToLower(f.final))
+}
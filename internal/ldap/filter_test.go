@@ -0,0 +1,80 @@
+package ldap
+
+import "testing"
+
+func eq(attr, value string) *packet {
+	return seq(classContext, 3, octets(classUniversal, tagOctetString, attr), octets(classUniversal, tagOctetString, value))
+}
+
+func present(attr string) *packet {
+	return octets(classContext, 7, attr)
+}
+
+func substr(attr string, parts ...*packet) *packet {
+	return seq(classContext, 4, octets(classUniversal, tagOctetString, attr), seq(classUniversal, tagSequence, parts...))
+}
+
+func TestFilterMatch(t *testing.T) {
+	alice := &Entry{DN: "uid=alice,ou=users,dc=example", Attrs: []Attribute{
+		{Name: "uid", Values: []string{"alice"}},
+		{Name: "objectClass", Values: []string{"inetOrgPerson"}},
+		{Name: "mail", Values: []string{"Alice@Example.org"}},
+		{Name: "memberOf", Values: []string{"cn=git,ou=groups,dc=example", "cn=wiki,ou=groups,dc=example"}},
+	}}
+
+	cases := []struct {
+		name string
+		f    *packet
+		want bool
+	}{
+		{"equality", eq("uid", "alice"), true},
+		{"equality case-insensitive", eq("UID", "ALICE"), true},
+		{"equality miss", eq("uid", "bob"), false},
+		{"multi-valued", eq("memberOf", "cn=wiki,ou=groups,dc=example"), true},
+		{"present", present("mail"), true},
+		{"present objectClass", present("objectclass"), true},
+		{"present missing", present("telephoneNumber"), false},
+		{"and", seq(classContext, 0, eq("uid", "alice"), present("mail")), true},
+		{"and miss", seq(classContext, 0, eq("uid", "alice"), eq("uid", "bob")), false},
+		{"or", seq(classContext, 1, eq("uid", "bob"), eq("uid", "alice")), true},
+		{"not", seq(classContext, 2, eq("uid", "bob")), true},
+		{"substring initial", substr("mail", octets(classContext, 0, "alice")), true},
+		{"substring any final", substr("mail", octets(classContext, 1, "@"), octets(classContext, 2, ".org")), true},
+		{"substring order", substr("mail", octets(classContext, 0, "example"), octets(classContext, 2, "alice")), false},
+		{"greater or equal", seq(classContext, 5, octets(classUniversal, tagOctetString, "uid"), octets(classUniversal, tagOctetString, "aaa")), true},
+		{"less or equal", seq(classContext, 6, octets(classUniversal, tagOctetString, "uid"), octets(classUniversal, tagOctetString, "aaa")), false},
+		{"extensible never matches", seq(classContext, 9, octets(classContext, 1, "caseExactMatch")), false},
+	}
+	for _, tc := range cases {
+		p, _, err := decode(tc.f.encode())
+		if err != nil {
+			t.Fatalf("%s: decode: %v", tc.name, err)
+		}
+		f, err := parseFilter(p)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", tc.name, err)
+		}
+		if got := f.match(alice); got != tc.want {
+			t.Errorf("%s: match = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	cases := map[string]*packet{
+		"universal class": octets(classUniversal, tagOctetString, "uid"),
+		"empty not":       seq(classContext, 2),
+		"short equality":  seq(classContext, 3, octets(classUniversal, tagOctetString, "uid")),
+		"short substring": seq(classContext, 4, octets(classUniversal, tagOctetString, "uid")),
+		"unknown tag":     octets(classContext, 12, "x"),
+	}
+	for name, f := range cases {
+		p, _, err := decode(f.encode())
+		if err != nil {
+			t.Fatalf("%s: decode: %v", name, err)
+		}
+		if _, err := parseFilter(p); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+// Package ldap is a minimal read-only LDAPv3 server (RFC 4511): simple
+// bind, search, WhoAmI and unbind. Every write operation is refused. The
+// directory itself comes from a Backend.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result codes.
+const (
+	resultSuccess                 = 0
+	resultOperationsError         = 1
+	resultProtocolError           = 2
+	resultSizeLimitExceeded       = 4
+	resultAuthMethodNotSupported  = 7
+	resultNoSuchObject            = 32
+	resultInvalidCredentials      = 49
+	resultInsufficientAccessRight = 50
+	resultUnwillingToPerform      = 53
+)
+
+// Protocol operations ([APPLICATION n]).
+const (
+	opBindRequest     = 0
+	opBindResponse    = 1
+	opUnbindRequest   = 2
+	opSearchRequest   = 3
+	opSearchEntry     = 4
+	opSearchDone      = 5
+	opModifyRequest   = 6
+	opAddRequest      = 8
+	opDelRequest      = 10
+	opModDNRequest    = 12
+	opCompareRequest  = 14
+	opAbandonRequest  = 16
+	opExtendedRequest = 23
+	opExtendedResp    = 24
+)
+
+const oidWhoAmI = "1.3.6.1.4.1.4203.1.11.3"
+
+// idleTimeout closes connections that send nothing for this long.
+const idleTimeout = 5 * time.Minute
+
+// Attribute is one attribute of an entry.
+type Attribute struct {
+	Name   string
+	Values []string
+}
+
+// Entry is a directory entry.
+type Entry struct {
+	DN    string
+	Attrs []Attribute
+}
+
+// Get returns the values of the named attribute (case-insensitive).
+func (e *Entry) Get(name string) []string {
+	for _, a := range e.Attrs {
+		if strings.EqualFold(a.Name, name) {
+			return a.Values
+		}
+	}
+	return nil
+}
+
+// Backend supplies the directory and checks credentials.
+type Backend interface {
+	// Bind reports whether password is valid for the given bind DN.
+	Bind(ctx context.Context, dn, password string) (bool, error)
+	// Entries returns every entry below and including the base DN.
+	Entries(ctx context.Context) ([]Entry, error)
+}
+
+// Config configures a Server.
+type Config struct {
+	BaseDN         string
+	AllowAnonymous bool // allow searches without a bind
+}
+
+// Server serves LDAP connections.
+type Server struct {
+	cfg     Config
+	backend Backend
+	limiter *bindLimiter
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewServer returns a server answering from backend.
+func NewServer(backend Backend, cfg Config) *Server {
+	return &Server{cfg: cfg, backend: backend, limiter: newBindLimiter(), conns: map[net.Conn]struct{}{}}
+}
+
+// Serve accepts connections on ln until Close.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting, drops open connections and waits for them to end.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// conn is the per-connection state.
+type conn struct {
+	s     *Server
+	c     net.Conn
+	bound string // normalized DN of the last successful bind, "" if anonymous
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer nc.Close()
+	cn := &conn{s: s, c: nc}
+	r := bufio.NewReader(nc)
+	for {
+		nc.SetReadDeadline(time.Now().Add(idleTimeout))
+		raw, err := readMessage(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("ldap: read failed", "remote", nc.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		msg, _, err := decode(raw)
+		if err != nil || !msg.is(classUniversal, tagSequence) || len(msg.children) < 2 {
+			slog.Debug("ldap: malformed message", "remote", nc.RemoteAddr(), "error", err)
+			return
+		}
+		id, err := msg.children[0].int()
+		if err != nil {
+			return
+		}
+		op := msg.children[1]
+		if op.class != classApplication {
+			return
+		}
+		if !cn.handle(id, op) {
+			return
+		}
+	}
+}
+
+// handle answers one request and reports whether to keep the connection.
+func (cn *conn) handle(id int64, op *packet) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	switch op.tag {
+	case opBindRequest:
+		return cn.bind(ctx, id, op)
+	case opUnbindRequest:
+		return false
+	case opSearchRequest:
+		return cn.search(ctx, id, op)
+	case opAbandonRequest:
+		return true // requests are answered synchronously; nothing to abandon
+	case opExtendedRequest:
+		return cn.extended(id, op)
+	case opModifyRequest, opAddRequest, opDelRequest, opModDNRequest:
+		return cn.send(id, result(op.tag+1, resultUnwillingToPerform, "", "directory is read-only"))
+	case opCompareRequest:
+		return cn.send(id, result(op.tag+1, resultUnwillingToPerform, "", "compare is not supported"))
+	default:
+		cn.send(id, result(opExtendedResp, resultProtocolError, "", "unsupported operation"))
+		return false
+	}
+}
+
+func (cn *conn) send(id int64, op *packet) bool {
+	msg := seq(classUniversal, tagSequence, integer(classUniversal, tagInteger, id), op)
+	cn.c.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := cn.c.Write(msg.encode())
+	return err == nil
+}
+
+// result builds an LDAPResult-shaped response.
+func result(op, code int, matchedDN, diag string, extra ...*packet) *packet {
+	children := []*packet{
+		integer(classUniversal, tagEnumerated, int64(code)),
+		octets(classUniversal, tagOctetString, matchedDN),
+		octets(classUniversal, tagOctetString, diag),
+	}
+	return seq(classApplication, op, append(children, extra...)...)
+}
+
+func (cn *conn) bind(ctx context.Context, id int64, op *packet) bool {
+	if len(op.children) < 3 {
+		return cn.send(id, result(opBindResponse, resultProtocolError, "", "malformed bind"))
+	}
+	if v, _ := op.children[0].int(); v != 3 {
+		return cn.send(id, result(opBindResponse, resultProtocolError, "", "only LDAPv3 is supported"))
+	}
+	dn := op.children[1].str()
+	auth := op.children[2]
+	cn.bound = ""
+	if !auth.is(classContext, 0) {
+		return cn.send(id, result(opBindResponse, resultAuthMethodNotSupported, "", "only simple bind is supported"))
+	}
+	password := auth.str()
+
+	switch {
+	case dn == "" && password == "":
+		return cn.send(id, result(opBindResponse, resultSuccess, "", ""))
+	case password == "":
+		// An unauthenticated bind (RFC 4513 5.1.2) would look like success
+		// to naive clients that only check the result code.
+		return cn.send(id, result(opBindResponse, resultUnwillingToPerform, "", "unauthenticated bind is not allowed"))
+	}
+
+	ip := remoteIP(cn.c.RemoteAddr())
+	if !cn.s.limiter.allowed(ip, dn) {
+		slog.Warn("ldap: bind throttled", "dn", dn, "remote", cn.c.RemoteAddr())
+		return cn.send(id, result(opBindResponse, resultInvalidCredentials, "", ""))
+	}
+	ok, err := cn.s.backend.Bind(ctx, dn, password)
+	if err != nil {
+		slog.Warn("ldap: bind failed", "dn", dn, "error", err)
+		return cn.send(id, result(opBindResponse, resultOperationsError, "", "internal error"))
+	}
+	if !ok {
+		cn.s.limiter.failed(ip, dn)
+		slog.Info("ldap: invalid credentials", "dn", dn, "remote", cn.c.RemoteAddr())
+		return cn.send(id, result(opBindResponse, resultInvalidCredentials, "", ""))
+	}
+	cn.s.limiter.succeeded(dn)
+	cn.bound = NormalizeDN(dn)
+	slog.Info("ldap: bind", "dn", cn.bound, "remote", cn.c.RemoteAddr())
+	return cn.send(id, result(opBindResponse, resultSuccess, "", ""))
+}
+
+func (cn *conn) extended(id int64, op *packet) bool {
+	name := ""
+	if len(op.children) > 0 && op.children[0].is(classContext, 0) {
+		name = op.children[0].str()
+	}
+	if name != oidWhoAmI {
+		return cn.send(id, result(opExtendedResp, resultProtocolError, "", "unsupported extended operation "+name))
+	}
+	authzID := ""
+	if cn.bound != "" {
+		authzID = "dn:" + cn.bound
+	}
+	return cn.send(id, result(opExtendedResp, resultSuccess, "", "", octets(classContext, 11, authzID)))
+}
+
+func (cn *conn) search(ctx context.Context, id int64, op *packet) bool {
+	if len(op.children) < 8 {
+		return cn.send(id, result(opSearchDone, resultProtocolError, "", "malformed search"))
+	}
+	base := NormalizeDN(op.children[0].str())
+	scope, _ := op.children[1].int()
+	sizeLimit, _ := op.children[3].int()
+	typesOnly := op.children[5].bool()
+	filter, err := parseFilter(op.children[6])
+	if err != nil {
+		return cn.send(id, result(opSearchDone, resultProtocolError, "", err.Error()))
+	}
+	var attrs []string
+	for _, a := range op.children[7].children {
+		attrs = append(attrs, a.str())
+	}
+
+	// The root DSE is readable by anyone so clients can discover the base.
+	if base == "" && scope == 0 {
+		root := Entry{DN: "", Attrs: []Attribute{
+			{"objectClass", []string{"top"}},
+			{"namingContexts", []string{cn.s.cfg.BaseDN}},
+			{"supportedLDAPVersion", []string{"3"}},
+			{"supportedExtension", []string{oidWhoAmI}},
+			{"vendorName", []string{"noknok"}},
+		}}
+		if filter.match(&root) && !cn.sendEntry(id, &root, attrs, typesOnly) {
+			return false
+		}
+		return cn.send(id, result(opSearchDone, resultSuccess, "", ""))
+	}
+
+	if cn.bound == "" && !cn.s.cfg.AllowAnonymous {
+		return cn.send(id, result(opSearchDone, resultInsufficientAccessRight, "", "bind required"))
+	}
+
+	entries, err := cn.s.backend.Entries(ctx)
+	if err != nil {
+		slog.Warn("ldap: search failed", "error", err)
+		return cn.send(id, result(opSearchDone, resultOperationsError, "", "internal error"))
+	}
+
+	baseFound := false
+	for i := range entries {
+		if NormalizeDN(entries[i].DN) == base {
+			baseFound = true
+			break
+		}
+	}
+	if !baseFound {
+		return cn.send(id, result(opSearchDone, resultNoSuchObject, NormalizeDN(cn.s.cfg.BaseDN), ""))
+	}
+
+	sent := int64(0)
+	for i := range entries {
+		e := &entries[i]
+		if !inScope(NormalizeDN(e.DN), base, scope) || !filter.match(e) {
+			continue
+		}
+		if sizeLimit > 0 && sent >= sizeLimit {
+			return cn.send(id, result(opSearchDone, resultSizeLimitExceeded, "", ""))
+		}
+		if !cn.sendEntry(id, e, attrs, typesOnly) {
+			return false
+		}
+		sent++
+	}
+	return cn.send(id, result(opSearchDone, resultSuccess, "", ""))
+}
+
+// sendEntry writes a SearchResultEntry with the requested attributes: all
+// of them for an empty list or "*", none for "1.1".
+func (cn *conn) sendEntry(id int64, e *Entry, want []string, typesOnly bool) bool {
+	all := len(want) == 0
+	for _, w := range want {
+		if w == "*" {
+			all = true
+		}
+	}
+	var list []*packet
+	for _, a := range e.Attrs {
+		if len(a.Values) == 0 || (!all && !containsFold(want, a.Name)) {
+			continue
+		}
+		vals := seq(classUniversal, tagSet)
+		if !typesOnly {
+			for _, v := range a.Values {
+				vals.children = append(vals.children, octets(classUniversal, tagOctetString, v))
+			}
+		}
+		list = append(list, seq(classUniversal, tagSequence, octets(classUniversal, tagOctetString, a.Name), vals))
+	}
+	return cn.send(id, seq(classApplication, opSearchEntry,
+		octets(classUniversal, tagOctetString, e.DN),
+		seq(classUniversal, tagSequence, list...),
+	))
+}
+
+func inScope(dn, base string, scope int64) bool {
+	switch scope {
+	case 0: // baseObject
+		return dn == base
+	case 1: // singleLevel
+		_, parent, _ := strings.Cut(dn, ",")
+		return dn != "" && parent == base
+	default: // wholeSubtree
+		return dn == base || base == "" || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+// NormalizeDN lowercases a DN and removes spaces around separators, so
+// "UID=Alice, OU=users,DC=noknok" compares equal to "uid=alice,ou=users,dc=noknok".
+// Escaped commas are not handled; noknok's names never contain them.
+func NormalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		k, v, _ := strings.Cut(p, "=")
+		parts[i] = strings.ToLower(strings.TrimSpace(k)) + "=" + strings.ToLower(strings.TrimSpace(v))
+	}
+	if strings.TrimSpace(dn) == "" {
+		return ""
+	}
+	return strings.Join(parts, ",")
+}
+
+// remoteIP returns the address of a peer without its port.
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenAndServe listens on addr, with TLS (LDAPS) when tlsConfig is set.
+func (s *Server) ListenAndServe(addr string, tlsConfig *tls.Config) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	return s.Serve(ln)
+}
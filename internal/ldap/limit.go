@@ -0,0 +1,84 @@
+package ldap
+
+import (
+	"sync"
+	"time"
+)
+
+// Failed binds are limited per client address and per bind DN: once a key
+// reaches its limit within bindWindow, further binds for it are refused
+// without consulting the backend until the window has passed.
+const (
+	maxBindFailuresPerIP = 20
+	maxBindFailuresPerDN = 10
+	bindWindow           = 15 * time.Minute
+	maxLimiterKeys       = 10000 // expired windows are swept beyond this
+)
+
+// bindLimiter counts failed binds in fixed windows.
+type bindLimiter struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	failures map[string]*failWindow
+}
+
+type failWindow struct {
+	n     int
+	start time.Time
+}
+
+func newBindLimiter() *bindLimiter {
+	return &bindLimiter{now: time.Now, failures: map[string]*failWindow{}}
+}
+
+func ipKey(ip string) string { return "ip:" + ip }
+func dnKey(dn string) string { return "dn:" + NormalizeDN(dn) }
+
+// allowed reports whether a bind from ip for dn may be attempted.
+func (l *bindLimiter) allowed(ip, dn string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count(ipKey(ip)) < maxBindFailuresPerIP && l.count(dnKey(dn)) < maxBindFailuresPerDN
+}
+
+// failed records a failed bind.
+func (l *bindLimiter) failed(ip, dn string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.failures) >= maxLimiterKeys {
+		l.sweep()
+	}
+	for _, k := range []string{ipKey(ip), dnKey(dn)} {
+		if l.count(k) == 0 {
+			l.failures[k] = &failWindow{start: l.now()}
+		}
+		l.failures[k].n++
+	}
+}
+
+// succeeded clears the DN's failures. The address keeps its count so one
+// valid account cannot be used to reset guessing against others.
+func (l *bindLimiter) succeeded(dn string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, dnKey(dn))
+}
+
+// count returns the failures for key in the current window.
+func (l *bindLimiter) count(key string) int {
+	w, ok := l.failures[key]
+	if !ok {
+		return 0
+	}
+	if l.now().Sub(w.start) >= bindWindow {
+		delete(l.failures, key)
+		return 0
+	}
+	return w.n
+}
+
+func (l *bindLimiter) sweep() {
+	for k := range l.failures {
+		l.count(k)
+	}
+}
@@ -0,0 +1,49 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBindLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newBindLimiter()
+	l.now = func() time.Time { return now }
+
+	for range maxBindFailuresPerDN {
+		if !l.allowed("10.0.0.1", "uid=alice,ou=users") {
+			t.Fatal("throttled before the DN limit")
+		}
+		l.failed("10.0.0.1", "uid=alice,ou=users")
+	}
+	if l.allowed("10.0.0.2", "UID=Alice, OU=users") {
+		t.Fatal("DN limit not applied to an equivalent DN from another address")
+	}
+	if !l.allowed("10.0.0.1", "uid=bob,ou=users") {
+		t.Fatal("address throttled before its own limit")
+	}
+
+	for range maxBindFailuresPerIP - maxBindFailuresPerDN {
+		l.failed("10.0.0.1", "uid=bob,ou=users")
+	}
+	if l.allowed("10.0.0.1", "uid=carol,ou=users") {
+		t.Fatal("address limit not applied")
+	}
+
+	now = now.Add(bindWindow)
+	if !l.allowed("10.0.0.1", "uid=alice,ou=users") {
+		t.Fatal("limits not lifted after the window")
+	}
+}
+
+func TestBindLimiterSuccessClearsDN(t *testing.T) {
+	l := newBindLimiter()
+	for range maxBindFailuresPerDN - 1 {
+		l.failed("10.0.0.1", "uid=alice")
+	}
+	l.succeeded("uid=alice")
+	l.failed("10.0.0.1", "uid=alice")
+	if !l.allowed("10.0.0.3", "uid=alice") {
+		t.Fatal("DN failures not cleared by a successful bind")
+	}
+}
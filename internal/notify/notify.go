@@ -0,0 +1,120 @@
+// Package notify raises security notifications. Every event lands in the
+// recipient's in-portal inbox, is queued as a signed "notification" webhook
+// event, and is optionally forwarded to external sinks (SMTP).
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/webhook"
+)
+
+// Event kinds.
+const (
+	KindNewDevice     = "login.new_device"
+	KindSessionRevoke = "session.revoked"
+	KindRoleChanged   = "user.role_changed"
+	KindGrantAdded    = "grant.added"
+	KindGrantRemoved  = "grant.removed"
+	KindIdentityAdded = "identity.added"
+	KindAppPassword   = "app_password.created"
+)
+
+// Event is a notification for one user.
+type Event struct {
+	Kind      string         `json:"kind"`
+	UserID    int64          `json:"user_id"`
+	DID       string         `json:"did"`    // recipient's primary DID
+	Handle    string         `json:"handle"` // recipient's primary handle
+	Title     string         `json:"title"`
+	Body      string         `json:"body"`
+	Detail    map[string]any `json:"detail,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// Sink delivers events outside noknok.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, ev Event) error
+}
+
+// Notifier stores events in the inbox and fans them out to webhooks and
+// sinks.
+type Notifier struct {
+	db    *database.DB
+	hooks *webhook.Dispatcher
+	sinks []Sink
+}
+
+// New returns a notifier delivering to the inbox, hooks (may be nil) and
+// the given sinks.
+func New(db *database.DB, hooks *webhook.Dispatcher, sinks ...Sink) *Notifier {
+	return &Notifier{db: db, hooks: hooks, sinks: sinks}
+}
+
+// Notify delivers ev to each recipient. Inbox writes and webhook queueing
+// happen before Notify returns; sinks are sent to in the background so a
+// slow mail server never holds up a request.
+func (n *Notifier) Notify(ctx context.Context, ev Event, userIDs ...int64) {
+	seen := map[int64]bool{}
+	for _, id := range userIDs {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		e := ev
+		e.UserID = id
+		if u, err := n.db.GetUser(ctx, id); err == nil {
+			e.DID, e.Handle = u.DID, u.Handle
+		}
+		detail, _ := json.Marshal(e.Detail)
+		row, err := n.db.CreateNotification(ctx, database.Notification{
+			UserID: id, Kind: e.Kind, Title: e.Title, Body: e.Body, Detail: detail,
+		})
+		if err != nil {
+			slog.Warn("notify: inbox write failed", "kind", e.Kind, "user_id", id, "error", err)
+			e.CreatedAt = time.Now()
+		} else {
+			e.CreatedAt = row.CreatedAt
+		}
+		if n.hooks != nil {
+			n.hooks.Emit(ctx, webhook.EventNotification, e)
+		}
+		n.dispatch(e)
+	}
+}
+
+// NotifyOwners delivers ev to every owner except those in skip (typically
+// the actor and anyone already notified directly).
+func (n *Notifier) NotifyOwners(ctx context.Context, ev Event, skip ...int64) {
+	owners, err := n.db.ListUserIDsByRole(ctx, "owner")
+	if err != nil {
+		slog.Warn("notify: list owners failed", "error", err)
+		return
+	}
+	var ids []int64
+	for _, id := range owners {
+		if !slices.Contains(skip, id) {
+			ids = append(ids, id)
+		}
+	}
+	n.Notify(ctx, ev, ids...)
+}
+
+func (n *Notifier) dispatch(ev Event) {
+	for _, s := range n.sinks {
+		go func(s Sink) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := s.Send(ctx, ev); err != nil {
+				slog.Warn("notify: sink failed", "sink", s.Name(), "kind", ev.Kind, "user_id", ev.UserID, "error", err)
+			}
+		}(s)
+	}
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP mails each event to a fixed list of addresses (users have no email
+// address in noknok, so this is meant for an operator or security inbox).
+type SMTP struct {
+	addr     string // host:port
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+// NewSMTP returns a sink sending through the server at addr. PLAIN auth is
+// used when username is set; net/smtp only allows it over TLS or to localhost.
+func NewSMTP(addr, from string, to []string, username, password string) *SMTP {
+	return &SMTP{addr: addr, from: from, to: to, username: username, password: password}
+}
+
+func (s *SMTP) Name() string { return "smtp" }
+
+func (s *SMTP) Send(ctx context.Context, ev Event) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, _ := net.SplitHostPort(s.addr)
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	who := ev.Handle
+	if who == "" {
+		who = fmt.Sprintf("user %d", ev.UserID)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: [noknok] %s (%s)\r\n", headerSafe(ev.Title), headerSafe(who))
+	fmt.Fprintf(&msg, "Date: %s\r\n", ev.CreatedAt.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(ev.Body + "\r\n\r\n")
+	fmt.Fprintf(&msg, "Account: %s %s\r\nEvent: %s\r\n", who, ev.DID, ev.Kind)
+
+	// net/smtp has no context support; bound the whole exchange instead.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.addr, auth, s.from, s.to, []byte(msg.String())) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func headerSafe(v string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+}
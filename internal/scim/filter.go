@@ -0,0 +1,393 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Attrs is a resource flattened for filtering: lowercased attribute paths
+// ("username", "roles.value", "meta.created") mapped to their values.
+// Booleans are "true"/"false" and timestamps RFC 3339 in UTC, so ordering
+// comparisons on them work as string comparisons.
+type Attrs map[string][]string
+
+// caseExact lists the attributes compared case-sensitively; all others
+// ignore case, as userName and displayName do in the core schema.
+var caseExact = map[string]bool{"id": true, "externalid": true}
+
+// Filter is a parsed filter expression.
+type Filter interface {
+	Match(a Attrs) bool
+}
+
+type logical struct {
+	and         bool
+	left, right Filter
+}
+
+func (l logical) Match(a Attrs) bool {
+	if l.and {
+		return l.left.Match(a) && l.right.Match(a)
+	}
+	return l.left.Match(a) || l.right.Match(a)
+}
+
+type negation struct{ f Filter }
+
+func (n negation) Match(a Attrs) bool { return !n.f.Match(a) }
+
+type comparison struct {
+	attr  string
+	op    string
+	value string
+	null  bool
+}
+
+func (c comparison) Match(a Attrs) bool {
+	vals := a[c.attr]
+	switch {
+	case c.op == "pr":
+		return len(vals) > 0
+	case c.null && c.op == "eq":
+		return len(vals) == 0
+	case c.null && c.op == "ne":
+		return len(vals) > 0
+	case c.op == "ne":
+		return !comparison{attr: c.attr, op: "eq", value: c.value}.Match(a)
+	}
+	want := c.value
+	if !caseExact[c.attr] {
+		want = strings.ToLower(want)
+	}
+	for _, v := range vals {
+		if !caseExact[c.attr] {
+			v = strings.ToLower(v)
+		}
+		var ok bool
+		switch c.op {
+		case "eq":
+			ok = v == want
+		case "co":
+			ok = strings.Contains(v, want)
+		case "sw":
+			ok = strings.HasPrefix(v, want)
+		case "ew":
+			ok = strings.HasSuffix(v, want)
+		case "gt":
+			ok = v > want
+		case "ge":
+			ok = v >= want
+		case "lt":
+			ok = v < want
+		case "le":
+			ok = v <= want
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFilter parses a filter such as
+// `userName eq "alice.bsky.social" and not (roles.value eq "owner")`.
+// Value filters like `members[value eq "5"]` are matched against the
+// flattened sub-attributes ("members.value"), so conditions inside the
+// brackets are not correlated to a single element.
+func ParseFilter(s string) (Filter, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	f, err := p.or("")
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	return f, nil
+}
+
+// Path is a parsed PATCH path: an attribute, an optional value filter
+// selecting elements of a multi-valued attribute, and a sub-attribute.
+type Path struct {
+	Attr   string // lowercased, schema URN prefix removed
+	Filter Filter // evaluated per element against its sub-attributes; nil if none
+	Sub    string // lowercased sub-attribute, "" if none
+}
+
+// ParsePath parses a PATCH path such as `members[value eq "5"]` or
+// `name.givenName`.
+func ParsePath(s string) (Path, error) {
+	var p Path
+	attr, rest, hasFilter := strings.Cut(s, "[")
+	attr = normalizeAttr(attr)
+	if hasFilter {
+		expr, tail, ok := cutClosingBracket(rest)
+		if !ok {
+			return p, fmt.Errorf("unterminated value filter")
+		}
+		f, err := ParseFilter(expr)
+		if err != nil {
+			return p, err
+		}
+		p.Filter = f
+		if tail != "" {
+			if !strings.HasPrefix(tail, ".") {
+				return p, fmt.Errorf("unexpected %q after value filter", tail)
+			}
+			p.Sub = strings.ToLower(tail[1:])
+		}
+	} else if a, sub, ok := strings.Cut(attr, "."); ok {
+		attr, p.Sub = a, sub
+	}
+	if attr == "" {
+		return p, fmt.Errorf("empty attribute")
+	}
+	p.Attr = attr
+	return p, nil
+}
+
+// cutClosingBracket splits s at the "]" closing a value filter, skipping
+// brackets inside quoted strings.
+func cutClosingBracket(s string) (string, string, bool) {
+	inString := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case inString && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			inString = !inString
+		case !inString && s[i] == ']':
+			return s[:i], s[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// normalizeAttr lowercases an attribute path and strips a schema URN prefix
+// ("urn:...:User:userName" becomes "username").
+func normalizeAttr(s string) string {
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		switch ch := s[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(':
+			toks = append(toks, token{tokLParen, "("})
+			i++
+		case ch == ')':
+			toks = append(toks, token{tokRParen, ")"})
+			i++
+		case ch == '[':
+			toks = append(toks, token{tokLBracket, "["})
+			i++
+		case ch == ']':
+			toks = append(toks, token{tokRBracket, "]"})
+			i++
+		case ch == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			var v string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &v); err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[i:j+1])
+			}
+			toks = append(toks, token{tokString, v})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, token{tokWord, s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.toks) {
+		return &p.toks[p.pos]
+	}
+	return nil
+}
+
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t != nil && t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	t := p.peek()
+	if t == nil || t.kind != kind {
+		return fmt.Errorf("expected %q", text)
+	}
+	p.pos++
+	return nil
+}
+
+// or and and take the attribute prefix of an enclosing value filter.
+func (p *parser) or(prefix string) (Filter, error) {
+	f, err := p.and(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.and(prefix)
+		if err != nil {
+			return nil, err
+		}
+		f = logical{and: false, left: f, right: r}
+	}
+	return f, nil
+}
+
+func (p *parser) and(prefix string) (Filter, error) {
+	f, err := p.unary(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		r, err := p.unary(prefix)
+		if err != nil {
+			return nil, err
+		}
+		f = logical{and: true, left: f, right: r}
+	}
+	return f, nil
+}
+
+func (p *parser) unary(prefix string) (Filter, error) {
+	if p.keyword("not") {
+		f, err := p.group(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return negation{f}, nil
+	}
+	if t := p.peek(); t != nil && t.kind == tokLParen {
+		return p.group(prefix)
+	}
+	return p.attrExpr(prefix)
+}
+
+func (p *parser) group(prefix string) (Filter, error) {
+	if err := p.expect(tokLParen, "("); err != nil {
+		return nil, err
+	}
+	f, err := p.or(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) attrExpr(prefix string) (Filter, error) {
+	t := p.peek()
+	if t == nil || t.kind != tokWord {
+		return nil, fmt.Errorf("expected attribute")
+	}
+	p.pos++
+	attr := prefix + normalizeAttr(t.text)
+
+	if n := p.peek(); n != nil && n.kind == tokLBracket {
+		p.pos++
+		f, err := p.or(attr + ".")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRBracket, "]"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	op := p.peek()
+	if op == nil || op.kind != tokWord {
+		return nil, fmt.Errorf("expected operator after %s", t.text)
+	}
+	p.pos++
+	c := comparison{attr: attr, op: strings.ToLower(op.text)}
+	switch c.op {
+	case "pr":
+		return c, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("unknown operator %q", op.text)
+	}
+
+	v := p.peek()
+	if v == nil || (v.kind != tokString && v.kind != tokWord) {
+		return nil, fmt.Errorf("expected value after %s %s", t.text, op.text)
+	}
+	p.pos++
+	switch {
+	case v.kind == tokString:
+		c.value = v.text
+	case v.text == "null":
+		if c.op != "eq" && c.op != "ne" {
+			return nil, fmt.Errorf("null can only be compared with eq or ne")
+		}
+		c.null = true
+	case v.text == "true" || v.text == "false":
+		c.value = v.text
+	default:
+		if !isNumber(v.text) {
+			return nil, fmt.Errorf("invalid value %q", v.text)
+		}
+		c.value = v.text
+	}
+	return c, nil
+}
+
+func isNumber(s string) bool {
+	var f float64
+	return json.Unmarshal([]byte(s), &f) == nil
+}
@@ -0,0 +1,180 @@
+// Package scim holds the SCIM 2.0 (RFC 7643/7644) wire types and the filter
+// and PATCH path parser used by noknok's provisioning API.
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Schema URNs.
+const (
+	SchemaUser          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaUserExtension = "urn:ietf:params:scim:schemas:extension:noknok:2.0:User"
+	SchemaListResponse  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp       = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError         = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaSPConfig      = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType  = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema        = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// ContentType is the media type of every SCIM response.
+const ContentType = "application/scim+json"
+
+// scimType values for Error.
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidValue  = "invalidValue"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrMutability    = "mutability"
+	ErrUniqueness    = "uniqueness"
+	ErrNoTarget      = "noTarget"
+)
+
+// Error is a SCIM error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError returns an error response for an HTTP status.
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{Schemas: []string{SchemaError}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail}
+}
+
+func (e *Error) Error() string { return e.Detail }
+
+// ListResponse is a page of query results.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// Meta is the common resource metadata.
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+// MultiValue is an element of a multi-valued attribute (roles, groups,
+// members, photos).
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// User is a noknok user. userName is the primary identity's handle and
+// externalId its DID.
+type User struct {
+	Schemas     []string       `json:"schemas"`
+	ID          string         `json:"id,omitempty"`
+	ExternalID  string         `json:"externalId,omitempty"`
+	UserName    string         `json:"userName"`
+	DisplayName string         `json:"displayName,omitempty"`
+	NickName    *string        `json:"nickName,omitempty"`
+	Active      *bool          `json:"active,omitempty"`
+	Photos      []MultiValue   `json:"photos,omitempty"`
+	Roles       []MultiValue   `json:"roles,omitempty"`
+	Groups      []MultiValue   `json:"groups,omitempty"`
+	Extension   *UserExtension `json:"urn:ietf:params:scim:schemas:extension:noknok:2.0:User,omitempty"`
+	Meta        *Meta          `json:"meta,omitempty"`
+}
+
+// UserExtension lists every identity linked to a user.
+type UserExtension struct {
+	Identities []Identity `json:"identities"`
+}
+
+// Identity is one linked atproto identity.
+type Identity struct {
+	DID     string `json:"did"`
+	Handle  string `json:"handle"`
+	Primary bool   `json:"primary"`
+}
+
+// Group is a noknok service; its members are the users granted access.
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// PatchRequest is the body of a PATCH.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is one add, remove or replace.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// NormalizedOp returns the lowercased operation name.
+func (o PatchOperation) NormalizedOp() string { return strings.ToLower(o.Op) }
+
+// PrimaryValue returns the primary element's value, or the first one's.
+func PrimaryValue(vs []MultiValue) string {
+	for _, v := range vs {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(vs) > 0 {
+		return vs[0].Value
+	}
+	return ""
+}
+
+// ParseBool accepts a JSON boolean or a "true"/"false" string, which some
+// identity providers send for "active".
+func ParseBool(raw json.RawMessage) (bool, bool) {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b, true
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if v, err := strconv.ParseBool(s); err == nil {
+			return v, true
+		}
+	}
+	return false, false
+}
+
+// ParseValues decodes a multi-valued attribute value, accepting a single
+// object or a bare string in place of a list.
+func ParseValues(raw json.RawMessage) ([]MultiValue, bool) {
+	var vs []MultiValue
+	if json.Unmarshal(raw, &vs) == nil {
+		return vs, true
+	}
+	var v MultiValue
+	if json.Unmarshal(raw, &v) == nil {
+		return []MultiValue{v}, true
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return []MultiValue{{Value: s}}, true
+	}
+	return nil, false
+}
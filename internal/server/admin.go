@@ -28,6 +28,11 @@ if (document.readyState === 'loading') {
 		return ""
 	}
 
+	webhooksTab := ""
+	if role == "owner" {
+		webhooksTab = `<a href="/?admin&tab=webhooks" class="admin-tab` + tabActive("webhooks") + `" data-tab="webhooks">Webhooks</a>`
+	}
+
 	return `
 <!-- Admin Panel -->
 <div id="admin-panel" class="admin-card" style="display:` + display + `">
@@ -39,6 +44,8 @@ if (document.readyState === 'loading') {
     <a href="/?admin&tab=users" class="admin-tab` + tabActive("users") + `" data-tab="users">Users</a>
     <a href="/?admin&tab=services" class="admin-tab` + tabActive("services") + `" data-tab="services">Services</a>
     <a href="/?admin&tab=access" class="admin-tab` + tabActive("access") + `" data-tab="access">Access</a>
+    <a href="/?admin&tab=audit" class="admin-tab` + tabActive("audit") + `" data-tab="audit">Audit</a>
+    ` + webhooksTab + `
   </div>
   <div id="admin-content" class="admin-body">
   </div>
@@ -186,15 +193,74 @@ function loadTab(tab) {
         });
       });
     });
+  } else if (tab === 'audit') {
+    api('GET', '/traefik/audit', null, function(err, data) {
+      if (err) { el.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+      renderAudit(el, data);
+    });
+  } else if (tab === 'webhooks') {
+    api('GET', '/webhooks', null, function(err, data) {
+      if (err) { el.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+      adminData.webhooks = data.webhooks;
+      adminData.eventTypes = data.event_types;
+      renderWebhooks(el);
+    });
   }
 }
 
+function renderAudit(el, data) {
+  var colors = { protected: '#22c55e', unprotected: '#ef4444', public: '#eab308', no_router: '#64748b' };
+  var html = '<table class="admin-tbl"><thead><tr><th>Service</th><th>Host</th><th>Status</th><th>Routers</th></tr></thead><tbody>';
+  for (var i = 0; i < data.services.length; i++) {
+    var s = data.services[i];
+    var routers = '';
+    for (var j = 0; j < s.routers.length; j++) {
+      var r = s.routers[j];
+      var c = r.protected ? '#22c55e' : (r.redirect ? '#64748b' : '#ef4444');
+      var how = r.match === 'regexp' ? ' (regexp)' : (r.match === 'any' ? ' (any host)' : '');
+      routers += '<div style="color:' + c + '">' + esc(r.name) + how + (r.redirect ? ' (redirect)' : '') + '</div>';
+    }
+    html += '<tr><td>' + esc(s.name) + (s.enabled ? '' : ' <span style="color:#64748b;font-size:0.6875rem">(disabled)</span>') + '</td>' +
+      '<td style="font-size:0.75rem;color:#64748b">' + esc(s.host) + '</td>' +
+      '<td style="color:' + (colors[s.status] || '#e2e8f0') + '">' + esc(s.status.replace('_', ' ')) + '</td>' +
+      '<td style="font-size:0.75rem">' + routers + '</td></tr>';
+  }
+  html += '</tbody></table>';
+  if (data.uncatalogued.length) {
+    html += '<div style="margin-top:1rem;color:#ef4444;font-size:0.8125rem">Routers for uncatalogued hosts</div>';
+    html += '<table class="admin-tbl"><thead><tr><th>Router</th><th>Host</th><th>forwardAuth</th></tr></thead><tbody>';
+    for (var k = 0; k < data.uncatalogued.length; k++) {
+      var u = data.uncatalogued[k];
+      html += '<tr><td>' + esc(u.name) + '</td><td style="font-size:0.75rem;color:#64748b">' + esc(u.host) + '</td>' +
+        '<td>' + (u.protected ? 'yes' : (u.redirect ? 'redirect' : 'no')) + '</td></tr>';
+    }
+    html += '</tbody></table>';
+  }
+  if (data.wildcard.length) {
+    html += '<div style="margin-top:1rem;color:#ef4444;font-size:0.8125rem">Routers matching hosts by pattern or serving every host</div>';
+    html += '<table class="admin-tbl"><thead><tr><th>Router</th><th>Matches</th><th>forwardAuth</th></tr></thead><tbody>';
+    for (var w = 0; w < data.wildcard.length; w++) {
+      var x = data.wildcard[w];
+      html += '<tr><td>' + esc(x.name) + '</td><td style="font-size:0.75rem;color:#64748b">' + esc(x.match === 'any' ? 'any host' : x.host) + '</td>' +
+        '<td>' + (x.protected ? 'yes' : (x.redirect ? 'redirect' : 'no')) + '</td></tr>';
+    }
+    html += '</tbody></table>';
+  }
+  html += '<div style="color:#64748b;font-size:0.6875rem;margin-top:0.5rem">Checked ' + esc(new Date(data.checked_at).toLocaleString()) + '</div>';
+  el.innerHTML = html;
+}
+
 function esc(s) {
   var d = document.createElement('div');
   d.textContent = s || '';
   return d.innerHTML;
 }
 
+function pdsHost(u) {
+  if (!u) return '';
+  try { return new URL(u).host; } catch (e) { return u; }
+}
+
 function renderUsers(el) {
   // Sort: owners first, then admins, then users.
   var roleOrder = { owner: 0, admin: 1, user: 2 };
@@ -203,7 +269,7 @@ function renderUsers(el) {
     var ob = roleOrder[b.role] !== undefined ? roleOrder[b.role] : 3;
     return oa - ob;
   });
-  var html = '<table class="admin-tbl"><thead><tr><th style="width:30px"></th><th>Handle</th><th>Username</th><th>Role</th></tr></thead><tbody>';
+  var html = '<table class="admin-tbl"><thead><tr><th style="width:30px"></th><th>Handle</th><th>PDS</th><th>Username</th><th>Role</th></tr></thead><tbody>';
   for (var i = 0; i < adminData.users.length; i++) {
     var u = adminData.users[i];
     var canChangeRole = ROLE === 'owner';
@@ -215,7 +281,12 @@ function renderUsers(el) {
         '<option value="admin"' + (u.role==='admin'?' selected':'') + '>Admin</option>' +
         '<option value="owner"' + (u.role==='owner'?' selected':'') + '>Owner</option></select>'
       : esc(u.role);
-    html += '<tr><td>' + radio + '</td><td>' + esc(u.handle || '(no handle)') + '</td><td>' + usernameCell + '</td><td>' + roleCell + '</td></tr>';
+    var avatar = u.avatar_url ? '<img src="' + esc(u.avatar_url) + '" alt="" style="width:20px;height:20px;border-radius:50%;vertical-align:middle;margin-right:0.375rem">' : '';
+    var nameCell = avatar + (u.display_name
+      ? esc(u.display_name) + ' <span style="color:#64748b;font-size:0.75rem">' + esc(u.handle || '(no handle)') + '</span>'
+      : esc(u.handle || '(no handle)'));
+    var pdsCell = '<span style="color:#94a3b8;font-size:0.75rem">' + esc(pdsHost(u.pds_url)) + '</span>';
+    html += '<tr><td>' + radio + '</td><td>' + nameCell + '</td><td>' + pdsCell + '</td><td>' + usernameCell + '</td><td>' + roleCell + '</td></tr>';
   }
   html += '</tbody></table>';
   html += '<div class="admin-form">' +
@@ -231,7 +302,9 @@ function renderUsers(el) {
     '<div class="admin-form" style="margin-top:0.5rem">' +
     '<input class="admin-input" id="add-identity-handle" placeholder="handle" style="flex:1;min-width:150px">' +
     '<button class="admin-btn" onclick="addIdentity()">Link</button></div>' +
-    '<div id="identities-msg"></div></div>';
+    '<div id="identities-msg"></div>' +
+    '<div style="font-size:0.8125rem;color:#94a3b8;margin:0.75rem 0 0.5rem;font-weight:500">OAuth sessions</div>' +
+    '<div id="oauth-sessions-list"></div></div>';
   el.innerHTML = html;
   // Re-select or auto-select first user.
   var targetId = selectedUserId;
@@ -553,12 +626,35 @@ function loadIdentities(userId) {
       var badge = id.is_primary ? ' <span style="color:#3b82f6;font-size:0.6875rem">(primary)</span>' : '';
       var rmBtn = id.is_primary ? '' : ' <button class="admin-btn-danger" onclick="removeIdentity(' + userId + ',' + id.id + ')" style="margin-left:0.5rem">Remove</button>';
       html += '<div style="display:flex;align-items:center;gap:0.5rem;padding:0.25rem 0;font-size:0.8125rem">' +
-        '<span style="color:#e2e8f0">' + esc(id.handle || id.did) + '</span>' + badge +
+        '<span style="color:#e2e8f0">' + esc(id.display_name ? id.display_name + ' (' + (id.handle || id.did) + ')' : (id.handle || id.did)) + '</span>' + badge +
         '<span style="color:#64748b;font-size:0.6875rem;overflow:hidden;text-overflow:ellipsis;max-width:200px">' + esc(id.did) + '</span>' +
+        (id.pds_url ? '<span style="color:#94a3b8;font-size:0.6875rem">' + esc(pdsHost(id.pds_url)) + '</span>' : '') +
         rmBtn + '</div>';
     }
     list.innerHTML = html || '<div style="color:#64748b;font-size:0.75rem">No identities</div>';
   });
+  loadOAuthSessions(userId);
+}
+
+function loadOAuthSessions(userId) {
+  var list = document.getElementById('oauth-sessions-list');
+  if (!list) return;
+  list.innerHTML = '<div style="color:#64748b;font-size:0.75rem">Loading...</div>';
+  api('GET', '/users/' + userId + '/oauth-sessions', null, function(err, data) {
+    if (err) { list.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+    var html = '';
+    for (var i = 0; i < data.length; i++) {
+      var o = data[i];
+      var state = o.linked
+        ? '<span style="color:#22c55e;font-size:0.6875rem">linked</span>'
+        : '<span style="color:#eab308;font-size:0.6875rem">unlinked</span>';
+      html += '<div style="display:flex;align-items:center;gap:0.5rem;padding:0.25rem 0;font-size:0.8125rem">' +
+        '<span style="color:#e2e8f0">' + esc(o.handle || o.did) + '</span>' + state +
+        '<span style="color:#64748b;font-size:0.6875rem">' + esc(o.authserver_url) + '</span>' +
+        '<span style="color:#64748b;font-size:0.6875rem;margin-left:auto">' + esc(new Date(o.updated_at).toLocaleString()) + '</span></div>';
+    }
+    list.innerHTML = html || '<div style="color:#64748b;font-size:0.75rem">No OAuth sessions</div>';
+  });
 }
 
 function addIdentity() {
@@ -601,10 +697,11 @@ function deleteSelectedUser() {
 }
 
 function renderServices(el) {
-  var html = '<table class="admin-tbl"><thead><tr><th>Name</th><th>Slug</th><th>URL</th><th>Admin Role</th><th></th></tr></thead><tbody>';
+  var html = '<table class="admin-tbl"><thead><tr><th>Name</th><th>Slug</th><th>URL</th><th>Upstream</th><th>Admin Role</th><th></th></tr></thead><tbody>';
   for (var i = 0; i < adminData.services.length; i++) {
     var s = adminData.services[i];
     html += '<tr><td>' + esc(s.name) + '</td><td style="color:#64748b">' + esc(s.slug) + '</td><td style="font-size:0.75rem;color:#64748b">' + esc(s.url) + '</td>' +
+      '<td><input class="admin-input" style="width:130px;font-size:0.75rem" placeholder="(proxy off)" value="' + esc(s.upstream_url) + '" onchange="updateServiceUpstream(' + s.id + ',this.value.trim())"></td>' +
       '<td><input class="admin-input" style="width:70px;font-size:0.75rem" value="' + esc(s.admin_role) + '" onchange="updateServiceAdminRole(' + s.id + ',this.value)"></td>' +
       '<td><button class="admin-btn-danger" onclick="deleteService(' + s.id + ')">Delete</button></td></tr>';
   }
@@ -614,6 +711,7 @@ function renderServices(el) {
     '<input class="admin-input" id="svc-slug" placeholder="slug" style="width:80px" oninput="checkAddService()">' +
     '<input class="admin-input" id="svc-url" placeholder="https://..." style="flex:1;min-width:130px" oninput="checkAddService()">' +
     '<input class="admin-input" id="svc-desc" placeholder="description" style="width:110px">' +
+    '<input class="admin-input" id="svc-upstream" placeholder="upstream (optional)" style="width:130px">' +
     '<input class="admin-input" id="svc-admin-role" placeholder="admin" style="width:70px">' +
     '<button class="admin-btn" id="add-svc-btn" onclick="addService()" disabled style="opacity:0.4;cursor:default">Add</button></div>';
   html += '<div id="services-msg"></div>';
@@ -642,14 +740,16 @@ function addService() {
   var url = document.getElementById('svc-url').value.trim();
   var desc = document.getElementById('svc-desc').value.trim();
   var adminRole = document.getElementById('svc-admin-role').value.trim() || 'admin';
+  var upstream = document.getElementById('svc-upstream').value.trim();
   var msg = document.getElementById('services-msg');
   if (!name || !slug || !url) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = 'Name, slug, and URL required'; return; }
-  api('POST', '/services', { name: name, slug: slug, url: url, description: desc, icon_url: '', admin_role: adminRole }, function(err) {
+  api('POST', '/services', { name: name, slug: slug, url: url, description: desc, icon_url: '', admin_role: adminRole, upstream_url: upstream }, function(err) {
     if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
     document.getElementById('svc-name').value = '';
     document.getElementById('svc-slug').value = '';
     document.getElementById('svc-url').value = '';
     document.getElementById('svc-desc').value = '';
+    document.getElementById('svc-upstream').value = '';
     document.getElementById('svc-admin-role').value = '';
     checkAddService();
     msg.className = 'admin-msg admin-msg-ok'; msg.textContent = 'Service added';
@@ -664,7 +764,7 @@ function updateServiceAdminRole(id, adminRole) {
   }
   if (!svc) return;
   var msg = document.getElementById('services-msg');
-  api('PUT', '/services/' + id, { name: svc.name, description: svc.description, url: svc.url, icon_url: svc.icon_url, admin_role: adminRole }, function(err) {
+  api('PUT', '/services/' + id, { name: svc.name, description: svc.description, url: svc.url, icon_url: svc.icon_url, admin_role: adminRole, upstream_url: svc.upstream_url }, function(err) {
     if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
     svc.admin_role = adminRole;
     msg.className = 'admin-msg admin-msg-ok'; msg.textContent = 'Admin role updated';
@@ -672,6 +772,21 @@ function updateServiceAdminRole(id, adminRole) {
   });
 }
 
+function updateServiceUpstream(id, upstream) {
+  var svc = null;
+  for (var i = 0; i < adminData.services.length; i++) {
+    if (adminData.services[i].id === id) { svc = adminData.services[i]; break; }
+  }
+  if (!svc) return;
+  var msg = document.getElementById('services-msg');
+  api('PUT', '/services/' + id, { name: svc.name, description: svc.description, url: svc.url, icon_url: svc.icon_url, admin_role: svc.admin_role, upstream_url: upstream }, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    svc.upstream_url = upstream;
+    msg.className = 'admin-msg admin-msg-ok'; msg.textContent = 'Upstream updated';
+    setTimeout(function() { msg.className = ''; msg.textContent = ''; }, 1500);
+  });
+}
+
 function deleteService(id) {
   if (!confirm('Delete this service? Grants will also be removed.')) return;
   api('DELETE', '/services/' + id, null, function(err) {
@@ -758,6 +873,115 @@ function updateGrantRole(userId, serviceId, role) {
   });
 }
 
+function renderWebhooks(el) {
+  var html = '<table class="admin-tbl"><thead><tr><th>URL</th><th>Events</th><th>Enabled</th><th></th></tr></thead><tbody>';
+  for (var i = 0; i < adminData.webhooks.length; i++) {
+    var w = adminData.webhooks[i];
+    var events = w.events.length ? w.events.join(', ') : 'all';
+    html += '<tr><td>' + esc(w.url) + (w.description ? '<div style="font-size:0.75rem;color:#64748b">' + esc(w.description) + '</div>' : '') + '</td>' +
+      '<td style="font-size:0.75rem;color:#94a3b8">' + esc(events) + '</td>' +
+      '<td><input type="checkbox" class="access-check"' + (w.enabled ? ' checked' : '') + ' onchange="toggleWebhook(' + w.id + ',this.checked)"></td>' +
+      '<td style="white-space:nowrap"><button class="admin-btn" onclick="testWebhook(' + w.id + ')">Test</button> ' +
+      '<button class="admin-btn" onclick="showDeliveries(' + w.id + ')">Log</button> ' +
+      '<button class="admin-btn" onclick="rotateWebhookSecret(' + w.id + ')">Rotate</button> ' +
+      '<button class="admin-btn-danger" onclick="deleteWebhook(' + w.id + ')">Delete</button></td></tr>';
+  }
+  html += '</tbody></table>';
+  html += '<div class="admin-form">' +
+    '<input class="admin-input" id="wh-url" placeholder="https://..." style="flex:1;min-width:160px">' +
+    '<input class="admin-input" id="wh-desc" placeholder="description" style="width:120px">' +
+    '<input class="admin-input" id="wh-events" placeholder="events (blank = all)" style="width:160px" title="Comma-separated: ' + esc(adminData.eventTypes.join(', ')) + ', prefix.* or *">' +
+    '<button class="admin-btn" onclick="addWebhook()">Add</button></div>';
+  html += '<div id="webhooks-msg"></div><div id="webhook-deliveries"></div>';
+  el.innerHTML = html;
+}
+
+function showWebhookSecret(secret) {
+  var msg = document.getElementById('webhooks-msg');
+  msg.className = 'admin-msg admin-msg-ok';
+  msg.innerHTML = 'Signing secret (shown once): <code style="user-select:all">' + esc(secret) + '</code>';
+}
+
+function addWebhook() {
+  var url = document.getElementById('wh-url').value.trim();
+  var desc = document.getElementById('wh-desc').value.trim();
+  var raw = document.getElementById('wh-events').value.split(',');
+  var events = [];
+  for (var i = 0; i < raw.length; i++) { if (raw[i].trim()) events.push(raw[i].trim()); }
+  var msg = document.getElementById('webhooks-msg');
+  api('POST', '/webhooks', { url: url, description: desc, events: events }, function(err, data) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    adminData.webhooks.push(data);
+    renderWebhooks(document.getElementById('admin-content'));
+    showWebhookSecret(data.secret);
+  });
+}
+
+function toggleWebhook(id, enabled) {
+  var msg = document.getElementById('webhooks-msg');
+  api('PUT', '/webhooks/' + id, { enabled: enabled }, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; loadTab('webhooks'); return; }
+    msg.className = 'admin-msg admin-msg-ok'; msg.textContent = enabled ? 'Webhook enabled' : 'Webhook disabled';
+    setTimeout(function() { msg.className = ''; msg.textContent = ''; }, 1500);
+  });
+}
+
+function rotateWebhookSecret(id) {
+  if (!confirm('Rotate the signing secret? The receiver must be updated with the new one.')) return;
+  var msg = document.getElementById('webhooks-msg');
+  api('PUT', '/webhooks/' + id, { rotate_secret: true }, function(err, data) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    showWebhookSecret(data.secret);
+  });
+}
+
+function testWebhook(id) {
+  var msg = document.getElementById('webhooks-msg');
+  api('POST', '/webhooks/' + id + '/test', null, function(err) {
+    if (err) { msg.className = 'admin-msg admin-msg-err'; msg.textContent = err; return; }
+    msg.className = 'admin-msg admin-msg-ok'; msg.textContent = 'Test event queued';
+    setTimeout(function() { showDeliveries(id); }, 1500);
+  });
+}
+
+function deleteWebhook(id) {
+  if (!confirm('Delete this webhook and its delivery log?')) return;
+  api('DELETE', '/webhooks/' + id, null, function(err) {
+    if (err) { alert(err); return; }
+    loadTab('webhooks');
+  });
+}
+
+function showDeliveries(id) {
+  var el = document.getElementById('webhook-deliveries');
+  api('GET', '/webhooks/' + id + '/deliveries', null, function(err, dels) {
+    if (err) { el.innerHTML = '<div class="admin-msg admin-msg-err">' + esc(err) + '</div>'; return; }
+    var colors = { delivered: '#22c55e', pending: '#eab308', failed: '#ef4444' };
+    var html = '<h3 style="font-size:0.875rem;color:#94a3b8;margin:1rem 0 0.5rem">Deliveries</h3>';
+    if (!dels.length) { el.innerHTML = html + '<div style="color:#64748b;font-size:0.8125rem">No deliveries yet.</div>'; return; }
+    html += '<table class="admin-tbl"><thead><tr><th>Event</th><th>Status</th><th>Attempts</th><th>Last</th><th>Created</th><th></th></tr></thead><tbody>';
+    for (var i = 0; i < dels.length; i++) {
+      var d = dels[i];
+      var last = d.last_status ? String(d.last_status) : '';
+      if (d.last_error) last += (last ? ' ' : '') + d.last_error;
+      html += '<tr><td>' + esc(d.event_type) + '</td>' +
+        '<td style="color:' + (colors[d.status] || '#e2e8f0') + '">' + esc(d.status) + '</td>' +
+        '<td>' + d.attempts + '</td>' +
+        '<td style="font-size:0.75rem;color:#94a3b8">' + esc(last) + '</td>' +
+        '<td style="font-size:0.75rem;color:#64748b">' + esc(new Date(d.created_at).toLocaleString()) + '</td>' +
+        '<td>' + (d.status !== 'pending' ? '<button class="admin-btn" onclick="retryDelivery(' + id + ',' + d.id + ')">Retry</button>' : '') + '</td></tr>';
+    }
+    el.innerHTML = html + '</tbody></table>';
+  });
+}
+
+function retryDelivery(id, deliveryId) {
+  api('POST', '/webhooks/' + id + '/deliveries/' + deliveryId + '/retry', null, function(err) {
+    if (err) { alert(err); return; }
+    setTimeout(function() { showDeliveries(id); }, 1500);
+  });
+}
+
 ` + autoLoad + `
 </script>`
 }
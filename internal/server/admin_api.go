@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net/http"
 	"regexp"
@@ -11,7 +13,8 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/primal-host/noknok/internal/database"
-	"github.com/primal-host/noknok/internal/session"
+	"github.com/primal-host/noknok/internal/notify"
+	"github.com/primal-host/noknok/internal/webhook"
 )
 
 var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,39}$`)
@@ -21,11 +24,11 @@ const ctxKeyUser = "admin_user"
 // requireAdmin validates the session and ensures the user is owner or admin.
 func (s *Server) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cookie, err := c.Cookie(session.CookieName())
-		if err != nil || cookie.Value == "" {
+		token := s.sessionToken(c)
+		if token == "" {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
 		}
-		sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+		sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 		if err != nil {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
 		}
@@ -41,6 +44,17 @@ func (s *Server) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// requireOwner restricts a route in the admin group to owners. It must run
+// after requireAdmin.
+func requireOwner(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if adminUser(c).Role != "owner" {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "owner access required"})
+		}
+		return next(c)
+	}
+}
+
 func adminUser(c echo.Context) *database.User {
 	return c.Get(ctxKeyUser).(*database.User)
 }
@@ -69,161 +83,271 @@ func (s *Server) handleCreateUser(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
 	}
-	if req.Handle == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "handle is required"})
+
+	user, err := s.createUser(c.Request().Context(), caller, req.Handle, "", req.Role, req.Username)
+	if err != nil {
+		return userErrorJSON(c, err)
+	}
+	return c.JSON(http.StatusCreated, user)
+}
+
+func (s *Server) handleUpdateUserRole(c echo.Context) error {
+	caller := adminUser(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	if err := s.setUserRole(c.Request().Context(), caller, id, req.Role); err != nil {
+		return userErrorJSON(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleUpdateUserUsername(c echo.Context) error {
+	caller := adminUser(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	if err := s.setUsername(c.Request().Context(), caller, id, req.Username); err != nil {
+		return userErrorJSON(c, err)
 	}
-	if req.Role == "" {
-		req.Role = "user"
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleDeleteUser(c echo.Context) error {
+	caller := adminUser(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+
+	if err := s.deleteUser(c.Request().Context(), caller, id); err != nil {
+		return userErrorJSON(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// userError is a rejected user change and the HTTP status to report it
+// with. The user helpers below are shared by the admin API and SCIM, so both
+// enforce the same role rules.
+type userError struct {
+	status int
+	msg    string
+}
+
+func (e *userError) Error() string { return e.msg }
+
+func userErrorJSON(c echo.Context, err error) error {
+	var ue *userError
+	if errors.As(err, &ue) {
+		return c.JSON(ue.status, map[string]string{"error": ue.msg})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+}
+
+// createUser resolves handle and creates a user with it as the primary
+// identity. If wantDID is set the handle must resolve to it.
+func (s *Server) createUser(ctx context.Context, caller *database.User, handle, wantDID, role, username string) (*database.User, error) {
+	if handle == "" {
+		return nil, &userError{http.StatusBadRequest, "handle is required"}
+	}
+	if role == "" {
+		role = "user"
 	}
 
 	// Admins can only create users, not other admins/owners.
-	if caller.Role != "owner" && req.Role != "user" {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "only owners can assign admin/owner roles"})
+	if caller.Role != "owner" && role != "user" {
+		return nil, &userError{http.StatusForbidden, "only owners can assign admin/owner roles"}
 	}
-	if req.Role != "user" && req.Role != "admin" && req.Role != "owner" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid role"})
+	if role != "user" && role != "admin" && role != "owner" {
+		return nil, &userError{http.StatusBadRequest, "invalid role"}
 	}
 
 	// Resolve handle to DID.
-	did, resolvedHandle, err := s.oauth.ResolveHandle(c.Request().Context(), req.Handle)
+	did, resolvedHandle, err := s.oauth.ResolveHandle(ctx, handle)
 	if err != nil {
-		slog.Warn("handle resolution failed", "handle", req.Handle, "error", err)
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "could not resolve handle"})
+		slog.Warn("handle resolution failed", "handle", handle, "error", err)
+		return nil, &userError{http.StatusBadRequest, "could not resolve handle"}
+	}
+	if wantDID != "" && did != wantDID {
+		return nil, &userError{http.StatusBadRequest, "handle resolves to " + did + ", not " + wantDID}
 	}
 
-	if req.Username != "" && !validUsername.MatchString(req.Username) {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid username (alphanumeric, hyphens, underscores, 1-39 chars)"})
+	if username != "" && !validUsername.MatchString(username) {
+		return nil, &userError{http.StatusBadRequest, "invalid username (alphanumeric, hyphens, underscores, 1-39 chars)"}
 	}
 
 	// Check if DID already has an identity.
-	if exists, _ := s.db.UserExists(c.Request().Context(), did); exists {
-		return c.JSON(http.StatusConflict, map[string]string{"error": "identity already exists"})
+	if exists, _ := s.db.UserExists(ctx, did); exists {
+		return nil, &userError{http.StatusConflict, "identity already exists"}
 	}
 
-	user, err := s.db.CreateUser(c.Request().Context(), req.Role, req.Username)
+	user, err := s.db.CreateUser(ctx, role, username)
 	if err != nil {
 		slog.Warn("create user failed", "error", err)
-		return c.JSON(http.StatusConflict, map[string]string{"error": "user already exists"})
+		return nil, &userError{http.StatusConflict, "user already exists"}
 	}
 
-	if _, err := s.db.AddIdentity(c.Request().Context(), user.ID, did, resolvedHandle, true); err != nil {
+	if _, err := s.db.AddIdentity(ctx, user.ID, did, resolvedHandle, true); err != nil {
 		slog.Warn("add identity failed", "did", did, "error", err)
 		// Clean up the user we just created.
-		_ = s.db.DeleteUser(c.Request().Context(), user.ID)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to add identity"})
+		_ = s.db.DeleteUser(ctx, user.ID)
+		return nil, &userError{http.StatusInternalServerError, "failed to add identity"}
 	}
 	user.DID = did
 	user.Handle = resolvedHandle
 
-	slog.Info("user created", "did", did, "handle", resolvedHandle, "role", req.Role, "by", caller.Handle)
-	return c.JSON(http.StatusCreated, user)
+	slog.Info("user created", "did", did, "handle", resolvedHandle, "role", role, "by", caller.Handle)
+	s.webhooks.Emit(ctx, webhook.EventUserCreated, map[string]any{
+		"user_id": user.ID, "did": did, "handle": resolvedHandle, "role": role, "username": username, "by": caller.Handle,
+	})
+	return user, nil
 }
 
-func (s *Server) handleUpdateUserRole(c echo.Context) error {
-	caller := adminUser(c)
-
-	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+// setUserRole changes a user's role.
+func (s *Server) setUserRole(ctx context.Context, caller *database.User, id int64, role string) error {
+	target, err := s.checkUserRole(ctx, caller, id, role)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
-	}
-
-	var req struct {
-		Role string `json:"role"`
+		return err
 	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	if err := s.db.UpdateUserRole(ctx, id, role); err != nil {
+		return &userError{http.StatusInternalServerError, "failed to update role"}
 	}
-	if req.Role != "user" && req.Role != "admin" && req.Role != "owner" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid role"})
+	s.userRoleChanged(ctx, caller, id, target, role)
+	return nil
+}
+
+// checkUserRole reports whether caller may give user id the role, and
+// returns the user as it is before the change.
+func (s *Server) checkUserRole(ctx context.Context, caller *database.User, id int64, role string) (database.User, error) {
+	var target database.User
+	if role != "user" && role != "admin" && role != "owner" {
+		return target, &userError{http.StatusBadRequest, "invalid role"}
 	}
 
 	// Admins can only set role to "user".
-	if caller.Role != "owner" && req.Role != "user" {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "only owners can assign admin/owner roles"})
+	if caller.Role != "owner" && role != "user" {
+		return target, &userError{http.StatusForbidden, "only owners can assign admin/owner roles"}
 	}
 
 	// Prevent changing the seed owner's role.
-	users, err := s.db.ListUsers(c.Request().Context())
+	users, err := s.db.ListUsers(ctx)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return target, &userError{http.StatusInternalServerError, "internal error"}
 	}
 	for _, u := range users {
 		if u.ID == id && u.DID == s.cfg.OwnerDID {
-			return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot change seed owner role"})
+			return target, &userError{http.StatusForbidden, "cannot change seed owner role"}
+		}
+		if u.ID == id {
+			target = u
 		}
 	}
-
-	if err := s.db.UpdateUserRole(c.Request().Context(), id, req.Role); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
-	}
-
-	slog.Info("user role updated", "user_id", id, "role", req.Role, "by", caller.Handle)
-	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	return target, nil
 }
 
-func (s *Server) handleUpdateUserUsername(c echo.Context) error {
-	caller := adminUser(c)
+// userRoleChanged announces a saved role change.
+func (s *Server) userRoleChanged(ctx context.Context, caller *database.User, id int64, target database.User, role string) {
+	slog.Info("user role updated", "user_id", id, "role", role, "by", caller.Handle)
+	if target.ID == 0 || target.Role == role {
+		return
+	}
+	s.webhooks.Emit(ctx, webhook.EventUserRoleChanged, map[string]any{
+		"user_id": id, "did": target.DID, "handle": target.Handle, "from": target.Role, "to": role, "by": caller.Handle,
+	})
+	ev := notify.Event{
+		Kind:   notify.KindRoleChanged,
+		Title:  "Role changed to " + role,
+		Body:   "@" + caller.Handle + " changed the role of @" + target.Handle + " from " + target.Role + " to " + role + ".",
+		Detail: map[string]any{"user_id": id, "handle": target.Handle, "from": target.Role, "to": role, "by": caller.Handle},
+	}
+	s.notifier.Notify(ctx, ev, id)
+	s.notifier.NotifyOwners(ctx, ev, caller.ID, id)
+}
 
-	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+// setUsername changes a user's username; empty clears it.
+func (s *Server) setUsername(ctx context.Context, caller *database.User, id int64, username string) error {
+	if err := checkUsername(username); err != nil {
+		return err
 	}
-
-	var req struct {
-		Username string `json:"username"`
-	}
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
-	}
-	if req.Username != "" && !validUsername.MatchString(req.Username) {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid username (alphanumeric, hyphens, underscores, 1-39 chars)"})
+	if err := s.db.UpdateUserUsername(ctx, id, username); err != nil {
+		return &userError{http.StatusInternalServerError, "failed to update username"}
 	}
+	s.usernameChanged(ctx, caller, id, username)
+	return nil
+}
 
-	if err := s.db.UpdateUserUsername(c.Request().Context(), id, req.Username); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update username"})
+func checkUsername(username string) error {
+	if username != "" && !validUsername.MatchString(username) {
+		return &userError{http.StatusBadRequest, "invalid username (alphanumeric, hyphens, underscores, 1-39 chars)"}
 	}
-
-	slog.Info("user username updated", "user_id", id, "username", req.Username, "by", caller.Handle)
-	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	return nil
 }
 
-func (s *Server) handleDeleteUser(c echo.Context) error {
-	caller := adminUser(c)
-
-	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
-	}
+// usernameChanged announces a saved username change.
+func (s *Server) usernameChanged(ctx context.Context, caller *database.User, id int64, username string) {
+	slog.Info("user username updated", "user_id", id, "username", username, "by", caller.Handle)
+	s.webhooks.Emit(ctx, webhook.EventUserUpdated, map[string]any{
+		"user_id": id, "username": username, "by": caller.Handle,
+	})
+}
 
+// deleteUser removes a user and all their identities and grants.
+func (s *Server) deleteUser(ctx context.Context, caller *database.User, id int64) error {
 	// No self-deletion.
 	if id == caller.ID {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot delete yourself"})
+		return &userError{http.StatusForbidden, "cannot delete yourself"}
 	}
 
 	// Protect seed owner.
-	users, err := s.db.ListUsers(c.Request().Context())
+	users, err := s.db.ListUsers(ctx)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return &userError{http.StatusInternalServerError, "internal error"}
 	}
+	var target database.User
 	for _, u := range users {
 		if u.ID == id {
+			target = u
 			if u.DID == s.cfg.OwnerDID {
-				return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot delete seed owner"})
+				return &userError{http.StatusForbidden, "cannot delete seed owner"}
 			}
 			// Admins can only delete users, not other admins/owners.
 			if caller.Role != "owner" && u.Role != "user" {
-				return c.JSON(http.StatusForbidden, map[string]string{"error": "only owners can delete admins/owners"})
+				return &userError{http.StatusForbidden, "only owners can delete admins/owners"}
 			}
 			break
 		}
 	}
 
-	if err := s.db.DeleteUser(c.Request().Context(), id); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+	if err := s.db.DeleteUser(ctx, id); err != nil {
+		return &userError{http.StatusInternalServerError, "failed to delete user"}
 	}
 
 	slog.Info("user deleted", "user_id", id, "by", caller.Handle)
-	return c.NoContent(http.StatusNoContent)
+	s.webhooks.Emit(ctx, webhook.EventUserDeleted, map[string]any{
+		"user_id": id, "did": target.DID, "handle": target.Handle, "role": target.Role, "by": caller.Handle,
+	})
+	return nil
 }
 
 // --- Services ---
@@ -249,6 +373,7 @@ func (s *Server) handleCreateService(c echo.Context) error {
 		URL         string `json:"url"`
 		IconURL     string `json:"icon_url"`
 		AdminRole   string `json:"admin_role"`
+		UpstreamURL string `json:"upstream_url"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
@@ -256,13 +381,17 @@ func (s *Server) handleCreateService(c echo.Context) error {
 	if req.Slug == "" || req.Name == "" || req.URL == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "slug, name, and url are required"})
 	}
+	if !validUpstreamURL(req.UpstreamURL) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "upstream_url must be an http(s) URL"})
+	}
 
-	svc, err := s.db.CreateService(c.Request().Context(), req.Slug, req.Name, req.Description, req.URL, req.IconURL, req.AdminRole)
+	svc, err := s.db.CreateService(c.Request().Context(), req.Slug, req.Name, req.Description, req.URL, req.IconURL, req.AdminRole, req.UpstreamURL)
 	if err != nil {
 		return c.JSON(http.StatusConflict, map[string]string{"error": "service slug already exists"})
 	}
 
 	slog.Info("service created", "slug", req.Slug, "by", caller.Handle)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventServiceCreated, map[string]any{"service": svc, "by": caller.Handle})
 	return c.JSON(http.StatusCreated, svc)
 }
 
@@ -280,6 +409,7 @@ func (s *Server) handleUpdateService(c echo.Context) error {
 		URL         string `json:"url"`
 		IconURL     string `json:"icon_url"`
 		AdminRole   string `json:"admin_role"`
+		UpstreamURL string `json:"upstream_url"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
@@ -287,12 +417,18 @@ func (s *Server) handleUpdateService(c echo.Context) error {
 	if req.Name == "" || req.URL == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "name and url are required"})
 	}
+	if !validUpstreamURL(req.UpstreamURL) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "upstream_url must be an http(s) URL"})
+	}
 
-	if err := s.db.UpdateService(c.Request().Context(), id, req.Name, req.Description, req.URL, req.IconURL, req.AdminRole); err != nil {
+	if err := s.db.UpdateService(c.Request().Context(), id, req.Name, req.Description, req.URL, req.IconURL, req.AdminRole, req.UpstreamURL); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update service"})
 	}
 
 	slog.Info("service updated", "service_id", id, "by", caller.Handle)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventServiceUpdated, map[string]any{
+		"service_id": id, "name": req.Name, "url": req.URL, "admin_role": req.AdminRole, "upstream_url": req.UpstreamURL, "by": caller.Handle,
+	})
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
@@ -309,6 +445,7 @@ func (s *Server) handleDeleteService(c echo.Context) error {
 	}
 
 	slog.Info("service deleted", "service_id", id, "by", caller.Handle)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventServiceDeleted, map[string]any{"service_id": id, "by": caller.Handle})
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -323,6 +460,9 @@ func (s *Server) handleToggleServiceEnabled(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to toggle"})
 	}
 	slog.Info("service enabled toggled", "service_id", id, "enabled", enabled, "by", caller.Handle)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventServiceToggled, map[string]any{
+		"service_id": id, "field": "enabled", "value": enabled, "by": caller.Handle,
+	})
 	return c.JSON(http.StatusOK, map[string]bool{"enabled": enabled})
 }
 
@@ -337,6 +477,9 @@ func (s *Server) handleToggleServicePublic(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to toggle"})
 	}
 	slog.Info("service public toggled", "service_id", id, "public", public, "by", caller.Handle)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventServiceToggled, map[string]any{
+		"service_id": id, "field": "public", "value": public, "by": caller.Handle,
+	})
 	return c.JSON(http.StatusOK, map[string]bool{"public": public})
 }
 
@@ -426,12 +569,10 @@ func (s *Server) handleCreateGrant(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "user_id and service_id are required"})
 	}
 
-	grant, err := s.db.CreateGrant(c.Request().Context(), req.UserID, req.ServiceID, caller.ID, req.Role)
+	grant, err := s.addGrant(c.Request().Context(), caller, req.UserID, req.ServiceID, req.Role)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create grant"})
 	}
-
-	slog.Info("grant created", "user_id", req.UserID, "service_id", req.ServiceID, "by", caller.Handle)
 	return c.JSON(http.StatusCreated, grant)
 }
 
@@ -443,14 +584,41 @@ func (s *Server) handleDeleteGrant(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid grant ID"})
 	}
 
-	if err := s.db.DeleteGrant(c.Request().Context(), id); err != nil {
+	grant, err := s.db.DeleteGrant(c.Request().Context(), id)
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete grant"})
 	}
 
-	slog.Info("grant deleted", "grant_id", id, "by", caller.Handle)
+	if grant != nil {
+		s.grantRemoved(c.Request().Context(), caller, grant)
+	}
 	return c.NoContent(http.StatusNoContent)
 }
 
+// addGrant creates or updates a grant and announces it.
+func (s *Server) addGrant(ctx context.Context, caller *database.User, userID, serviceID int64, role string) (*database.Grant, error) {
+	grant, err := s.db.CreateGrant(ctx, userID, serviceID, caller.ID, role)
+	if err != nil {
+		return nil, err
+	}
+	s.grantAdded(ctx, caller, grant)
+	return grant, nil
+}
+
+// grantAdded announces a created or updated grant.
+func (s *Server) grantAdded(ctx context.Context, caller *database.User, grant *database.Grant) {
+	slog.Info("grant created", "user_id", grant.UserID, "service_id", grant.ServiceID, "by", caller.Handle)
+	s.notifyGrant(ctx, grant, true, caller)
+	s.webhooks.Emit(ctx, webhook.EventGrantCreated, map[string]any{"grant": grant, "by": caller.Handle})
+}
+
+// grantRemoved announces a deleted grant.
+func (s *Server) grantRemoved(ctx context.Context, caller *database.User, grant *database.Grant) {
+	slog.Info("grant deleted", "grant_id", grant.ID, "by", caller.Handle)
+	s.notifyGrant(ctx, grant, false, caller)
+	s.webhooks.Emit(ctx, webhook.EventGrantDeleted, map[string]any{"grant": grant, "by": caller.Handle})
+}
+
 // --- Identities ---
 
 func (s *Server) handleListUserIdentities(c echo.Context) error {
@@ -503,6 +671,17 @@ func (s *Server) handleAddIdentity(c echo.Context) error {
 	}
 
 	slog.Info("identity added", "user_id", userID, "did", did, "handle", resolvedHandle, "by", caller.Handle)
+	ev := notify.Event{
+		Kind:   notify.KindIdentityAdded,
+		Title:  "Identity @" + resolvedHandle + " linked",
+		Body:   "@" + caller.Handle + " linked the identity @" + resolvedHandle + " (" + did + ") to this account. It can now sign in as you.",
+		Detail: map[string]any{"user_id": userID, "did": did, "handle": resolvedHandle, "by": caller.Handle},
+	}
+	s.notifier.Notify(c.Request().Context(), ev, userID)
+	s.notifier.NotifyOwners(c.Request().Context(), ev, caller.ID, userID)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventIdentityAdded, map[string]any{
+		"user_id": userID, "identity_id": identity.ID, "did": did, "handle": resolvedHandle, "by": caller.Handle,
+	})
 	return c.JSON(http.StatusCreated, identity)
 }
 
@@ -525,17 +704,17 @@ func (s *Server) handleRemoveIdentity(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
 	}
 
-	var found bool
+	var found *database.Identity
 	for _, id := range ids {
 		if id.ID == identityID {
-			found = true
+			found = &id
 			if id.IsPrimary {
 				return c.JSON(http.StatusForbidden, map[string]string{"error": "cannot remove primary identity"})
 			}
 			break
 		}
 	}
-	if !found {
+	if found == nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "identity not found for this user"})
 	}
 
@@ -548,5 +727,45 @@ func (s *Server) handleRemoveIdentity(c echo.Context) error {
 	}
 
 	slog.Info("identity removed", "user_id", userID, "identity_id", identityID, "by", caller.Handle)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventIdentityRemoved, map[string]any{
+		"user_id": userID, "identity_id": identityID, "did": found.DID, "handle": found.Handle, "by": caller.Handle,
+	})
 	return c.NoContent(http.StatusNoContent)
 }
+
+// --- OAuth sessions ---
+
+func (s *Server) handleListUserOAuthSessions(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+
+	sessions, err := s.db.ListOAuthSessions(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list oauth sessions"})
+	}
+	if sessions == nil {
+		sessions = []database.OAuthSession{}
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// --- Audit log ---
+
+// handleListAuditLog returns recent audit entries. Query parameters: did,
+// action (prefix) and limit (default 100, max 1000).
+func (s *Server) handleListAuditLog(c echo.Context) error {
+	limit := 100
+	if v, err := strconv.Atoi(c.QueryParam("limit")); err == nil && v > 0 {
+		limit = min(v, 1000)
+	}
+	entries, err := s.db.ListAuditLog(c.Request().Context(), c.QueryParam("did"), c.QueryParam("action"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list audit log"})
+	}
+	if entries == nil {
+		entries = []database.AuditEntry{}
+	}
+	return c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// Routers and middlewares as returned by the Traefik API
+// (/api/http/routers, /api/http/middlewares).
+type apiRouter struct {
+	Name        string   `json:"name"`
+	Provider    string   `json:"provider"`
+	Rule        string   `json:"rule"`
+	Middlewares []string `json:"middlewares"`
+}
+
+type apiMiddleware struct {
+	Name        string `json:"name"`
+	Provider    string `json:"provider"`
+	Type        string `json:"type"`
+	ForwardAuth *struct {
+		Address string `json:"address"`
+	} `json:"forwardAuth"`
+	Chain *struct {
+		Middlewares []string `json:"middlewares"`
+	} `json:"chain"`
+}
+
+// auditRouter is one router in the audit report.
+type auditRouter struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`      // the literal host, the HostRegexp pattern, or "*"
+	Match     string `json:"match"`     // host, regexp or any
+	Protected bool   `json:"protected"` // has noknok forwardAuth (directly or via a chain)
+	Redirect  bool   `json:"redirect"`  // only redirects, so cannot bypass auth
+}
+
+// auditService is a catalogued service and the routers serving its host.
+type auditService struct {
+	ID      int64         `json:"id"`
+	Slug    string        `json:"slug"`
+	Name    string        `json:"name"`
+	Host    string        `json:"host"`
+	Public  bool          `json:"public"`
+	Enabled bool          `json:"enabled"`
+	Status  string        `json:"status"` // protected, unprotected, public, no_router
+	Routers []auditRouter `json:"routers"`
+}
+
+// auditReport correlates Traefik routers with the services catalog.
+type auditReport struct {
+	Services     []auditService `json:"services"`
+	Uncatalogued []auditRouter  `json:"uncatalogued"` // routers for hosts under our cookie domains
+	Wildcard     []auditRouter  `json:"wildcard"`     // HostRegexp and catch-all routers, which can serve any catalogued host
+	CheckedAt    time.Time      `json:"checked_at"`
+}
+
+// Router match kinds.
+const (
+	matchHost   = "host"
+	matchRegexp = "regexp"
+	matchAny    = "any"
+)
+
+var ruleMatcher = regexp.MustCompile("\\b(Host|HostRegexp)\\(((?:\\s*(?:`[^`]*`|\"[^\"]*\")\\s*,?)+)\\s*\\)")
+var ruleArg = regexp.MustCompile("`([^`]*)`|\"([^\"]*)\"")
+
+// routerHosts returns the literal Host and the HostRegexp patterns in a
+// router rule. A rule with neither serves every host. HostSNI is ignored.
+func routerHosts(rule string) (hosts, patterns []string) {
+	for _, m := range ruleMatcher.FindAllStringSubmatch(rule, -1) {
+		for _, a := range ruleArg.FindAllStringSubmatch(m[2], -1) {
+			arg := a[1] + a[2]
+			if m[1] == "Host" {
+				hosts = append(hosts, strings.ToLower(arg))
+			} else {
+				patterns = append(patterns, arg)
+			}
+		}
+	}
+	return hosts, patterns
+}
+
+var templateVar = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?\}`)
+
+// hostPattern compiles a HostRegexp argument: a Traefik v2 template such as
+// "{sub:[a-z]+}.example.com", or a v3 regular expression. A pattern that does
+// not compile matches every host, so the audit errs towards reporting it.
+func hostPattern(p string) *regexp.Regexp {
+	if templateVar.MatchString(p) {
+		var b strings.Builder
+		last := 0
+		for _, loc := range templateVar.FindAllStringSubmatchIndex(p, -1) {
+			b.WriteString(regexp.QuoteMeta(p[last:loc[0]]))
+			if loc[4] >= 0 {
+				b.WriteString("(?:" + p[loc[4]:loc[5]] + ")")
+			} else {
+				b.WriteString(`[^.]+`)
+			}
+			last = loc[1]
+		}
+		b.WriteString(regexp.QuoteMeta(p[last:]))
+		p = "^" + b.String() + "$"
+	}
+	re, err := regexp.Compile("(?i)" + p)
+	if err != nil {
+		return regexp.MustCompile("")
+	}
+	return re
+}
+
+// qualify adds the router's provider to a middleware reference without one.
+func qualify(name, provider string) string {
+	if strings.Contains(name, "@") {
+		return name
+	}
+	return name + "@" + provider
+}
+
+// isNoknokAuth reports whether a forwardAuth address points at noknok's
+// auth endpoints (/auth, /auth/nginx, ...) on TRAEFIK_AUTH_ADDRESS or
+// PUBLIC_URL. Any other host may be a different auth service, or none.
+func (s *Server) isNoknokAuth(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil || (u.Path != "/auth" && !strings.HasPrefix(u.Path, "/auth/")) {
+		return false
+	}
+	for _, known := range []string{s.cfg.TraefikAuthAddress, s.cfg.PublicURL} {
+		if k, err := url.Parse(known); err == nil && k.Host != "" && authEndpoint(k) == authEndpoint(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// authEndpoint returns the normalized host and port of u.
+func authEndpoint(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return database.NormalizeHost(u.Host) + ":" + port
+}
+
+// fetchTraefik GETs a Traefik API list endpoint into out.
+func (s *Server) fetchTraefik(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(s.cfg.TraefikAPIURL, "/")+path+"?per_page=10000", nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("traefik %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// buildAudit reads Traefik's routers and middlewares and correlates them
+// with the services catalog.
+func (s *Server) buildAudit(ctx context.Context) (*auditReport, error) {
+	var routers []apiRouter
+	if err := s.fetchTraefik(ctx, "/api/http/routers", &routers); err != nil {
+		return nil, err
+	}
+	var mws []apiMiddleware
+	if err := s.fetchTraefik(ctx, "/api/http/middlewares", &mws); err != nil {
+		return nil, err
+	}
+	svcs, err := s.db.ListServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.auditRouters(routers, mws, svcs), nil
+}
+
+// auditRouters flags catalogued services reachable through a router without
+// noknok's forwardAuth, routers for hosts under our cookie domains that are
+// not catalogued, and routers that match hosts by pattern or not at all.
+func (s *Server) auditRouters(routers []apiRouter, mws []apiMiddleware, svcs []database.Service) *auditReport {
+	byName := make(map[string]apiMiddleware, len(mws))
+	for _, mw := range mws {
+		byName[mw.Name] = mw
+	}
+	// protects resolves chains; seen guards against cycles.
+	var protects func(name string, seen map[string]bool) bool
+	protects = func(name string, seen map[string]bool) bool {
+		if seen[name] {
+			return false
+		}
+		seen[name] = true
+		mw, ok := byName[name]
+		if !ok {
+			return false
+		}
+		if mw.ForwardAuth != nil && s.isNoknokAuth(mw.ForwardAuth.Address) {
+			return true
+		}
+		if mw.Chain != nil {
+			for _, child := range mw.Chain.Middlewares {
+				if protects(qualify(child, mw.Provider), seen) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	isRedirect := func(name string) bool {
+		t := byName[name].Type
+		return t == "redirectscheme" || t == "redirectregex"
+	}
+
+	// wildcard routers carry their compiled pattern; nil matches any host.
+	type wildcard struct {
+		router  auditRouter
+		pattern *regexp.Regexp
+	}
+	hostRouters := make(map[string][]auditRouter)
+	var wildcards []wildcard
+	for _, r := range routers {
+		ar := auditRouter{Name: r.Name}
+		for _, m := range r.Middlewares {
+			q := qualify(m, r.Provider)
+			if protects(q, map[string]bool{}) {
+				ar.Protected = true
+			}
+			if isRedirect(q) {
+				ar.Redirect = true
+			}
+		}
+		hosts, patterns := routerHosts(r.Rule)
+		for _, h := range hosts {
+			ar.Host, ar.Match = h, matchHost
+			hostRouters[h] = append(hostRouters[h], ar)
+		}
+		for _, p := range patterns {
+			ar.Host, ar.Match = p, matchRegexp
+			wildcards = append(wildcards, wildcard{ar, hostPattern(p)})
+		}
+		// Traefik's own routers (dashboard, ping) live on its internal
+		// entrypoint and match no host by design.
+		if len(hosts) == 0 && len(patterns) == 0 && r.Provider != "internal" {
+			ar.Host, ar.Match = "*", matchAny
+			wildcards = append(wildcards, wildcard{ar, nil})
+		}
+	}
+
+	report := &auditReport{Wildcard: []auditRouter{}, CheckedAt: time.Now()}
+	for _, w := range wildcards {
+		report.Wildcard = append(report.Wildcard, w.router)
+	}
+	catalogued := make(map[string]bool)
+	for _, svc := range svcs {
+		host := strings.ToLower(serviceHost(svc))
+		catalogued[host] = true
+		as := auditService{
+			ID: svc.ID, Slug: svc.Slug, Name: svc.Name, Host: host,
+			Public: svc.Public, Enabled: svc.Enabled,
+			Routers: append([]auditRouter{}, hostRouters[host]...),
+		}
+		for _, w := range wildcards {
+			if w.pattern == nil || w.pattern.MatchString(host) {
+				as.Routers = append(as.Routers, w.router)
+			}
+		}
+		switch {
+		case svc.Public:
+			as.Status = "public"
+		case len(as.Routers) == 0:
+			as.Status = "no_router"
+		default:
+			as.Status = "protected"
+			for _, r := range as.Routers {
+				if !r.Protected && !r.Redirect {
+					as.Status = "unprotected"
+				}
+			}
+		}
+		report.Services = append(report.Services, as)
+	}
+
+	publicHost := ""
+	if u, err := url.Parse(s.cfg.PublicURL); err == nil {
+		publicHost = strings.ToLower(u.Hostname())
+	}
+	report.Uncatalogued = []auditRouter{}
+	for host, rs := range hostRouters {
+		if catalogued[host] || host == publicHost || !s.inCookieDomain(host) {
+			continue
+		}
+		report.Uncatalogued = append(report.Uncatalogued, rs...)
+	}
+	sort.Slice(report.Uncatalogued, func(i, j int) bool {
+		a, b := report.Uncatalogued[i], report.Uncatalogued[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Name < b.Name
+	})
+	return report
+}
+
+// inCookieDomain reports whether host is covered by one of COOKIE_DOMAINS.
+func (s *Server) inCookieDomain(host string) bool {
+	for _, d := range s.cfg.CookieDomains {
+		if host == strings.TrimPrefix(d, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(d, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleTraefikAudit(c echo.Context) error {
+	if s.cfg.TraefikAPIURL == "" {
+		return c.JSON(http.StatusNotImplemented, map[string]string{"error": "TRAEFIK_API_URL is not configured"})
+	}
+	report, err := s.buildAudit(c.Request().Context())
+	if err != nil {
+		slog.Error("traefik audit failed", "error", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "traefik audit failed: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, report)
+}
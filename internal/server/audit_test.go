@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/primal-host/noknok/internal/config"
+	"github.com/primal-host/noknok/internal/database"
+)
+
+func TestRouterHosts(t *testing.T) {
+	cases := []struct {
+		rule            string
+		hosts, patterns []string
+	}{
+		{"Host(`App.example.com`)", []string{"app.example.com"}, nil},
+		{"Host(`a.example.com`, `b.example.com`) && PathPrefix(`/x`)", []string{"a.example.com", "b.example.com"}, nil},
+		{"Host(\"a.example.com\") || Host(`b.example.com`)", []string{"a.example.com", "b.example.com"}, nil},
+		{"HostRegexp(`^(a|b)\\.example\\.com$`)", nil, []string{`^(a|b)\.example\.com$`}},
+		{"HostRegexp(`{sub:[a-z]+}.example.com`) && Host(`c.example.com`)", []string{"c.example.com"}, []string{"{sub:[a-z]+}.example.com"}},
+		{"HostSNI(`*`)", nil, nil},
+		{"PathPrefix(`/`)", nil, nil},
+	}
+	for _, tc := range cases {
+		hosts, patterns := routerHosts(tc.rule)
+		if !reflect.DeepEqual(hosts, tc.hosts) || !reflect.DeepEqual(patterns, tc.patterns) {
+			t.Errorf("routerHosts(%q) = %q, %q; want %q, %q", tc.rule, hosts, patterns, tc.hosts, tc.patterns)
+		}
+	}
+}
+
+func TestHostPattern(t *testing.T) {
+	cases := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"{sub:[a-z]+}.example.com", "wiki.example.com", true},
+		{"{sub:[a-z]+}.example.com", "wiki.example.com.evil", false},
+		{"{sub:[a-z]+}.example.com", "wikiXexample.com", false},
+		{"{sub}.example.com", "git.example.com", true},
+		{"{sub}.example.com", "a.b.example.com", false},
+		{`^[a-z]{3}\.example\.com$`, "git.example.com", true},
+		{`^[a-z]{3}\.example\.com$`, "wiki.example.com", false},
+		{`\.example\.com$`, "Wiki.Example.com", true},
+		{`[`, "anything.test", true}, // does not compile: reported as matching
+	}
+	for _, tc := range cases {
+		if got := hostPattern(tc.pattern).MatchString(tc.host); got != tc.want {
+			t.Errorf("hostPattern(%q) on %q = %v, want %v", tc.pattern, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestIsNoknokAuth(t *testing.T) {
+	s := &Server{cfg: &config.Config{
+		TraefikAuthAddress: "http://noknok:4321/auth",
+		PublicURL:          "https://auth.example.com",
+	}}
+	cases := []struct {
+		address string
+		want    bool
+	}{
+		{"http://noknok:4321/auth", true},
+		{"http://NOKNOK:4321/auth/nginx", true},
+		{"https://auth.example.com/auth", true},
+		{"https://auth.example.com:443/auth", true},
+		{"http://noknok:4321/login", false},
+		{"http://noknok:8080/auth", false},
+		{"http://authelia:9091/auth", false},
+		{"https://auth.example.com.evil/auth", false},
+	}
+	for _, tc := range cases {
+		if got := s.isNoknokAuth(tc.address); got != tc.want {
+			t.Errorf("isNoknokAuth(%q) = %v, want %v", tc.address, got, tc.want)
+		}
+	}
+}
+
+// traefikStandIn serves canned /api/http routers and middlewares.
+func traefikStandIn(t *testing.T, routers []apiRouter, mws []apiMiddleware) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/http/routers", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(routers)
+	})
+	mux.HandleFunc("/api/http/middlewares", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(mws)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func forwardAuth(name, provider, address string) apiMiddleware {
+	mw := apiMiddleware{Name: name, Provider: provider, Type: "forwardauth"}
+	mw.ForwardAuth = &struct {
+		Address string `json:"address"`
+	}{address}
+	return mw
+}
+
+func chain(name, provider string, children ...string) apiMiddleware {
+	mw := apiMiddleware{Name: name, Provider: provider, Type: "chain"}
+	mw.Chain = &struct {
+		Middlewares []string `json:"middlewares"`
+	}{children}
+	return mw
+}
+
+func TestAuditAgainstTraefikAPI(t *testing.T) {
+	routers := []apiRouter{
+		{Name: "app@docker", Provider: "docker", Rule: "Host(`app.example.com`)", Middlewares: []string{"noknok@file"}},
+		{Name: "git@docker", Provider: "docker", Rule: "Host(`git.example.com`)", Middlewares: []string{"secure"}},
+		{Name: "wiki@docker", Provider: "docker", Rule: "Host(`wiki.example.com`)", Middlewares: []string{"noknok@file"}},
+		{Name: "wild@docker", Provider: "docker", Rule: "HostRegexp(`{sub:w[a-z]+}.example.com`)"},
+		{Name: "fallback@file", Provider: "file", Rule: "PathPrefix(`/`)", Middlewares: []string{"noknok"}},
+		{Name: "api@internal", Provider: "internal", Rule: "PathPrefix(`/api`)"},
+		{Name: "new@docker", Provider: "docker", Rule: "Host(`new.example.com`)"},
+		{Name: "other@docker", Provider: "docker", Rule: "Host(`elsewhere.org`)"},
+	}
+	mws := []apiMiddleware{
+		forwardAuth("noknok@file", "file", "http://noknok:4321/auth"),
+		// Same path, different host: not noknok.
+		forwardAuth("impostor@docker", "docker", "http://other:9000/auth"),
+		chain("secure@docker", "docker", "impostor"),
+	}
+	srv := traefikStandIn(t, routers, mws)
+
+	s := &Server{cfg: &config.Config{
+		TraefikAPIURL:      srv.URL,
+		TraefikAuthAddress: "http://noknok:4321/auth",
+		PublicURL:          "https://auth.example.com",
+		CookieDomains:      []string{".example.com"},
+	}}
+	var gotRouters []apiRouter
+	if err := s.fetchTraefik(context.Background(), "/api/http/routers", &gotRouters); err != nil {
+		t.Fatal(err)
+	}
+	var gotMws []apiMiddleware
+	if err := s.fetchTraefik(context.Background(), "/api/http/middlewares", &gotMws); err != nil {
+		t.Fatal(err)
+	}
+	svcs := []database.Service{
+		{ID: 1, Slug: "app", URL: "https://app.example.com"},
+		{ID: 2, Slug: "git", URL: "https://git.example.com"},
+		{ID: 3, Slug: "wiki", URL: "https://wiki.example.com"},
+		{ID: 4, Slug: "blog", URL: "https://blog.example.com", Public: true},
+	}
+	report := s.auditRouters(gotRouters, gotMws, svcs)
+
+	status := map[string]string{}
+	for _, svc := range report.Services {
+		status[svc.Slug] = svc.Status
+	}
+	want := map[string]string{
+		"app":  "protected",   // its own router and the catch-all both use noknok
+		"git":  "unprotected", // forwardAuth to another host via a chain
+		"wiki": "unprotected", // reachable through the HostRegexp router
+		"blog": "public",
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("statuses = %v, want %v", status, want)
+	}
+
+	var wild []string
+	for _, r := range report.Wildcard {
+		wild = append(wild, r.Name+" "+r.Match)
+	}
+	if want := []string{"wild@docker regexp", "fallback@file any"}; !reflect.DeepEqual(wild, want) {
+		t.Errorf("wildcard = %q, want %q", wild, want)
+	}
+
+	if len(report.Uncatalogued) != 1 || report.Uncatalogued[0].Name != "new@docker" || report.Uncatalogued[0].Protected {
+		t.Errorf("uncatalogued = %+v, want only unprotected new@docker", report.Uncatalogued)
+	}
+}
+
+func TestFetchTraefikError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	s := &Server{cfg: &config.Config{TraefikAPIURL: srv.URL}}
+	var routers []apiRouter
+	if err := s.fetchTraefik(context.Background(), "/api/http/routers", &routers); err == nil {
+		t.Fatal("expected an error for a 404 from the Traefik API")
+	}
+}
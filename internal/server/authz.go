@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/session"
+)
+
+// authRequest is a proxy-neutral description of a request to authorize.
+// Each proxy integration fills it from its own header conventions.
+type authRequest struct {
+	Host             string          // original request host
+	Scheme           string          // original request scheme
+	URI              string          // original request URI (path and query)
+	Accept           string          // original Accept header
+	Token            string          // noknok session token from the cookie
+	Client           session.Binding // original client, for session binding
+	HasAuthorization bool            // request carries its own credentials
+}
+
+// authOutcome is the access decision for an authRequest.
+type authOutcome int
+
+const (
+	authAllow        authOutcome = iota // let the request through
+	authLogin                           // browser without a session: send to login
+	authPortal                          // browser that may not use this service: send to portal
+	authUnauthorized                    // non-browser without credentials
+	authForbidden                       // authenticated but not granted
+	authUnavailable                     // service disabled
+	authBadRequest                      // no original host to decide for
+)
+
+// authDecision is the result of decideAuth. Headers are only set for
+// authAllow; Redirect only for authLogin and authPortal. Service is the
+// catalog entry the host resolved to, or nil.
+type authDecision struct {
+	Outcome  authOutcome
+	Headers  http.Header
+	Redirect string
+	Service  *database.Service
+}
+
+func (r authRequest) wantsHTML() bool {
+	return strings.Contains(r.Accept, "text/html")
+}
+
+// decideAuth applies noknok's access rules: disabled services block
+// everyone, public services allow everyone, otherwise a valid session with a
+// grant (or an owner/admin role) is required. Requests carrying their own
+// Authorization header pass through for the backend to validate.
+func (s *Server) decideAuth(ctx context.Context, r authRequest) authDecision {
+	if database.NormalizeHost(r.Host) == "" {
+		return authDecision{Outcome: authBadRequest}
+	}
+
+	// Resolve the service once; the status check, the grant check and the
+	// proxy's upstream all use this row.
+	svc, err := s.db.GetServiceByHost(ctx, r.Host)
+	if err != nil {
+		slog.Warn("auth: service lookup failed", "host", r.Host, "error", err)
+		return authDecision{Outcome: authUnavailable}
+	}
+	// Check service status — disabled blocks all, public allows all.
+	if svc != nil && !svc.Enabled {
+		if r.wantsHTML() {
+			return authDecision{Outcome: authPortal, Redirect: s.cfg.PublicURL + "/", Service: svc}
+		}
+		return authDecision{Outcome: authUnavailable, Service: svc}
+	}
+	if svc != nil && svc.Public {
+		return authDecision{Outcome: authAllow, Service: svc}
+	}
+
+	if r.Token != "" {
+		sess, err := s.sess.Validate(ctx, r.Token, r.Client)
+		if err == nil {
+			h := make(http.Header)
+			// Check if user is owner/admin (full access) or has a grant for this service.
+			role, roleErr := s.db.GetUserServiceRole(ctx, sess.DID, svc)
+			if roleErr != nil || role == "" {
+				// User has no grant for this service — deny access.
+				// Redirect browser to portal so they see what they can access.
+				if r.wantsHTML() {
+					return authDecision{Outcome: authPortal, Redirect: s.cfg.PublicURL + "/", Service: svc}
+				}
+				return authDecision{Outcome: authForbidden, Service: svc}
+			}
+			h.Set("X-User-Role", role)
+
+			h.Set("X-User-DID", sess.DID)
+			h.Set("X-User-Handle", sess.Handle)
+			if sess.Username != "" {
+				h.Set("X-WEBAUTH-USER", sess.Username)
+			}
+			if s.cfg.ForwardUserName && sess.DisplayName != "" {
+				h.Set("X-User-Name", sess.DisplayName)
+			}
+			return authDecision{Outcome: authAllow, Headers: h, Service: svc}
+		}
+	}
+
+	// Pass through requests with an Authorization header (e.g. PATs, API tokens)
+	// so the backend service can validate them itself.
+	if r.HasAuthorization {
+		return authDecision{Outcome: authAllow, Service: svc}
+	}
+
+	// Non-browser clients (git, curl, API) get 401 so they can retry with
+	// credentials. The backend (e.g. Gitea) will issue its own WWW-Authenticate
+	// challenge once it receives the request.
+	if !r.wantsHTML() {
+		return authDecision{Outcome: authUnauthorized}
+	}
+
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	loginURL := s.cfg.PublicURL + "/login?redirect=" + url.QueryEscape(fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URI))
+	return authDecision{Outcome: authLogin, Redirect: loginURL, Service: svc}
+}
+
+// writeAllow copies the identity headers of an allow decision to the response.
+func (s *Server) writeAllow(c echo.Context, d authDecision) error {
+	for k, v := range d.Headers {
+		c.Response().Header()[k] = v
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// sessionToken returns the request's session token, or "".
+func (s *Server) sessionToken(c echo.Context) string {
+	return s.sess.Token(c.Request())
+}
+
+// clientBinding describes the requesting client for session binding. Behind
+// a forward-auth proxy the address comes from X-Forwarded-For, read by the
+// extractor set in New so that only trusted hops are skipped.
+func (s *Server) clientBinding(c echo.Context) session.Binding {
+	return session.NewBinding(c.RealIP(), c.Request().Header)
+}
+
+// setSessionCookie sets a session cookie (or its clearing cookie) along with
+// the host-only portal twin when one is configured.
+func (s *Server) setSessionCookie(c echo.Context, cookie *http.Cookie) {
+	c.SetCookie(cookie)
+	if pc := s.sess.PortalCookie(cookie); pc != nil {
+		c.SetCookie(pc)
+	}
+}
+
+// handleAuthNginx is the nginx auth_request endpoint. nginx only understands
+// 2xx, 401 and 403 from an auth subrequest, so redirects are signalled in the
+// X-Auth-Redirect header for an error_page handler to act on:
+//
+//	location = /_noknok {
+//	    internal;
+//	    proxy_pass http://noknok:4321/auth/nginx;
+//	    proxy_pass_request_body off;
+//	    proxy_set_header Content-Length "";
+//	    proxy_set_header X-Original-URL $scheme://$http_host$request_uri;
+//	}
+//	location / {
+//	    auth_request /_noknok;
+//	    auth_request_set $noknok_redirect $upstream_http_x_auth_redirect;
+//	    auth_request_set $noknok_did $upstream_http_x_user_did;
+//	    error_page 401 403 = @noknok;
+//	    proxy_set_header X-User-DID $noknok_did;
+//	    ...
+//	}
+//	location @noknok {
+//	    if ($noknok_redirect) { return 302 $noknok_redirect; }
+//	    return 401;
+//	}
+//
+// The original URL is read from X-Original-URL, or from X-Original-URI with
+// X-Forwarded-Host and X-Forwarded-Proto. Without either header the request
+// fails with 500.
+func (s *Server) handleAuthNginx(c echo.Context) error {
+	r := c.Request()
+	req := authRequest{
+		Accept:           r.Header.Get("Accept"),
+		Client:           s.clientBinding(c),
+		HasAuthorization: r.Header.Get("Authorization") != "",
+	}
+	if u, err := url.Parse(r.Header.Get("X-Original-URL")); err == nil && u.Host != "" {
+		req.Scheme, req.Host, req.URI = u.Scheme, u.Host, u.RequestURI()
+	} else {
+		req.Host = r.Header.Get("X-Forwarded-Host")
+		if req.Host == "" {
+			// r.Host names noknok itself in the auth subrequest, not the
+			// protected site, so there is nothing to decide on.
+			slog.Warn("nginx auth: neither X-Original-URL nor X-Forwarded-Host set", "remote", r.RemoteAddr)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		req.Scheme = r.Header.Get("X-Forwarded-Proto")
+		req.URI = r.Header.Get("X-Original-URI")
+	}
+	req.Token = s.sess.TokenForHost(r, req.Host)
+
+	d := s.decideAuth(r.Context(), req)
+	switch d.Outcome {
+	case authAllow:
+		return s.writeAllow(c, d)
+	case authLogin:
+		c.Response().Header().Set("X-Auth-Redirect", d.Redirect)
+		return c.NoContent(http.StatusUnauthorized)
+	case authPortal:
+		c.Response().Header().Set("X-Auth-Redirect", d.Redirect)
+		return c.NoContent(http.StatusForbidden)
+	case authForbidden, authUnavailable:
+		// auth_request treats anything but 401/403 as an internal error.
+		return c.NoContent(http.StatusForbidden)
+	case authBadRequest:
+		return c.NoContent(http.StatusBadRequest)
+	}
+	return c.NoContent(http.StatusUnauthorized)
+}
+
+// handleAuthCaddy is the Caddy forward_auth endpoint. Caddy forwards the
+// original request headers plus X-Forwarded-Method/-Uri/-Host/-Proto, and
+// returns any non-2xx response (including redirects) to the client as is:
+//
+//	forward_auth noknok:4321 {
+//	    uri /auth/caddy
+//	    copy_headers X-User-DID X-User-Handle X-User-Role X-User-Name X-WEBAUTH-USER
+//	}
+func (s *Server) handleAuthCaddy(c echo.Context) error {
+	r := c.Request()
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	req := authRequest{
+		Host:             host,
+		Scheme:           scheme,
+		URI:              r.Header.Get("X-Forwarded-Uri"),
+		Accept:           r.Header.Get("Accept"),
+		Token:            s.sess.TokenForHost(r, host),
+		Client:           s.clientBinding(c),
+		HasAuthorization: r.Header.Get("Authorization") != "",
+	}
+
+	d := s.decideAuth(r.Context(), req)
+	switch d.Outcome {
+	case authAllow:
+		return s.writeAllow(c, d)
+	case authLogin, authPortal:
+		return c.Redirect(http.StatusFound, d.Redirect)
+	case authUnavailable:
+		return c.NoContent(http.StatusServiceUnavailable)
+	case authForbidden:
+		return c.NoContent(http.StatusForbidden)
+	case authBadRequest:
+		return c.NoContent(http.StatusBadRequest)
+	}
+	return c.NoContent(http.StatusUnauthorized)
+}
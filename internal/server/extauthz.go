@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/session"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// identityHeaders are the headers noknok injects on allowed requests. They
+// are stripped from requests that noknok allows without an identity so a
+// client cannot spoof them.
+var identityHeaders = []string{"X-User-DID", "X-User-Handle", "X-User-Role", "X-User-Name", "X-WEBAUTH-USER"}
+
+// extAuthz implements the Envoy ext_authz v3 gRPC Authorization service on
+// top of decideAuth.
+type extAuthz struct {
+	s *Server
+}
+
+// startExtAuthz serves the ext_authz gRPC API on cfg.ExtAuthzAddr. Envoy:
+//
+//	http_filters:
+//	- name: envoy.filters.http.ext_authz
+//	  typed_config:
+//	    "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+//	    transport_api_version: V3
+//	    grpc_service:
+//	      envoy_grpc: { cluster_name: noknok_authz }
+func (s *Server) startExtAuthz() {
+	if s.cfg.ExtAuthzAddr == "" {
+		return
+	}
+	lis, err := net.Listen("tcp", s.cfg.ExtAuthzAddr)
+	if err != nil {
+		slog.Error("ext_authz listen failed", "addr", s.cfg.ExtAuthzAddr, "error", err)
+		return
+	}
+	s.grpc = grpc.NewServer()
+	authv3.RegisterAuthorizationServer(s.grpc, &extAuthz{s: s})
+	go func() {
+		slog.Info("ext_authz gRPC listening", "addr", s.cfg.ExtAuthzAddr)
+		if err := s.grpc.Serve(lis); err != nil {
+			slog.Error("ext_authz server stopped", "error", err)
+		}
+	}()
+}
+
+// Check implements authv3.AuthorizationServer.
+func (a *extAuthz) Check(ctx context.Context, in *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	if !a.s.trustedGRPCCaller(ctx) {
+		return &authv3.CheckResponse{
+			Status: &status.Status{Code: int32(codes.PermissionDenied)},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+			}},
+		}, nil
+	}
+
+	hr := in.GetAttributes().GetRequest().GetHttp()
+	hdr := hr.GetHeaders() // keys are lower-cased by Envoy
+	h := make(http.Header, len(hdr))
+	for k, v := range hdr {
+		h.Set(k, v)
+	}
+	// Envoy resolves the downstream address through its trusted hops
+	// (xff_num_trusted_hops); the raw X-Forwarded-For is client-controlled.
+	ip := in.GetAttributes().GetSource().GetAddress().GetSocketAddress().GetAddress()
+
+	req := authRequest{
+		Host:             hr.GetHost(),
+		Scheme:           hr.GetScheme(),
+		URI:              hr.GetPath(),
+		Accept:           hdr["accept"],
+		HasAuthorization: hdr["authorization"] != "",
+		Token:            a.s.sess.TokenForHost(&http.Request{Header: h}, hr.GetHost()),
+		Client:           session.NewBinding(ip, h),
+	}
+
+	d := a.s.decideAuth(ctx, req)
+	if d.Outcome == authAllow {
+		ok := &authv3.OkHttpResponse{}
+		for _, name := range identityHeaders {
+			if v := d.Headers.Get(name); v != "" {
+				ok.Headers = append(ok.Headers, headerOption(name, v))
+			} else {
+				ok.HeadersToRemove = append(ok.HeadersToRemove, strings.ToLower(name))
+			}
+		}
+		return &authv3.CheckResponse{
+			Status:       &status.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+		}, nil
+	}
+
+	denied := &authv3.DeniedHttpResponse{}
+	code := codes.PermissionDenied
+	switch d.Outcome {
+	case authLogin, authPortal:
+		denied.Status = &typev3.HttpStatus{Code: typev3.StatusCode_Found}
+		denied.Headers = []*corev3.HeaderValueOption{headerOption("Location", d.Redirect)}
+		code = codes.Unauthenticated
+	case authUnauthorized:
+		denied.Status = &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized}
+		code = codes.Unauthenticated
+	case authUnavailable:
+		denied.Status = &typev3.HttpStatus{Code: typev3.StatusCode_ServiceUnavailable}
+		code = codes.Unavailable
+	case authBadRequest:
+		denied.Status = &typev3.HttpStatus{Code: typev3.StatusCode_BadRequest}
+		code = codes.InvalidArgument
+	default:
+		denied.Status = &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden}
+	}
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(code)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied},
+	}, nil
+}
+
+func headerOption(name, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: name, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+// handleAuthEnvoy is the ext_authz HTTP service. Envoy sends the original
+// method, Host and path (after path_prefix) plus the headers listed in
+// allowed_headers; any non-2xx response is returned to the client as is.
+//
+//	http_service:
+//	  server_uri: { uri: noknok:4321, cluster: noknok, timeout: 1s }
+//	  path_prefix: /auth/envoy
+//	  authorization_request:
+//	    allowed_headers:
+//	      patterns: [{ exact: cookie }, { exact: accept }, { exact: x-forwarded-proto }]
+//	  authorization_response:
+//	    allowed_upstream_headers:
+//	      patterns: [{ prefix: x-user- }, { exact: x-webauth-user }]
+func (s *Server) handleAuthEnvoy(c echo.Context) error {
+	r := c.Request()
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+	uri := strings.TrimPrefix(r.URL.RequestURI(), "/auth/envoy")
+	if uri == "" {
+		uri = "/"
+	}
+	req := authRequest{
+		Host:             r.Host,
+		Scheme:           scheme,
+		URI:              uri,
+		Accept:           r.Header.Get("Accept"),
+		Token:            s.sessionToken(c),
+		Client:           s.clientBinding(c),
+		HasAuthorization: r.Header.Get("Authorization") != "",
+	}
+
+	d := s.decideAuth(r.Context(), req)
+	switch d.Outcome {
+	case authAllow:
+		return s.writeAllow(c, d)
+	case authLogin, authPortal:
+		return c.Redirect(http.StatusFound, d.Redirect)
+	case authUnavailable:
+		return c.NoContent(http.StatusServiceUnavailable)
+	case authForbidden:
+		return c.NoContent(http.StatusForbidden)
+	case authBadRequest:
+		return c.NoContent(http.StatusBadRequest)
+	}
+	return c.NoContent(http.StatusUnauthorized)
+}
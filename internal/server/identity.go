@@ -10,12 +10,12 @@ import (
 
 // handleSwitchIdentity switches the active identity within the session group.
 func (s *Server) handleSwitchIdentity(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
-	if err != nil || cookie.Value == "" {
+	token := s.sessionToken(c)
+	if token == "" {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
 
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 	if err != nil {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
@@ -30,18 +30,19 @@ func (s *Server) handleSwitchIdentity(c echo.Context) error {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
 	}
 
-	c.SetCookie(newCookie)
-	return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
+	s.setSessionCookie(c, newCookie)
+	return c.Redirect(http.StatusFound, s.relayChain(c.Request().Context(), newCookie.Value, s.cfg.PublicURL+"/"))
 }
 
-// handleLogoutOne logs out a single identity from the session group.
+// handleLogoutOne logs out a single identity from the session group and
+// revokes its atproto OAuth session.
 func (s *Server) handleLogoutOne(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
-	if err != nil || cookie.Value == "" {
+	token := s.sessionToken(c)
+	if token == "" {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
 
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 	if err != nil {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
@@ -51,6 +52,16 @@ func (s *Server) handleLogoutOne(c echo.Context) error {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
 	}
 
+	// Revoke the target's atproto OAuth session before the row disappears.
+	if group, gErr := s.sess.ListGroup(c.Request().Context(), sess.GroupID); gErr == nil {
+		for _, g := range group {
+			if g.ID == targetID {
+				s.revokeOAuthSessions(c.Request().Context(), []session.Session{g})
+				break
+			}
+		}
+	}
+
 	wasActive := targetID == sess.ID
 	newCookie, err := s.sess.DestroyOne(c.Request().Context(), sess.GroupID, targetID, wasActive)
 	if err != nil {
@@ -58,25 +69,28 @@ func (s *Server) handleLogoutOne(c echo.Context) error {
 	}
 
 	if newCookie != nil {
-		c.SetCookie(newCookie)
+		s.setSessionCookie(c, newCookie)
 	}
 
 	// If no sessions remain, redirect to login.
 	if wasActive && newCookie != nil && newCookie.MaxAge == -1 {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
+	if newCookie != nil {
+		return c.Redirect(http.StatusFound, s.relayChain(c.Request().Context(), newCookie.Value, s.cfg.PublicURL+"/"))
+	}
 
 	return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/")
 }
 
 // handleListIdentities returns all identities in the current session group as JSON.
 func (s *Server) handleListIdentities(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
-	if err != nil || cookie.Value == "" {
+	token := s.sessionToken(c)
+	if token == "" {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
 	}
 
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
 	}
@@ -86,20 +100,30 @@ func (s *Server) handleListIdentities(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list group"})
 	}
 
+	dids := make([]string, 0, len(group))
+	for _, g := range group {
+		dids = append(dids, g.DID)
+	}
+	profiles, _ := s.db.ListIdentitiesByDID(c.Request().Context(), dids)
+
 	type identity struct {
-		ID     int64  `json:"id"`
-		DID    string `json:"did"`
-		Handle string `json:"handle"`
-		Active bool   `json:"active"`
+		ID          int64  `json:"id"`
+		DID         string `json:"did"`
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name,omitempty"`
+		AvatarURL   string `json:"avatar_url,omitempty"`
+		Active      bool   `json:"active"`
 	}
 
 	result := make([]identity, 0, len(group))
 	for _, g := range group {
 		result = append(result, identity{
-			ID:     g.ID,
-			DID:    g.DID,
-			Handle: g.Handle,
-			Active: g.Token == sess.Token,
+			ID:          g.ID,
+			DID:         g.DID,
+			Handle:      g.Handle,
+			DisplayName: profiles[g.DID].DisplayName,
+			AvatarURL:   profiles[g.DID].AvatarURL,
+			Active:      g.ID == sess.ID,
 		})
 	}
 
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base32"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/ldap"
+	"github.com/primal-host/noknok/internal/notify"
+)
+
+// maxAppPasswords caps how many app passwords one user may hold.
+const maxAppPasswords = 25
+
+// startLDAP serves the read-only LDAP directory when LDAP_ADDR is set.
+func (s *Server) startLDAP() {
+	if s.cfg.LDAPAddr == "" {
+		return
+	}
+	var tlsConfig *tls.Config
+	if s.cfg.LDAPTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.LDAPTLSCert, s.cfg.LDAPTLSKey)
+		if err != nil {
+			slog.Error("ldap: failed to load TLS certificate", "error", err)
+			return
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	} else {
+		slog.Warn("ldap: listening without TLS; app passwords are sent in clear text", "addr", s.cfg.LDAPAddr)
+	}
+	// Binds re-check the account at most once per VERIFY_INTERVAL, so app
+	// passwords stop working like sessions do when an account goes away.
+	recheck, _ := time.ParseDuration(s.cfg.VerifyInterval)
+	s.ldap = ldap.NewServer(&ldapDirectory{s: s, recheck: recheck, verified: map[string]time.Time{}}, ldap.Config{
+		BaseDN:         s.cfg.LDAPBaseDN,
+		AllowAnonymous: s.cfg.LDAPAllowAnonymous,
+	})
+	go func() {
+		slog.Info("ldap listening", "addr", s.cfg.LDAPAddr, "tls", tlsConfig != nil, "base_dn", s.cfg.LDAPBaseDN)
+		if err := s.ldap.ListenAndServe(s.cfg.LDAPAddr, tlsConfig); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.Error("ldap server stopped", "error", err)
+		}
+	}()
+}
+
+// ldapDirectory exposes users with a username under ou=users and one group
+// per service under ou=groups. Group members are the users handleAuth would
+// let through: everyone for public services, owners and admins for every
+// service, others by grant; disabled services have no members.
+type ldapDirectory struct {
+	s       *Server
+	recheck time.Duration // 0 disables the account check on bind
+
+	mu       sync.Mutex
+	verified map[string]time.Time // DID -> last successful account check
+}
+
+func (d *ldapDirectory) usersDN() string  { return "ou=users," + d.s.cfg.LDAPBaseDN }
+func (d *ldapDirectory) groupsDN() string { return "ou=groups," + d.s.cfg.LDAPBaseDN }
+
+func (d *ldapDirectory) userDN(username string) string {
+	return "uid=" + username + "," + d.usersDN()
+}
+
+// Bind checks an app password for "uid=<username>,ou=users,<base>" or a
+// bare username.
+func (d *ldapDirectory) Bind(ctx context.Context, dn, password string) (bool, error) {
+	username := strings.TrimSpace(dn)
+	if strings.Contains(dn, "=") {
+		rdn, parent, _ := strings.Cut(dn, ",")
+		attr, value, _ := strings.Cut(rdn, "=")
+		if !strings.EqualFold(strings.TrimSpace(attr), "uid") || ldap.NormalizeDN(parent) != ldap.NormalizeDN(d.usersDN()) {
+			return false, nil
+		}
+		username = strings.TrimSpace(value)
+	}
+	if username == "" {
+		return false, nil
+	}
+	user, err := d.s.db.GetUserByUsername(ctx, username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	ok, err := d.s.db.CheckAppPassword(ctx, user.ID, d.s.sess.HashSecret(password))
+	if err != nil || !ok {
+		return false, err
+	}
+	return d.accountActive(ctx, user.DID), nil
+}
+
+// accountActive applies the session verifier's account checks to a bind.
+// A revoked account loses its app passwords; a lookup failure lets the bind
+// through, as the verifier keeps sessions on transient failures.
+func (d *ldapDirectory) accountActive(ctx context.Context, did string) bool {
+	if d.recheck <= 0 {
+		return true
+	}
+	d.mu.Lock()
+	last, ok := d.verified[did]
+	d.mu.Unlock()
+	if ok && time.Since(last) < d.recheck {
+		return true
+	}
+
+	reason, _, err := d.s.accountRevocation(ctx, did)
+	if err != nil {
+		slog.Warn("ldap: account lookup failed", "did", did, "error", err)
+		return true
+	}
+	if reason != "" {
+		d.s.revokeAppPasswords(ctx, did, reason)
+		return false
+	}
+	d.mu.Lock()
+	d.verified[did] = time.Now()
+	d.mu.Unlock()
+	return true
+}
+
+// Entries renders the directory from the current users, services and grants.
+func (d *ldapDirectory) Entries(ctx context.Context) ([]ldap.Entry, error) {
+	users, err := d.s.db.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idents, err := d.s.db.ListAllIdentities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	services, err := d.s.db.ListServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := d.s.db.ListGrants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	granted := map[[2]int64]bool{}
+	for _, g := range grants {
+		granted[[2]int64{g.UserID, g.ServiceID}] = true
+	}
+	dids := map[int64][]string{}
+	handles := map[int64][]string{}
+	for _, id := range idents {
+		// Primary identity first.
+		if id.IsPrimary {
+			dids[id.UserID] = append([]string{id.DID}, dids[id.UserID]...)
+			handles[id.UserID] = append([]string{id.Handle}, handles[id.UserID]...)
+		} else {
+			dids[id.UserID] = append(dids[id.UserID], id.DID)
+			handles[id.UserID] = append(handles[id.UserID], id.Handle)
+		}
+	}
+
+	members := map[int64][]*database.User{}
+	memberOf := map[int64][]string{}
+	var listed []*database.User
+	for i := range users {
+		u := &users[i]
+		if u.Username == "" {
+			continue
+		}
+		listed = append(listed, u)
+		admin := u.Role == "owner" || u.Role == "admin"
+		for _, svc := range services {
+			if !svc.Enabled || !(svc.Public || admin || granted[[2]int64{u.ID, svc.ID}]) {
+				continue
+			}
+			members[svc.ID] = append(members[svc.ID], u)
+			memberOf[u.ID] = append(memberOf[u.ID], "cn="+svc.Slug+","+d.groupsDN())
+		}
+	}
+
+	base := d.s.cfg.LDAPBaseDN
+	rdnAttr, rdnValue, _ := strings.Cut(strings.Split(base, ",")[0], "=")
+	entries := []ldap.Entry{
+		{DN: base, Attrs: []ldap.Attribute{
+			{Name: "objectClass", Values: []string{"top", "domain"}},
+			{Name: strings.TrimSpace(rdnAttr), Values: []string{strings.TrimSpace(rdnValue)}},
+		}},
+		{DN: d.usersDN(), Attrs: []ldap.Attribute{
+			{Name: "objectClass", Values: []string{"top", "organizationalUnit"}},
+			{Name: "ou", Values: []string{"users"}},
+		}},
+		{DN: d.groupsDN(), Attrs: []ldap.Attribute{
+			{Name: "objectClass", Values: []string{"top", "organizationalUnit"}},
+			{Name: "ou", Values: []string{"groups"}},
+		}},
+	}
+
+	for _, u := range listed {
+		handle := u.Handle
+		if handle == "" {
+			handle = u.Username
+		}
+		cn := u.DisplayName
+		if cn == "" {
+			cn = handle
+		}
+		attrs := []ldap.Attribute{
+			{Name: "objectClass", Values: []string{"top", "person", "organizationalPerson", "inetOrgPerson"}},
+			{Name: "uid", Values: []string{u.Username}},
+			{Name: "cn", Values: []string{cn}},
+			{Name: "sn", Values: []string{handle}},
+			{Name: "displayName", Values: []string{cn}},
+			{Name: "did", Values: dids[u.ID]},
+			{Name: "handle", Values: handles[u.ID]},
+			{Name: "noknokRole", Values: []string{u.Role}},
+		}
+		if len(memberOf[u.ID]) > 0 {
+			attrs = append(attrs, ldap.Attribute{Name: "memberOf", Values: memberOf[u.ID]})
+		}
+		entries = append(entries, ldap.Entry{DN: d.userDN(u.Username), Attrs: attrs})
+	}
+
+	for _, svc := range services {
+		attrs := []ldap.Attribute{
+			{Name: "objectClass", Values: []string{"top", "groupOfNames"}},
+			{Name: "cn", Values: []string{svc.Slug}},
+			{Name: "description", Values: []string{svc.Name}},
+		}
+		if ms := members[svc.ID]; len(ms) > 0 {
+			dns := make([]string, 0, len(ms))
+			uids := make([]string, 0, len(ms))
+			for _, u := range ms {
+				dns = append(dns, d.userDN(u.Username))
+				uids = append(uids, u.Username)
+			}
+			attrs = append(attrs,
+				ldap.Attribute{Name: "member", Values: dns},
+				ldap.Attribute{Name: "memberUid", Values: uids})
+		}
+		entries = append(entries, ldap.Entry{DN: "cn=" + svc.Slug + "," + d.groupsDN(), Attrs: attrs})
+	}
+	return entries, nil
+}
+
+// --- App passwords ---
+
+// generateAppPassword returns 160 random bits as lowercase base32 in
+// dash-separated groups of four, e.g. "abcd-efgh-...".
+func generateAppPassword() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	var groups []string
+	for i := 0; i < len(raw); i += 4 {
+		groups = append(groups, raw[i:min(i+4, len(raw))])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// handleListAppPasswords returns the caller's app passwords and the DN
+// they bind as.
+func (s *Server) handleListAppPasswords(c echo.Context) error {
+	if s.ldap == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "LDAP is not enabled"})
+	}
+	user := s.sessionUser(c)
+	if user == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	items, err := s.db.ListAppPasswords(c.Request().Context(), user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list app passwords"})
+	}
+	if items == nil {
+		items = []database.AppPassword{}
+	}
+	bindDN := ""
+	if user.Username != "" {
+		bindDN = (&ldapDirectory{s: s}).userDN(user.Username)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"bind_dn": bindDN, "items": items})
+}
+
+// handleCreateAppPassword generates an app password for the caller. The
+// password is only returned in this response.
+func (s *Server) handleCreateAppPassword(c echo.Context) error {
+	if s.ldap == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "LDAP is not enabled"})
+	}
+	user := s.sessionUser(c)
+	if user == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	if user.Username == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "your account has no username; ask an administrator to set one"})
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "name is required (at most 100 characters)"})
+	}
+
+	ctx := c.Request().Context()
+	existing, err := s.db.ListAppPasswords(ctx, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create app password"})
+	}
+	if len(existing) >= maxAppPasswords {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "too many app passwords; revoke one first"})
+	}
+	password, err := generateAppPassword()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create app password"})
+	}
+	ap, err := s.db.CreateAppPassword(ctx, user.ID, req.Name, s.sess.HashSecret(password))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create app password"})
+	}
+
+	s.notifier.Notify(ctx, notify.Event{
+		Kind:   notify.KindAppPassword,
+		Title:  "App password \"" + req.Name + "\" created",
+		Body:   "An app password named \"" + req.Name + "\" was created for @" + user.Handle + ". It can sign in to LDAP-connected apps as you. If this was not you, revoke it in the portal.",
+		Detail: map[string]any{"app_password_id": ap.ID, "name": req.Name},
+	}, user.ID)
+
+	return c.JSON(http.StatusCreated, map[string]any{"app_password": ap, "password": password})
+}
+
+// handleDeleteAppPassword revokes one of the caller's app passwords.
+func (s *Server) handleDeleteAppPassword(c echo.Context) error {
+	user := s.sessionUser(c)
+	if user == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid id"})
+	}
+	if _, err := s.db.DeleteAppPassword(c.Request().Context(), user.ID, id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "app password not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete app password"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
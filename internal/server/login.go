@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/atproto"
 	"github.com/primal-host/noknok/internal/config"
 	"github.com/primal-host/noknok/internal/database"
 	"github.com/primal-host/noknok/internal/session"
@@ -15,7 +17,7 @@ import (
 
 const redirectCookieName = "noknok_redirect"
 
-// handleLoginPage renders the login form (handle only, no password).
+// handleLoginPage renders the login form (handle, DID, or PDS URL; no password).
 func (s *Server) handleLoginPage(c echo.Context) error {
 	redirect := c.QueryParam("redirect")
 	errMsg := c.QueryParam("error")
@@ -38,64 +40,108 @@ func (s *Server) handleLogin(c echo.Context) error {
 		return c.HTML(http.StatusOK, loginHTML(redirect, "Handle is required.", s.hasValidSession(c), nil))
 	}
 
-	// Default bare names to .bsky.social.
-	if !strings.Contains(handle, ".") {
-		handle += ".bsky.social"
+	in, err := atproto.ParseLoginInput(handle)
+	if err != nil {
+		return c.HTML(http.StatusOK, loginHTML(redirect, loginInputError(in.Kind), s.hasValidSession(c), nil))
 	}
 
 	// Store redirect URL in a cookie so we can use it after the OAuth callback.
 	if redirect != "" && isAllowedRedirect(redirect, s.cfg) {
-		secure := strings.HasPrefix(s.cfg.PublicURL, "https://")
 		c.SetCookie(&http.Cookie{
 			Name:     redirectCookieName,
 			Value:    redirect,
 			Path:     "/",
 			MaxAge:   600, // 10 minutes
 			HttpOnly: true,
-			Secure:   secure,
+			Secure:   s.cfg.CookieSecure,
 			SameSite: http.SameSiteLaxMode,
 		})
 	}
 
-	authURL, err := s.oauth.StartLogin(c.Request().Context(), handle)
+	authURL, err := s.oauth.StartLogin(c.Request().Context(), in)
 	if err != nil {
-		slog.Warn("OAuth start failed", "handle", handle, "error", err)
-		return c.HTML(http.StatusOK, loginHTML(redirect, "Could not start login. Check your handle and try again.", s.hasValidSession(c), nil))
+		slog.Warn("OAuth start failed", "input", in.Value, "error", err)
+		msg := loginStartError(in.Kind)
+		if errors.Is(err, atproto.ErrHostNotAllowed) {
+			msg = hostNotAllowedMsg
+		}
+		return c.HTML(http.StatusOK, loginHTML(redirect, msg, s.hasValidSession(c), nil))
 	}
 
 	return c.Redirect(http.StatusFound, authURL)
 }
 
+const hostNotAllowedMsg = "Accounts on that server are not allowed to sign in here."
+
+// loginInputError describes malformed login input of the given kind.
+func loginInputError(kind atproto.LoginKind) string {
+	switch kind {
+	case atproto.LoginDID:
+		return "That DID is not valid. Only did:plc and did:web are supported."
+	case atproto.LoginServer:
+		return "That server URL is not valid. Use an https:// URL."
+	}
+	return "That handle is not valid."
+}
+
+// loginStartError describes a failure to start OAuth for the given kind.
+func loginStartError(kind atproto.LoginKind) string {
+	switch kind {
+	case atproto.LoginDID:
+		return "Could not resolve that DID to a PDS. Check it and try again."
+	case atproto.LoginServer:
+		return "Could not find an atproto authorization server at that URL."
+	}
+	return "Could not resolve that handle. Try your DID or PDS URL instead."
+}
+
 // handleOAuthCallback processes the auth server redirect.
 func (s *Server) handleOAuthCallback(c echo.Context) error {
-	did, resolvedHandle, err := s.oauth.HandleCallback(c.Request().Context(), c.QueryParams())
+	// A loopback client's redirect URI is a 127.0.0.1 address, where the
+	// browser has none of our cookies. Bounce to the public host first.
+	if s.oauth.IsLoopback() {
+		if pub, err := url.Parse(s.cfg.PublicURL); err == nil && c.Request().Host != pub.Host {
+			return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/oauth/callback?"+c.QueryString())
+		}
+	}
+
+	res, err := s.oauth.HandleCallback(c.Request().Context(), c.QueryParams())
 	if err != nil {
 		slog.Warn("OAuth callback failed", "error", err)
-		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape("Authentication failed. Please try again."))
+		msg := "Authentication failed. Please try again."
+		if errors.Is(err, atproto.ErrHostNotAllowed) {
+			msg = hostNotAllowedMsg
+		}
+		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape(msg))
 	}
+	did, resolvedHandle := res.DID, res.Handle
 
 	// Look up user by identity DID.
 	user, err := s.db.GetUserByIdentityDID(c.Request().Context(), did)
 	if err != nil {
 		slog.Warn("unauthorized DID attempted login", "did", did, "handle", resolvedHandle)
+		s.revokeOAuthSessions(c.Request().Context(), []session.Session{{DID: did, OAuthSessionID: res.SessionID}})
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape("Access denied. You are not authorized."))
 	}
 
 	// Check for existing session group (adding identity to existing browser session).
 	var groupID string
-	if existing, err := c.Cookie(session.CookieName()); err == nil && existing.Value != "" {
-		if existingSess, err := s.sess.Validate(c.Request().Context(), existing.Value); err == nil {
+	if existing := s.sessionToken(c); existing != "" {
+		if existingSess, err := s.sess.Validate(c.Request().Context(), existing, s.clientBinding(c)); err == nil {
 			groupID = existingSess.GroupID
 
 			// If this DID already exists in the group, switch to it instead of creating a duplicate.
-			if existingID, _, found := s.sess.GroupHasDID(c.Request().Context(), groupID, did); found {
+			if existingID, found := s.sess.GroupHasDID(c.Request().Context(), groupID, did); found {
+				// The existing session keeps its own OAuth session; drop the new one.
+				s.revokeOAuthSessions(c.Request().Context(), []session.Session{{DID: did, OAuthSessionID: res.SessionID}})
 				switchCookie, switchErr := s.sess.SwitchTo(c.Request().Context(), groupID, existingID)
 				if switchErr != nil {
 					slog.Warn("failed to switch to existing identity", "did", did, "error", switchErr)
 				} else {
-					c.SetCookie(switchCookie)
+					s.setSessionCookie(c, switchCookie)
 				}
 				slog.Info("switched to existing identity in group", "did", did, "handle", resolvedHandle)
+				s.emitLogin(c, user.ID, did, resolvedHandle, res.PDSURL, true)
 				dest := s.cfg.PublicURL + "/"
 				if rc, err := c.Cookie(redirectCookieName); err == nil && rc.Value != "" {
 					if isAllowedRedirect(rc.Value, s.cfg) {
@@ -103,10 +149,15 @@ func (s *Server) handleOAuthCallback(c echo.Context) error {
 					}
 					c.SetCookie(&http.Cookie{Name: redirectCookieName, Value: "", Path: "/", MaxAge: -1})
 				}
+				// The switch rotated the session's token; refresh it on
+				// every cookie domain before landing on dest.
+				if switchCookie != nil {
+					return c.Redirect(http.StatusFound, s.relayChain(c.Request().Context(), switchCookie.Value, dest))
+				}
 				// Relay to external domain if needed.
 				if destURL, parseErr := url.Parse(dest); parseErr == nil && destURL.Host != "" {
 					if s.cfg.IsExternalHost(destURL.Host) {
-						token := existing.Value
+						token := existing
 						if switchCookie != nil {
 							token = switchCookie.Value
 						}
@@ -121,14 +172,20 @@ func (s *Server) handleOAuthCallback(c echo.Context) error {
 	}
 
 	// Create noknok session.
-	cookie, err := s.sess.Create(c.Request().Context(), user.ID, did, resolvedHandle, groupID)
+	cookie, err := s.sess.Create(c.Request().Context(), user.ID, did, resolvedHandle, groupID, res.SessionID, s.clientBinding(c))
 	if err != nil {
 		slog.Error("failed to create session", "error", err)
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login?error="+url.QueryEscape("Internal error. Please try again."))
 	}
-	c.SetCookie(cookie)
+	s.setSessionCookie(c, cookie)
+	s.notifyNewDevice(c.Request().Context(), user.ID, resolvedHandle, s.clientBinding(c))
 
-	slog.Info("login successful", "did", did, "handle", resolvedHandle)
+	slog.Info("login successful", "did", did, "handle", resolvedHandle, "pds", res.PDSURL)
+	s.emitLogin(c, user.ID, did, resolvedHandle, res.PDSURL, false)
+	if err := s.db.UpdateIdentityPDS(c.Request().Context(), did, res.PDSURL); err != nil {
+		slog.Warn("failed to record PDS", "did", did, "error", err)
+	}
+	s.refreshProfileAsync(did)
 
 	// Redirect to the stored destination or portal.
 	dest := s.cfg.PublicURL + "/"
@@ -160,12 +217,43 @@ func (s *Server) handleOAuthCallback(c echo.Context) error {
 
 // handleClientMetadata serves the OAuth client metadata document.
 func (s *Server) handleClientMetadata(c echo.Context) error {
+	if s.oauth.IsLoopback() {
+		return c.NoContent(http.StatusNotFound)
+	}
 	return c.JSON(http.StatusOK, s.oauth.ClientMetadata())
 }
 
-// handleJWKS serves the public JSON Web Key Set.
+// handleJWKS serves the public JSON Web Key Set. Keys are read from the
+// database so a rotation is published before any instance restarts.
 func (s *Server) handleJWKS(c echo.Context) error {
-	return c.JSON(http.StatusOK, s.oauth.PublicJWKS())
+	rows, err := s.db.ListOAuthKeys(c.Request().Context())
+	if err != nil || len(rows) == 0 {
+		return c.JSON(http.StatusOK, s.oauth.PublicJWKS())
+	}
+	keys := make([]atproto.SigningKey, len(rows))
+	for i, k := range rows {
+		keys[i] = atproto.SigningKey{ID: k.KID, Public: k.PublicKey}
+	}
+	jwks, err := atproto.KeyJWKS(keys)
+	if err != nil {
+		slog.Error("failed to build JWKS", "error", err)
+		return c.JSON(http.StatusOK, s.oauth.PublicJWKS())
+	}
+
+	// Always publish the key this instance signs with, even if it has
+	// since been retired in the database.
+	active := s.oauth.KeyID()
+	for _, k := range jwks.Keys {
+		if k.KeyID != nil && *k.KeyID == active {
+			return c.JSON(http.StatusOK, jwks)
+		}
+	}
+	for _, k := range s.oauth.PublicJWKS().Keys {
+		if k.KeyID != nil && *k.KeyID == active {
+			jwks.Keys = append(jwks.Keys, k)
+		}
+	}
+	return c.JSON(http.StatusOK, jwks)
 }
 
 // isAllowedRedirect validates the redirect URL to prevent open redirect attacks.
@@ -195,11 +283,11 @@ func isAllowedRedirect(rawURL string, cfg *config.Config) bool {
 
 // hasValidSession returns true if the request has a valid session cookie.
 func (s *Server) hasValidSession(c echo.Context) bool {
-	cookie, err := c.Cookie(session.CookieName())
-	if err != nil || cookie.Value == "" {
+	token := s.sessionToken(c)
+	if token == "" {
 		return false
 	}
-	_, err = s.sess.Validate(c.Request().Context(), cookie.Value)
+	_, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 	return err == nil
 }
 
@@ -385,7 +473,7 @@ func loginHTML(redirect, errMsg string, hasSession bool, svcs []database.Service
   ` + errorBlock + `
   <form method="POST" action="/login">
     ` + redirectInput + `
-    <input type="text" id="handle" name="handle" placeholder="you.bsky.social" autocomplete="username" autofocus required>
+    <input type="text" id="handle" name="handle" placeholder="you.bsky.social, did:plc:…, or https://pds…" autocomplete="username" autofocus required>
     <button type="submit">Sign in with Bluesky</button>
   </form>
 </div>
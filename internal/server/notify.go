@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/notify"
+	"github.com/primal-host/noknok/internal/session"
+	"github.com/primal-host/noknok/internal/webhook"
+)
+
+// sessionUser returns the user behind the request's session, or nil.
+func (s *Server) sessionUser(c echo.Context) *database.User {
+	token := s.sessionToken(c)
+	if token == "" {
+		return nil
+	}
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
+	if err != nil {
+		return nil
+	}
+	user, err := s.db.GetUserByIdentityDID(c.Request().Context(), sess.DID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// handleListNotifications returns the caller's inbox and unread count.
+func (s *Server) handleListNotifications(c echo.Context) error {
+	user := s.sessionUser(c)
+	if user == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	ctx := c.Request().Context()
+	items, err := s.db.ListNotifications(ctx, user.ID, 50)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list notifications"})
+	}
+	if items == nil {
+		items = []database.Notification{}
+	}
+	unread, _ := s.db.CountUnreadNotifications(ctx, user.ID)
+	return c.JSON(http.StatusOK, map[string]any{"unread": unread, "items": items})
+}
+
+// handleReadNotifications marks the caller's notifications read: the ids in
+// the body, or all of them if none are given.
+func (s *Server) handleReadNotifications(c echo.Context) error {
+	user := s.sessionUser(c)
+	if user == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	_ = c.Bind(&req)
+	if req.IDs == nil {
+		req.IDs = []int64{}
+	}
+	if err := s.db.MarkNotificationsRead(c.Request().Context(), user.ID, req.IDs); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// notifyNewDevice records the client a user just signed in from and warns
+// them if it is one they have not used before. A user's first sign-in only
+// records the client.
+func (s *Server) notifyNewDevice(ctx context.Context, userID int64, handle string, client session.Binding) {
+	if client.UAFamily == "" {
+		return
+	}
+	isNew, hadDevices, err := s.db.RecordDevice(ctx, userID, client.UAFamily, client.Hints, client.IPPrefix)
+	if err != nil || !isNew || !hadDevices {
+		return
+	}
+	s.notifier.Notify(ctx, notify.Event{
+		Kind:  notify.KindNewDevice,
+		Title: "New sign-in from " + client.UAFamily,
+		Body: "@" + handle + " signed in from a browser not seen on this account before (" +
+			client.UAFamily + ", network " + client.IPPrefix + "). If this was not you, log out all sessions and contact an administrator.",
+		Detail: map[string]any{"handle": handle, "ua_family": client.UAFamily, "ip_prefix": client.IPPrefix},
+	}, userID)
+}
+
+// alertSessionRevoked tells a user and the owners that session binding
+// revoked the user's sessions because the client changed.
+func (s *Server) alertSessionRevoked(ctx context.Context, ev session.RevokeEvent) {
+	changed := strings.Join(ev.Changed, ", ")
+	s.notifier.Notify(ctx, notify.Event{
+		Kind:  notify.KindSessionRevoke,
+		Title: "Sessions revoked for @" + ev.Session.Handle,
+		Body: "A session of @" + ev.Session.Handle + " was used from a different client (" + changed + " changed, now " +
+			ev.To.UAFamily + ", network " + ev.To.IPPrefix + "), so all of its sessions were signed out. If this was not you, the session cookie may have been stolen.",
+		Detail: map[string]any{"handle": ev.Session.Handle, "session_id": ev.Session.ID, "changed": ev.Changed, "from": ev.From, "to": ev.To},
+	}, ev.Session.UserID)
+	s.notifier.NotifyOwners(ctx, notify.Event{
+		Kind:  notify.KindSessionRevoke,
+		Title: "Sessions revoked for @" + ev.Session.Handle,
+		Body: "Session binding signed out @" + ev.Session.Handle + " after a request from a different client (" + changed + " changed, now " +
+			ev.To.UAFamily + ", network " + ev.To.IPPrefix + ").",
+		Detail: map[string]any{"handle": ev.Session.Handle, "session_id": ev.Session.ID, "changed": ev.Changed, "from": ev.From, "to": ev.To},
+	}, ev.Session.UserID)
+	s.webhooks.Emit(ctx, webhook.EventSessionRevoked, map[string]any{
+		"user_id": ev.Session.UserID, "did": ev.Session.DID, "handle": ev.Session.Handle,
+		"session_id": ev.Session.ID, "changed": ev.Changed, "from": ev.From, "to": ev.To,
+	})
+}
+
+// notifyGrant tells a user they gained or lost access to a service.
+func (s *Server) notifyGrant(ctx context.Context, g *database.Grant, added bool, by *database.User) {
+	name := "service " + strconv.FormatInt(g.ServiceID, 10)
+	if svc, err := s.db.GetService(ctx, g.ServiceID); err == nil {
+		name = svc.Name
+	}
+	ev := notify.Event{
+		Kind:   notify.KindGrantAdded,
+		Title:  "Access granted to " + name,
+		Body:   "@" + by.Handle + " granted you " + g.Role + " access to " + name + ".",
+		Detail: map[string]any{"service_id": g.ServiceID, "service": name, "role": g.Role, "by": by.Handle},
+	}
+	if !added {
+		ev.Kind = notify.KindGrantRemoved
+		ev.Title = "Access removed from " + name
+		ev.Body = "@" + by.Handle + " removed your access to " + name + "."
+	}
+	s.notifier.Notify(ctx, ev, g.UserID)
+}
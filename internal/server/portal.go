@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"html"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -13,12 +14,12 @@ import (
 
 // handlePortal renders the service catalog page (requires valid session).
 func (s *Server) handlePortal(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
-	if err != nil || cookie.Value == "" {
+	token := s.sessionToken(c)
+	if token == "" {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
 
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 	if err != nil {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
@@ -54,6 +55,16 @@ func (s *Server) handlePortal(c echo.Context) error {
 		group = []session.Session{*sess}
 	}
 
+	// Cached Bluesky profiles for the dropdown.
+	dids := make([]string, 0, len(group))
+	for _, g := range group {
+		dids = append(dids, g.DID)
+	}
+	profiles, err := s.db.ListIdentitiesByDID(ctx, dids)
+	if err != nil {
+		slog.Warn("portal: failed to load profiles", "error", err)
+	}
+
 	// Use cached health data from background poller.
 	// Falls back to inline checks if cache is empty (first few seconds after startup).
 	healthMap := s.cachedHealth()
@@ -65,11 +76,16 @@ func (s *Server) handlePortal(c echo.Context) error {
 	_, adminOpen := c.QueryParams()["admin"]
 	adminOpen = adminOpen && isAdmin
 	adminTab := c.QueryParam("tab")
-	if adminTab == "" {
+	if adminTab == "" || (adminTab == "webhooks" && user.Role != "owner") {
 		adminTab = "users"
 	}
 
-	return c.HTML(http.StatusOK, portalHTML(sess, group, svcs, healthMap, isAdmin, user.Role, adminOpen, adminTab))
+	unread, err := s.db.CountUnreadNotifications(ctx, user.ID)
+	if err != nil {
+		slog.Warn("portal: failed to count notifications", "error", err)
+	}
+
+	return c.HTML(http.StatusOK, portalHTML(sess, group, profiles, svcs, healthMap, isAdmin, user.Role, adminOpen, adminTab, unread, s.ldap != nil))
 }
 
 func truncate(s string, max int) string {
@@ -81,12 +97,30 @@ func truncate(s string, max int) string {
 }
 
 type identityInfo struct {
-	ID     int64
-	Handle string
-	Active bool
+	ID          int64
+	Handle      string
+	DisplayName string
+	AvatarURL   string
+	Active      bool
+}
+
+// label returns the escaped display name and handle for dropdown items.
+func (id identityInfo) label() string {
+	if id.DisplayName == "" {
+		return id.Handle
+	}
+	return html.EscapeString(id.DisplayName) + ` <span class="dd-handle">` + id.Handle + `</span>`
 }
 
-func portalHTML(active *session.Session, group []session.Session, svcs []database.Service, healthMap map[int64]bool, isAdmin bool, role string, adminOpen bool, adminTab string) string {
+// avatar returns an <img> for the identity's avatar, or "" if none is cached.
+func (id identityInfo) avatar() string {
+	if id.AvatarURL == "" {
+		return ""
+	}
+	return `<img class="dd-avatar" src="` + html.EscapeString(id.AvatarURL) + `" alt="">`
+}
+
+func portalHTML(active *session.Session, group []session.Session, profiles map[string]database.Identity, svcs []database.Service, healthMap map[int64]bool, isAdmin bool, role string, adminOpen bool, adminTab string, unread int, appPasswords bool) string {
 	cards := ""
 	for _, svc := range svcs {
 		initial := "?"
@@ -125,21 +159,32 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
 
 	// Build identity list.
 	identities := make([]identityInfo, 0, len(group))
+	activeInfo := identityInfo{Handle: active.Handle}
 	for _, s := range group {
-		identities = append(identities, identityInfo{
-			ID:     s.ID,
-			Handle: s.Handle,
-			Active: s.Token == active.Token,
-		})
+		info := identityInfo{
+			ID:          s.ID,
+			Handle:      s.Handle,
+			DisplayName: profiles[s.DID].DisplayName,
+			AvatarURL:   profiles[s.DID].AvatarURL,
+			Active:      s.ID == active.ID,
+		}
+		if info.Active {
+			activeInfo = info
+		}
+		identities = append(identities, info)
+	}
+	triggerLabel := activeInfo.Handle
+	if activeInfo.DisplayName != "" {
+		triggerLabel = html.EscapeString(activeInfo.DisplayName)
 	}
 
 	// Identity dropdown items.
 	identityItems := ""
 	for _, id := range identities {
 		if id.Active {
-			identityItems += `<div class="dd-item dd-active">` + id.Handle + `</div>`
+			identityItems += `<div class="dd-item dd-active">` + id.avatar() + id.label() + `</div>`
 		} else {
-			identityItems += fmt.Sprintf(`<form method="POST" action="/switch" style="margin:0"><input type="hidden" name="id" value="%d"><button type="submit" class="dd-item dd-btn">%s</button></form>`, id.ID, id.Handle)
+			identityItems += fmt.Sprintf(`<form method="POST" action="/switch" style="margin:0"><input type="hidden" name="id" value="%d"><button type="submit" class="dd-item dd-btn">%s%s</button></form>`, id.ID, id.avatar(), id.label())
 		}
 	}
 
@@ -159,6 +204,32 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
       </div>`
 	}
 
+	// App passwords item and panel (only when the LDAP directory is enabled).
+	appPwItem := ""
+	appPwHTML := ""
+	if appPasswords {
+		appPwItem = `
+      <div class="dd-section">
+        <a href="#" class="dd-add" onclick="return toggleAppPasswords(event)">App passwords</a>
+      </div>`
+		appPwHTML = `
+<div class="apppw-panel" id="apppw-panel" style="display:none">
+  <h2>App passwords</h2>
+  <p class="apppw-help">Apps that can only use LDAP sign in with an app password instead of Bluesky. <span id="apppw-dn"></span></p>
+  <div class="apppw-new" id="apppw-new" style="display:none"></div>
+  <form class="apppw-form" onsubmit="return createAppPassword()">
+    <input type="text" id="apppw-name" placeholder="Name, e.g. Jellyfin" maxlength="100">
+    <button type="submit">Create</button>
+  </form>
+  <div id="apppw-list"></div>
+</div>`
+	}
+
+	badge := ""
+	if unread > 0 {
+		badge = fmt.Sprintf("%d", unread)
+	}
+
 	adminHTML := ""
 	if isAdmin {
 		adminHTML = adminPanelHTML(role, adminOpen, adminTab)
@@ -238,6 +309,14 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     color: #3b82f6;
     font-weight: 500;
   }
+  .dd-avatar {
+    width: 1.25rem;
+    height: 1.25rem;
+    border-radius: 50%;
+    vertical-align: middle;
+    margin-right: 0.375rem;
+  }
+  .dd-handle { color: #64748b; font-size: 0.75rem; }
   .dd-btn {
     background: none;
     border: none;
@@ -271,6 +350,71 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     transition: background 0.15s;
   }
   .dd-logout-all:hover { background: #7f1d1d; }
+  .inbox-trigger {
+    background: #334155;
+    color: #e2e8f0;
+    border: none;
+    padding: 0.375rem 0.625rem;
+    border-radius: 6px;
+    font-size: 0.8125rem;
+    cursor: pointer;
+    position: relative;
+  }
+  .inbox-trigger:hover { background: #475569; }
+  .inbox-badge {
+    position: absolute;
+    top: -0.375rem;
+    right: -0.375rem;
+    background: #ef4444;
+    color: #fff;
+    border-radius: 999px;
+    font-size: 0.625rem;
+    padding: 0.0625rem 0.3125rem;
+  }
+  .inbox-badge:empty { display: none; }
+  .inbox-menu { min-width: 320px; max-height: 420px; overflow-y: auto; }
+  .inbox-item { padding: 0.625rem 0.75rem; border-bottom: 1px solid #334155; font-size: 0.8125rem; }
+  .inbox-item:last-child { border-bottom: none; }
+  .inbox-item.unread { background: #172554; }
+  .inbox-title { color: #f8fafc; font-weight: 500; }
+  .inbox-body { color: #94a3b8; margin-top: 0.25rem; line-height: 1.4; }
+  .inbox-time { color: #64748b; font-size: 0.6875rem; margin-top: 0.25rem; }
+  .inbox-empty { padding: 1rem 0.75rem; color: #64748b; font-size: 0.8125rem; }
+  .apppw-panel {
+    max-width: 800px;
+    margin: 0 auto 2rem;
+    background: #1e293b;
+    border-radius: 12px;
+    padding: 1.25rem;
+    font-size: 0.875rem;
+  }
+  .apppw-panel h2 { font-size: 1rem; color: #f8fafc; margin-bottom: 0.5rem; }
+  .apppw-help { color: #94a3b8; margin-bottom: 0.75rem; line-height: 1.4; }
+  .apppw-help code, .apppw-new code { color: #e2e8f0; background: #0f172a; padding: 0.125rem 0.375rem; border-radius: 4px; }
+  .apppw-new { background: #14532d; border-radius: 8px; padding: 0.75rem; margin-bottom: 0.75rem; line-height: 1.6; }
+  .apppw-form { display: flex; gap: 0.5rem; margin-bottom: 0.75rem; }
+  .apppw-form input {
+    flex: 1;
+    background: #0f172a;
+    color: #e2e8f0;
+    border: 1px solid #334155;
+    border-radius: 6px;
+    padding: 0.375rem 0.625rem;
+    font-size: 0.8125rem;
+  }
+  .apppw-form button, .apppw-revoke {
+    background: #334155;
+    color: #e2e8f0;
+    border: none;
+    border-radius: 6px;
+    padding: 0.375rem 0.75rem;
+    font-size: 0.8125rem;
+    cursor: pointer;
+  }
+  .apppw-form button:hover { background: #475569; }
+  .apppw-revoke:hover { background: #7f1d1d; }
+  .apppw-row { display: flex; align-items: center; justify-content: space-between; padding: 0.5rem 0; border-top: 1px solid #334155; }
+  .apppw-meta { color: #64748b; font-size: 0.75rem; }
   .grid {
     display: grid;
     grid-template-columns: repeat(auto-fill, minmax(240px, 1fr));
@@ -387,8 +531,10 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
 <body>
 <div class="header">
   <div class="user">
+    <button class="inbox-trigger" onclick="toggleInbox(event)" title="Notifications">&#128276;<span class="inbox-badge" id="inbox-badge">` + badge + `</span></button>
+    <div class="dd-menu inbox-menu" id="inbox-menu"></div>
     <button class="dd-trigger" onclick="toggleDropdown(event)">
-      ` + active.Handle + ` <span class="dd-arrow">&#9660;</span>
+      ` + activeInfo.avatar() + triggerLabel + ` <span class="dd-arrow">&#9660;</span>
     </button>
     <div class="dd-menu" id="identity-menu">
       <div class="dd-section">
@@ -398,7 +544,7 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
       <div class="dd-section">
         <a href="/login" class="dd-add">+ New sign-in...</a>
       </div>
-      ` + adminItem + `
+      ` + appPwItem + adminItem + `
       <div class="dd-sep"></div>
       <div class="dd-section">
         ` + logoutItems + `
@@ -409,7 +555,7 @@ func portalHTML(active *session.Session, group []session.Session, svcs []databas
     </div>
   </div>
 </div>
-` + adminHTML + `
+` + appPwHTML + adminHTML + `
 <div class="grid">` + cards + `
 </div>
 <script>
@@ -442,14 +588,169 @@ function closeAllTracked() {
 }
 function toggleDropdown(e) {
   e.stopPropagation();
+  document.getElementById('inbox-menu').classList.remove('open');
   document.getElementById('identity-menu').classList.toggle('open');
 }
+function toggleInbox(e) {
+  e.stopPropagation();
+  document.getElementById('identity-menu').classList.remove('open');
+  var menu = document.getElementById('inbox-menu');
+  if (menu.classList.toggle('open')) loadInbox();
+}
+function loadInbox() {
+  var menu = document.getElementById('inbox-menu');
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', '/api/notifications', true);
+  xhr.onreadystatechange = function() {
+    if (xhr.readyState !== 4) return;
+    menu.innerHTML = '';
+    var data;
+    try { data = JSON.parse(xhr.responseText); } catch(e) { data = null; }
+    if (xhr.status !== 200 || !data) {
+      menu.innerHTML = '<div class="inbox-empty">Could not load notifications.</div>';
+      return;
+    }
+    if (data.items.length === 0) {
+      menu.innerHTML = '<div class="inbox-empty">No notifications.</div>';
+      return;
+    }
+    for (var i = 0; i < data.items.length; i++) {
+      var n = data.items[i];
+      var item = document.createElement('div');
+      item.className = 'inbox-item' + (n.read_at ? '' : ' unread');
+      var t = document.createElement('div');
+      t.className = 'inbox-title';
+      t.textContent = n.title;
+      var b = document.createElement('div');
+      b.className = 'inbox-body';
+      b.textContent = n.body;
+      var d = document.createElement('div');
+      d.className = 'inbox-time';
+      d.textContent = new Date(n.created_at).toLocaleString();
+      item.appendChild(t);
+      item.appendChild(b);
+      item.appendChild(d);
+      menu.appendChild(item);
+    }
+    if (data.unread > 0) {
+      var mark = new XMLHttpRequest();
+      mark.open('POST', '/api/notifications/read', true);
+      mark.setRequestHeader('Content-Type', 'application/json');
+      mark.send('{}');
+    }
+    document.getElementById('inbox-badge').textContent = '';
+  };
+  xhr.send();
+}
+function toggleAppPasswords(e) {
+  e.preventDefault();
+  document.getElementById('identity-menu').classList.remove('open');
+  var panel = document.getElementById('apppw-panel');
+  var show = panel.style.display === 'none';
+  panel.style.display = show ? '' : 'none';
+  if (show) loadAppPasswords();
+  return false;
+}
+function loadAppPasswords() {
+  var list = document.getElementById('apppw-list');
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', '/api/app-passwords', true);
+  xhr.onreadystatechange = function() {
+    if (xhr.readyState !== 4) return;
+    list.innerHTML = '';
+    var data;
+    try { data = JSON.parse(xhr.responseText); } catch(e) { data = null; }
+    if (xhr.status !== 200 || !data) {
+      list.innerHTML = '<div class="apppw-meta">Could not load app passwords.</div>';
+      return;
+    }
+    var dn = document.getElementById('apppw-dn');
+    dn.textContent = '';
+    if (data.bind_dn) {
+      dn.appendChild(document.createTextNode('Bind DN: '));
+      var code = document.createElement('code');
+      code.textContent = data.bind_dn;
+      dn.appendChild(code);
+    } else {
+      dn.textContent = 'Your account has no username yet; ask an administrator to set one.';
+    }
+    if (data.items.length === 0) {
+      list.innerHTML = '<div class="apppw-meta">No app passwords.</div>';
+      return;
+    }
+    for (var i = 0; i < data.items.length; i++) {
+      var p = data.items[i];
+      var row = document.createElement('div');
+      row.className = 'apppw-row';
+      var info = document.createElement('div');
+      var name = document.createElement('div');
+      name.textContent = p.name;
+      var meta = document.createElement('div');
+      meta.className = 'apppw-meta';
+      meta.textContent = 'Created ' + new Date(p.created_at).toLocaleString() +
+        (p.last_used_at ? ' \u00b7 last used ' + new Date(p.last_used_at).toLocaleString() : ' \u00b7 never used');
+      info.appendChild(name);
+      info.appendChild(meta);
+      var btn = document.createElement('button');
+      btn.className = 'apppw-revoke';
+      btn.textContent = 'Revoke';
+      btn.onclick = (function(id, label) {
+        return function() { revokeAppPassword(id, label); };
+      })(p.id, p.name);
+      row.appendChild(info);
+      row.appendChild(btn);
+      list.appendChild(row);
+    }
+  };
+  xhr.send();
+}
+function createAppPassword() {
+  var input = document.getElementById('apppw-name');
+  var box = document.getElementById('apppw-new');
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', '/api/app-passwords', true);
+  xhr.setRequestHeader('Content-Type', 'application/json');
+  xhr.onreadystatechange = function() {
+    if (xhr.readyState !== 4) return;
+    var data;
+    try { data = JSON.parse(xhr.responseText); } catch(e) { data = {}; }
+    if (xhr.status !== 201) {
+      alert(data.error || 'Failed to create app password');
+      return;
+    }
+    box.textContent = 'Password for \u201c' + data.app_password.name + '\u201d (shown only once): ';
+    var code = document.createElement('code');
+    code.textContent = data.password;
+    box.appendChild(code);
+    box.style.display = '';
+    input.value = '';
+    loadAppPasswords();
+  };
+  xhr.send(JSON.stringify({ name: input.value }));
+  return false;
+}
+function revokeAppPassword(id, name) {
+  if (!confirm('Revoke app password \u201c' + name + '\u201d? Apps using it will stop signing in.')) return;
+  var xhr = new XMLHttpRequest();
+  xhr.open('DELETE', '/api/app-passwords/' + id, true);
+  xhr.onreadystatechange = function() {
+    if (xhr.readyState !== 4) return;
+    document.getElementById('apppw-new').style.display = 'none';
+    loadAppPasswords();
+  };
+  xhr.send();
+}
 document.addEventListener('click', function(e) {
   var menu = document.getElementById('identity-menu');
   if (!menu.contains(e.target)) menu.classList.remove('open');
+  var inbox = document.getElementById('inbox-menu');
+  if (!inbox.contains(e.target)) inbox.classList.remove('open');
 });
 document.addEventListener('keydown', function(e) {
-  if (e.key === 'Escape') document.getElementById('identity-menu').classList.remove('open');
+  if (e.key === 'Escape') {
+    document.getElementById('identity-menu').classList.remove('open');
+    document.getElementById('inbox-menu').classList.remove('open');
+  }
 });
 // Duplicate-tab detection via BroadcastChannel.
 // The first portal tab claims "primary". Any subsequent portal tab
@@ -540,11 +841,11 @@ document.addEventListener('keydown', function(e) {
 
 // handleHealthStatus returns user-specific service status as three arrays.
 func (s *Server) handleHealthStatus(c echo.Context) error {
-	cookie, err := c.Cookie(session.CookieName())
-	if err != nil || cookie.Value == "" {
+	token := s.sessionToken(c)
+	if token == "" {
 		return c.NoContent(http.StatusUnauthorized)
 	}
-	sess, err := s.sess.Validate(c.Request().Context(), cookie.Value)
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 	if err != nil {
 		return c.NoContent(http.StatusUnauthorized)
 	}
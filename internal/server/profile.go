@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// refreshProfile fetches the Bluesky profile for a DID and caches it on the
+// identity and its active sessions.
+func (s *Server) refreshProfile(ctx context.Context, did string) {
+	p, err := s.profiles.Fetch(ctx, did)
+	if err != nil {
+		slog.Warn("profile fetch failed", "did", did, "error", err)
+		return
+	}
+	if err := s.db.UpdateIdentityProfile(ctx, did, p.DisplayName, p.AvatarURL, p.Description); err != nil {
+		slog.Warn("profile update failed", "did", did, "error", err)
+	}
+}
+
+// refreshProfileAsync refreshes a profile in the background, detached from
+// the request lifetime.
+func (s *Server) refreshProfileAsync(did string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		s.refreshProfile(ctx, did)
+	}()
+}
+
+// handleUserInfo returns OIDC-style claims for the current session.
+func (s *Server) handleUserInfo(c echo.Context) error {
+	token := s.sessionToken(c)
+	if token == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "not authenticated"})
+	}
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid session"})
+	}
+
+	claims := map[string]string{
+		"sub":                sess.DID,
+		"preferred_username": sess.Handle,
+	}
+	if sess.Username != "" {
+		claims["nickname"] = sess.Username
+	}
+	if sess.DisplayName != "" {
+		claims["name"] = sess.DisplayName
+	}
+	ids, err := s.db.ListIdentitiesByDID(c.Request().Context(), []string{sess.DID})
+	if err == nil {
+		if id, ok := ids[sess.DID]; ok && id.AvatarURL != "" {
+			claims["picture"] = id.AvatarURL
+		}
+	}
+	return c.JSON(http.StatusOK, claims)
+}
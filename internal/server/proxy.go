@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/primal-host/noknok/internal/session"
+)
+
+// startProxy runs the built-in authenticating reverse proxy on cfg.ProxyAddr.
+// Requests are routed by Host to the matching service's upstream_url after
+// the same checks as handleAuth; requests for the PublicURL host are served
+// by noknok itself, so one listener can front a whole small host.
+func (s *Server) startProxy() {
+	if s.cfg.ProxyAddr == "" {
+		return
+	}
+	s.proxy = &http.Server{
+		Addr:              s.cfg.ProxyAddr,
+		Handler:           http.HandlerFunc(s.serveProxy),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.proxyTransport = http.DefaultTransport.(*http.Transport).Clone()
+	go func() {
+		var err error
+		if s.cfg.ProxyTLSCert != "" {
+			slog.Info("proxy listening", "addr", s.cfg.ProxyAddr, "tls", true)
+			err = s.proxy.ListenAndServeTLS(s.cfg.ProxyTLSCert, s.cfg.ProxyTLSKey)
+		} else {
+			slog.Info("proxy listening", "addr", s.cfg.ProxyAddr)
+			err = s.proxy.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("proxy server stopped", "error", err)
+		}
+	}()
+}
+
+func (s *Server) serveProxy(w http.ResponseWriter, r *http.Request) {
+	// The relay sets the session cookie on hosts in other cookie domains.
+	pub, err := url.Parse(s.cfg.PublicURL)
+	if (err == nil && strings.EqualFold(r.Host, pub.Host)) || r.URL.Path == "/__noknok_set" {
+		s.echo.ServeHTTP(w, r)
+		return
+	}
+
+	if r.Host == "" {
+		http.Error(w, "missing Host header", http.StatusBadRequest)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	req := authRequest{
+		Host:             r.Host,
+		Scheme:           scheme,
+		URI:              r.URL.RequestURI(),
+		Accept:           r.Header.Get("Accept"),
+		HasAuthorization: r.Header.Get("Authorization") != "",
+		Token:            s.sess.Token(r),
+	}
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	req.Client = session.NewBinding(ip, r.Header)
+
+	d := s.decideAuth(r.Context(), req)
+	switch d.Outcome {
+	case authAllow:
+	case authLogin, authPortal:
+		http.Redirect(w, r, d.Redirect, http.StatusFound)
+		return
+	case authUnavailable:
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	case authForbidden:
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	case authBadRequest:
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Route with the row the decision was made for, never a second lookup.
+	svc := d.Service
+	if svc == nil || svc.UpstreamURL == "" {
+		http.Error(w, "no upstream configured for "+r.Host, http.StatusBadGateway)
+		return
+	}
+	target, err := url.Parse(svc.UpstreamURL)
+	if err != nil {
+		slog.Error("proxy: bad upstream_url", "service", svc.Slug, "error", err)
+		http.Error(w, "bad upstream", http.StatusBadGateway)
+		return
+	}
+
+	// ReverseProxy handles Upgrade (WebSocket) requests itself; a negative
+	// FlushInterval streams responses (SSE, chunked downloads) as they arrive.
+	rp := &httputil.ReverseProxy{
+		Transport:     s.proxyTransport,
+		FlushInterval: -1,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+			for _, name := range identityHeaders {
+				pr.Out.Header.Del(name)
+			}
+			for k, v := range d.Headers {
+				pr.Out.Header[k] = v
+			}
+			s.stripSessionCookies(pr.Out.Header)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if !errors.Is(err, context.Canceled) {
+				slog.Warn("proxy: upstream error", "service", svc.Slug, "error", err)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	rp.ServeHTTP(w, r)
+}
+
+// stripSessionCookies removes the noknok session cookies so upstreams never
+// see a token they could replay.
+func (s *Server) stripSessionCookies(h http.Header) {
+	lines := h.Values("Cookie")
+	if len(lines) == 0 {
+		return
+	}
+	var kept []string
+	for _, line := range lines {
+		for _, part := range strings.Split(line, ";") {
+			part = strings.TrimSpace(part)
+			name, _, _ := strings.Cut(part, "=")
+			if part == "" || s.sess.IsSessionCookie(name) {
+				continue
+			}
+			kept = append(kept, part)
+		}
+	}
+	h.Del("Cookie")
+	if len(kept) > 0 {
+		h.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// validUpstreamURL reports whether raw is empty or an absolute http(s) URL.
+func validUpstreamURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -12,6 +15,9 @@ import (
 // happens) to an external domain (e.g. ker.ai).
 //
 // GET /__noknok_set?t=SESSION_TOKEN&r=/path
+//
+// r may also be an absolute URL on a configured cookie domain, so that hops
+// can be chained across domains (see relayChain).
 func (s *Server) handleRelay(c echo.Context) error {
 	token := c.QueryParam("t")
 	redirect := c.QueryParam("r")
@@ -21,7 +27,7 @@ func (s *Server) handleRelay(c echo.Context) error {
 	}
 
 	// Validate the session token.
-	sess, err := s.sess.Validate(c.Request().Context(), token)
+	sess, err := s.sess.Validate(c.Request().Context(), token, s.clientBinding(c))
 	if err != nil {
 		return c.Redirect(http.StatusFound, s.cfg.PublicURL+"/login")
 	}
@@ -33,10 +39,46 @@ func (s *Server) handleRelay(c echo.Context) error {
 	// Set the session cookie for this domain.
 	c.SetCookie(s.sess.MakeCookieForDomain(token, sess.ExpiresAt, domain))
 
-	// Redirect must be a relative path to prevent open redirect.
-	if redirect == "" || !strings.HasPrefix(redirect, "/") {
+	// Redirect must be a relative path or one of our cookie domains to
+	// prevent open redirect.
+	if isAllowedRedirect(redirect, s.cfg) {
+		return c.Redirect(http.StatusFound, redirect)
+	}
+	if redirect == "" || !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
 		redirect = "/"
 	}
 
 	return c.Redirect(http.StatusFound, redirect)
 }
+
+// relayChain returns a URL that sets token on every other cookie domain and
+// then lands on dest. Switching identity rotates the target session's token,
+// which would otherwise leave the copies relayed to other domains invalid.
+// Each domain is reached through the host of one of its services, the same
+// hosts the login relay uses; domains without services are skipped.
+func (s *Server) relayChain(ctx context.Context, token, dest string) string {
+	svcs, err := s.db.ListServices(ctx)
+	if err != nil {
+		slog.Warn("relay: failed to list services", "error", err)
+		return dest
+	}
+	hosts := map[string]*url.URL{}
+	var order []string
+	for _, svc := range svcs {
+		u, err := url.Parse(svc.URL)
+		if err != nil || u.Host == "" || !s.cfg.IsExternalHost(u.Host) {
+			continue
+		}
+		d := s.cfg.DomainForHost(u.Host)
+		if _, ok := hosts[d]; !ok {
+			hosts[d] = u
+			order = append(order, d)
+		}
+	}
+	next := dest
+	for i := len(order) - 1; i >= 0; i-- {
+		u := hosts[order[i]]
+		next = u.Scheme + "://" + u.Host + "/__noknok_set?t=" + url.QueryEscape(token) + "&r=" + url.QueryEscape(next)
+	}
+	return next
+}
@@ -2,7 +2,11 @@ package server
 
 func (s *Server) registerRoutes() {
 	s.echo.GET("/health", s.handleHealth)
-	s.echo.GET("/auth", s.handleAuth)
+	s.echo.GET("/auth", s.handleAuth, s.requireTrustedTraefik)
+	s.echo.Any("/auth/nginx", s.handleAuthNginx, s.requireTrustedProxy) // auth_request keeps the original method
+	s.echo.GET("/auth/caddy", s.handleAuthCaddy, s.requireTrustedProxy)
+	s.echo.Any("/auth/envoy", s.handleAuthEnvoy, s.requireTrustedProxy)
+	s.echo.Any("/auth/envoy/*", s.handleAuthEnvoy, s.requireTrustedProxy)
 	s.echo.GET("/login", s.handleLoginPage)
 	s.echo.POST("/login", s.handleLogin)
 	s.echo.POST("/logout", s.handleLogout)
@@ -10,7 +14,14 @@ func (s *Server) registerRoutes() {
 	s.echo.POST("/logout/one", s.handleLogoutOne)
 	s.echo.GET("/api/identities", s.handleListIdentities)
 	s.echo.GET("/api/health", s.handleHealthStatus)
+	s.echo.GET("/api/userinfo", s.handleUserInfo)
+	s.echo.GET("/api/notifications", s.handleListNotifications)
+	s.echo.POST("/api/notifications/read", s.handleReadNotifications)
+	s.echo.GET("/api/app-passwords", s.handleListAppPasswords)
+	s.echo.POST("/api/app-passwords", s.handleCreateAppPassword)
+	s.echo.DELETE("/api/app-passwords/:id", s.handleDeleteAppPassword)
 	s.echo.GET("/__noknok_set", s.handleRelay)
+	s.echo.GET("/traefik/config", s.handleTraefikConfig)
 	s.echo.GET("/", s.handlePortal)
 
 	// OAuth endpoints.
@@ -32,10 +43,43 @@ func (s *Server) registerRoutes() {
 	admin.PUT("/services/:id/public", s.handleToggleServicePublic)
 	admin.DELETE("/services/:id", s.handleDeleteService)
 	admin.GET("/services/health", s.handleServiceHealth)
+	admin.GET("/traefik/audit", s.handleTraefikAudit)
 	admin.GET("/grants", s.handleListGrants)
 	admin.POST("/grants", s.handleCreateGrant)
 	admin.DELETE("/grants/:id", s.handleDeleteGrant)
 	admin.GET("/users/:id/identities", s.handleListUserIdentities)
 	admin.POST("/users/:id/identities", s.handleAddIdentity)
 	admin.DELETE("/users/:id/identities/:identityId", s.handleRemoveIdentity)
+	admin.GET("/users/:id/oauth-sessions", s.handleListUserOAuthSessions)
+	admin.GET("/audit-log", s.handleListAuditLog)
+
+	// Webhooks send event data off-box, so only owners manage them.
+	hooks := admin.Group("/webhooks", requireOwner)
+	hooks.GET("", s.handleListWebhooks)
+	hooks.POST("", s.handleCreateWebhook)
+	hooks.PUT("/:id", s.handleUpdateWebhook)
+	hooks.DELETE("/:id", s.handleDeleteWebhook)
+	hooks.GET("/:id/deliveries", s.handleListWebhookDeliveries)
+	hooks.POST("/:id/test", s.handleTestWebhook)
+	hooks.POST("/:id/deliveries/:deliveryId/retry", s.handleRetryWebhookDelivery)
+
+	// SCIM 2.0 provisioning (bearer token, disabled unless SCIM_TOKEN is set).
+	scimAPI := s.echo.Group("/scim/v2", s.requireSCIM)
+	scimAPI.GET("/ServiceProviderConfig", s.handleSCIMServiceProviderConfig)
+	scimAPI.GET("/ResourceTypes", s.handleSCIMResourceTypes)
+	scimAPI.GET("/ResourceTypes/:id", s.handleSCIMResourceTypes)
+	scimAPI.GET("/Schemas", s.handleSCIMSchemas)
+	scimAPI.GET("/Schemas/:id", s.handleSCIMSchemas)
+	scimAPI.GET("/Users", s.handleSCIMListUsers)
+	scimAPI.POST("/Users", s.handleSCIMCreateUser)
+	scimAPI.GET("/Users/:id", s.handleSCIMGetUser)
+	scimAPI.PUT("/Users/:id", s.handleSCIMReplaceUser)
+	scimAPI.PATCH("/Users/:id", s.handleSCIMPatchUser)
+	scimAPI.DELETE("/Users/:id", s.handleSCIMDeleteUser)
+	scimAPI.GET("/Groups", s.handleSCIMListGroups)
+	scimAPI.POST("/Groups", s.handleSCIMGroupUnsupported)
+	scimAPI.GET("/Groups/:id", s.handleSCIMGetGroup)
+	scimAPI.PUT("/Groups/:id", s.handleSCIMReplaceGroup)
+	scimAPI.PATCH("/Groups/:id", s.handleSCIMPatchGroup)
+	scimAPI.DELETE("/Groups/:id", s.handleSCIMGroupUnsupported)
 }
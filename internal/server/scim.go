@@ -0,0 +1,941 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/scim"
+	"github.com/primal-host/noknok/internal/webhook"
+)
+
+const (
+	scimDefaultCount = 100
+	scimMaxResults   = 500
+)
+
+// requireSCIM authenticates SCIM requests with the SCIM_TOKEN bearer token.
+// Changes are made as the SCIM_ACTOR_DID user, so that user's role limits
+// what the token can do exactly as it would in the admin panel.
+func (s *Server) requireSCIM(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.cfg.SCIMToken == "" {
+			return echo.ErrNotFound
+		}
+		got, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.SCIMToken)) != 1 {
+			c.Response().Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			return scimError(c, scim.NewError(http.StatusUnauthorized, "", "invalid bearer token"))
+		}
+		actor, err := s.db.GetUserByIdentityDID(c.Request().Context(), s.cfg.SCIMActorDID)
+		if err != nil {
+			slog.Warn("scim: actor not found", "did", s.cfg.SCIMActorDID, "error", err)
+			return scimError(c, scim.NewError(http.StatusForbidden, "", "SCIM actor is not a noknok user"))
+		}
+		if actor.Role != "owner" && actor.Role != "admin" {
+			return scimError(c, scim.NewError(http.StatusForbidden, "", "SCIM actor must be an owner or admin"))
+		}
+		c.Set(ctxKeyUser, actor)
+		return next(c)
+	}
+}
+
+func scimJSON(c echo.Context, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, scim.ContentType, b)
+}
+
+func scimError(c echo.Context, e *scim.Error) error {
+	status, _ := strconv.Atoi(e.Status)
+	return scimJSON(c, status, e)
+}
+
+// scimUserError converts a userError from the shared user helpers.
+func scimUserError(err error) *scim.Error {
+	var ue *userError
+	if !errors.As(err, &ue) {
+		return scim.NewError(http.StatusInternalServerError, "", "internal error")
+	}
+	scimType := ""
+	switch ue.status {
+	case http.StatusConflict:
+		scimType = scim.ErrUniqueness
+	case http.StatusBadRequest:
+		scimType = scim.ErrInvalidValue
+	}
+	return scim.NewError(ue.status, scimType, ue.msg)
+}
+
+// scimBind decodes a request body. SCIM clients send application/scim+json,
+// which Echo's binder does not accept.
+func scimBind(c echo.Context, v any) *scim.Error {
+	if err := json.NewDecoder(io.LimitReader(c.Request().Body, 1<<20)).Decode(v); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "invalid JSON body: "+err.Error())
+	}
+	return nil
+}
+
+func (s *Server) scimLocation(kind, id string) string {
+	return strings.TrimRight(s.cfg.PublicURL, "/") + "/scim/v2/" + kind + "/" + id
+}
+
+func scimTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func scimNotFound(c echo.Context, kind string) error {
+	return scimError(c, scim.NewError(http.StatusNotFound, "", kind+" not found"))
+}
+
+// --- Directory ---
+
+// scimDirectory is a snapshot of users, identities, services and grants
+// from which SCIM resources are rendered.
+type scimDirectory struct {
+	users      []database.User
+	identities map[int64][]database.Identity
+	services   []database.Service
+	grants     []database.Grant
+}
+
+func (s *Server) loadSCIMDirectory(ctx context.Context) (*scimDirectory, error) {
+	d := &scimDirectory{identities: map[int64][]database.Identity{}}
+	var err error
+	if d.users, err = s.db.ListUsers(ctx); err != nil {
+		return nil, err
+	}
+	ids, err := s.db.ListAllIdentities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		d.identities[id.UserID] = append(d.identities[id.UserID], id)
+	}
+	if d.services, err = s.db.ListServices(ctx); err != nil {
+		return nil, err
+	}
+	if d.grants, err = s.db.ListGrants(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *scimDirectory) user(id string) *database.User {
+	for i := range d.users {
+		if strconv.FormatInt(d.users[i].ID, 10) == id {
+			return &d.users[i]
+		}
+	}
+	return nil
+}
+
+func (d *scimDirectory) service(id string) *database.Service {
+	for i := range d.services {
+		if strconv.FormatInt(d.services[i].ID, 10) == id {
+			return &d.services[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) scimUser(d *scimDirectory, u *database.User) scim.User {
+	id := strconv.FormatInt(u.ID, 10)
+	active := true
+	out := scim.User{
+		Schemas:     []string{scim.SchemaUser, scim.SchemaUserExtension},
+		ID:          id,
+		ExternalID:  u.DID,
+		UserName:    u.Handle,
+		DisplayName: u.DisplayName,
+		Active:      &active,
+		Roles:       []scim.MultiValue{{Value: u.Role, Primary: true}},
+		Extension:   &scim.UserExtension{Identities: []scim.Identity{}},
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      scimTime(u.CreatedAt),
+			LastModified: scimTime(u.UpdatedAt),
+			Location:     s.scimLocation("Users", id),
+		},
+	}
+	if u.Username != "" {
+		nick := u.Username
+		out.NickName = &nick
+	}
+	if u.AvatarURL != "" {
+		out.Photos = []scim.MultiValue{{Value: u.AvatarURL, Type: "photo", Primary: true}}
+	}
+	for _, ident := range d.identities[u.ID] {
+		out.Extension.Identities = append(out.Extension.Identities, scim.Identity{DID: ident.DID, Handle: ident.Handle, Primary: ident.IsPrimary})
+	}
+	for _, g := range d.grants {
+		if g.UserID == u.ID {
+			sid := strconv.FormatInt(g.ServiceID, 10)
+			out.Groups = append(out.Groups, scim.MultiValue{Value: sid, Display: g.ServiceName, Ref: s.scimLocation("Groups", sid)})
+		}
+	}
+	return out
+}
+
+func (s *Server) scimGroup(d *scimDirectory, svc *database.Service, members bool) scim.Group {
+	id := strconv.FormatInt(svc.ID, 10)
+	out := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          id,
+		ExternalID:  svc.Slug,
+		DisplayName: svc.Name,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      scimTime(svc.CreatedAt),
+			Location:     s.scimLocation("Groups", id),
+		},
+	}
+	if members {
+		out.Members = []scim.MultiValue{}
+		for _, g := range d.grants {
+			if g.ServiceID == svc.ID {
+				uid := strconv.FormatInt(g.UserID, 10)
+				out.Members = append(out.Members, scim.MultiValue{Value: uid, Display: g.UserHandle, Ref: s.scimLocation("Users", uid)})
+			}
+		}
+	}
+	return out
+}
+
+func scimAttrs(pairs ...string) scim.Attrs {
+	a := scim.Attrs{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			a[pairs[i]] = append(a[pairs[i]], pairs[i+1])
+		}
+	}
+	return a
+}
+
+func addMultiValues(a scim.Attrs, name string, vs []scim.MultiValue) {
+	for _, v := range vs {
+		a[name] = append(a[name], v.Value)
+		a[name+".value"] = append(a[name+".value"], v.Value)
+		if v.Display != "" {
+			a[name+".display"] = append(a[name+".display"], v.Display)
+		}
+	}
+}
+
+func userAttrs(u scim.User) scim.Attrs {
+	a := scimAttrs(
+		"id", u.ID, "externalid", u.ExternalID, "username", u.UserName, "displayname", u.DisplayName,
+		"active", "true", "meta.resourcetype", "User",
+		"meta.created", u.Meta.Created, "meta.lastmodified", u.Meta.LastModified,
+	)
+	if u.NickName != nil {
+		a["nickname"] = []string{*u.NickName}
+	}
+	addMultiValues(a, "roles", u.Roles)
+	addMultiValues(a, "groups", u.Groups)
+	for _, ident := range u.Extension.Identities {
+		a["identities.did"] = append(a["identities.did"], ident.DID)
+		a["identities.handle"] = append(a["identities.handle"], ident.Handle)
+	}
+	return a
+}
+
+func groupAttrs(g scim.Group) scim.Attrs {
+	a := scimAttrs(
+		"id", g.ID, "externalid", g.ExternalID, "displayname", g.DisplayName,
+		"meta.resourcetype", "Group", "meta.created", g.Meta.Created,
+	)
+	addMultiValues(a, "members", g.Members)
+	return a
+}
+
+// scimPage applies the filter, startIndex and count query parameters.
+func scimPage[T any](c echo.Context, items []T, attrs func(T) scim.Attrs) (*scim.ListResponse, *scim.Error) {
+	if f := c.QueryParam("filter"); f != "" {
+		filter, err := scim.ParseFilter(f)
+		if err != nil {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, err.Error())
+		}
+		var matched []T
+		for _, it := range items {
+			if filter.Match(attrs(it)) {
+				matched = append(matched, it)
+			}
+		}
+		items = matched
+	}
+
+	start := 1
+	if v, err := strconv.Atoi(c.QueryParam("startIndex")); err == nil && v > 1 {
+		start = v
+	}
+	count := scimDefaultCount
+	if v, err := strconv.Atoi(c.QueryParam("count")); err == nil {
+		count = max(0, min(v, scimMaxResults))
+	}
+
+	page := []any{}
+	for i := start - 1; i < len(items) && len(page) < count; i++ {
+		page = append(page, items[i])
+	}
+	return &scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: len(items),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}, nil
+}
+
+// --- Users ---
+
+func (s *Server) handleSCIMListUsers(c echo.Context) error {
+	d, err := s.loadSCIMDirectory(c.Request().Context())
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to list users"))
+	}
+	users := make([]scim.User, 0, len(d.users))
+	for i := range d.users {
+		users = append(users, s.scimUser(d, &d.users[i]))
+	}
+	resp, serr := scimPage(c, users, userAttrs)
+	if serr != nil {
+		return scimError(c, serr)
+	}
+	return scimJSON(c, http.StatusOK, resp)
+}
+
+func (s *Server) handleSCIMGetUser(c echo.Context) error {
+	return s.respondSCIMUser(c, http.StatusOK, c.Param("id"))
+}
+
+// respondSCIMUser renders the user with the given id.
+func (s *Server) respondSCIMUser(c echo.Context, status int, id string) error {
+	d, err := s.loadSCIMDirectory(c.Request().Context())
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to load user"))
+	}
+	u := d.user(id)
+	if u == nil {
+		return scimNotFound(c, "user")
+	}
+	out := s.scimUser(d, u)
+	if status == http.StatusCreated {
+		c.Response().Header().Set("Location", out.Meta.Location)
+	}
+	return scimJSON(c, status, out)
+}
+
+func (s *Server) handleSCIMCreateUser(c echo.Context) error {
+	caller := adminUser(c)
+
+	var req scim.User
+	if serr := scimBind(c, &req); serr != nil {
+		return scimError(c, serr)
+	}
+	if req.UserName == "" {
+		return scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "userName (the Bluesky handle) is required"))
+	}
+	if req.Active != nil && !*req.Active {
+		return scimError(c, scimDeactivateError())
+	}
+	nick := ""
+	if req.NickName != nil {
+		nick = *req.NickName
+	}
+
+	user, err := s.createUser(c.Request().Context(), caller, req.UserName, req.ExternalID, scim.PrimaryValue(req.Roles), nick)
+	if err != nil {
+		return scimError(c, scimUserError(err))
+	}
+	return s.respondSCIMUser(c, http.StatusCreated, strconv.FormatInt(user.ID, 10))
+}
+
+// handleSCIMReplaceUser applies a PUT. nickName is replaced (absent clears
+// it) but roles are only changed when sent, so an identity provider that
+// does not manage roles never demotes an admin.
+func (s *Server) handleSCIMReplaceUser(c echo.Context) error {
+	caller := adminUser(c)
+	ctx := c.Request().Context()
+
+	d, err := s.loadSCIMDirectory(ctx)
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to load user"))
+	}
+	u := d.user(c.Param("id"))
+	if u == nil {
+		return scimNotFound(c, "user")
+	}
+	cur := s.scimUser(d, u)
+
+	var req scim.User
+	if serr := scimBind(c, &req); serr != nil {
+		return scimError(c, serr)
+	}
+	p := userPatch{cur: cur, nick: u.Username, role: u.Role}
+	if serr := p.checkImmutable(req.UserName, req.ExternalID, req.Active); serr != nil {
+		return scimError(c, serr)
+	}
+	p.nick = ""
+	if req.NickName != nil {
+		p.nick = *req.NickName
+	}
+	if len(req.Roles) > 0 {
+		p.role = scim.PrimaryValue(req.Roles)
+	}
+
+	if err := s.applyUserPatch(ctx, caller, u, p); err != nil {
+		return scimError(c, scimUserError(err))
+	}
+	return s.respondSCIMUser(c, http.StatusOK, c.Param("id"))
+}
+
+func (s *Server) handleSCIMPatchUser(c echo.Context) error {
+	caller := adminUser(c)
+	ctx := c.Request().Context()
+
+	d, err := s.loadSCIMDirectory(ctx)
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to load user"))
+	}
+	u := d.user(c.Param("id"))
+	if u == nil {
+		return scimNotFound(c, "user")
+	}
+
+	var req scim.PatchRequest
+	if serr := scimBind(c, &req); serr != nil {
+		return scimError(c, serr)
+	}
+	p := userPatch{cur: s.scimUser(d, u), nick: u.Username, role: u.Role}
+	for _, op := range req.Operations {
+		if serr := p.apply(op); serr != nil {
+			return scimError(c, serr)
+		}
+	}
+
+	if err := s.applyUserPatch(ctx, caller, u, p); err != nil {
+		return scimError(c, scimUserError(err))
+	}
+	return s.respondSCIMUser(c, http.StatusOK, c.Param("id"))
+}
+
+func (s *Server) handleSCIMDeleteUser(c echo.Context) error {
+	caller := adminUser(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return scimNotFound(c, "user")
+	}
+	if _, err := s.db.GetUser(c.Request().Context(), id); err != nil {
+		return scimNotFound(c, "user")
+	}
+	if err := s.deleteUser(c.Request().Context(), caller, id); err != nil {
+		return scimError(c, scimUserError(err))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// applyUserPatch saves the nickName and role a PUT or PATCH ended with.
+// Both are checked before either is written, and they are written together.
+func (s *Server) applyUserPatch(ctx context.Context, caller *database.User, u *database.User, p userPatch) error {
+	if p.nick == u.Username && p.role == u.Role {
+		return nil
+	}
+	if err := checkUsername(p.nick); err != nil {
+		return err
+	}
+	var target database.User
+	if p.role != u.Role {
+		t, err := s.checkUserRole(ctx, caller, u.ID, p.role)
+		if err != nil {
+			return err
+		}
+		target = t
+	}
+	if err := s.db.UpdateUserProfile(ctx, u.ID, p.nick, p.role); err != nil {
+		return &userError{http.StatusInternalServerError, "failed to update user"}
+	}
+	if p.nick != u.Username {
+		s.usernameChanged(ctx, caller, u.ID, p.nick)
+	}
+	if p.role != u.Role {
+		s.userRoleChanged(ctx, caller, u.ID, target, p.role)
+	}
+	return nil
+}
+
+func scimDeactivateError() *scim.Error {
+	return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "noknok users cannot be deactivated; delete the user to revoke access")
+}
+
+// userPatch accumulates the writable user attributes while PATCH operations
+// are applied; nothing is saved until every operation has been accepted.
+type userPatch struct {
+	cur  scim.User
+	nick string
+	role string
+}
+
+// checkImmutable rejects changes to attributes noknok derives from the
+// user's identity.
+func (p *userPatch) checkImmutable(userName, externalID string, active *bool) *scim.Error {
+	if userName != "" && !strings.EqualFold(userName, p.cur.UserName) {
+		return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "userName is the primary identity's handle and cannot be changed")
+	}
+	if externalID != "" && externalID != p.cur.ExternalID {
+		return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "externalId is the primary identity's DID and cannot be changed")
+	}
+	if active != nil && !*active {
+		return scimDeactivateError()
+	}
+	return nil
+}
+
+func (p *userPatch) apply(op scim.PatchOperation) *scim.Error {
+	kind := op.NormalizedOp()
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "unknown op "+op.Op)
+	}
+	if op.Path == "" {
+		if kind == "remove" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrNoTarget, "remove requires a path")
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &obj); err != nil {
+			return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "value must be an object when path is omitted")
+		}
+		for k, v := range obj {
+			path, err := scim.ParsePath(k)
+			if err != nil {
+				return scim.NewError(http.StatusBadRequest, scim.ErrInvalidPath, err.Error())
+			}
+			if serr := p.set(kind, path, v); serr != nil {
+				return serr
+			}
+		}
+		return nil
+	}
+	path, err := scim.ParsePath(op.Path)
+	if err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidPath, err.Error())
+	}
+	return p.set(kind, path, op.Value)
+}
+
+// set applies one operation to one attribute. Attributes noknok does not
+// store (name, emails, ...) are ignored so identity providers that send
+// their full profile keep working.
+func (p *userPatch) set(kind string, path scim.Path, value json.RawMessage) *scim.Error {
+	invalid := func(what string) *scim.Error {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, what)
+	}
+	switch path.Attr {
+	case "nickname":
+		if kind == "remove" {
+			p.nick = ""
+			return nil
+		}
+		if err := json.Unmarshal(value, &p.nick); err != nil {
+			return invalid("nickName must be a string")
+		}
+	case "roles":
+		if kind == "remove" {
+			if path.Filter == nil || path.Filter.Match(scim.Attrs{"value": {p.role}}) {
+				p.role = "user"
+			}
+			return nil
+		}
+		vs, ok := scim.ParseValues(value)
+		if !ok || scim.PrimaryValue(vs) == "" {
+			return invalid("roles must be a list of {\"value\": role}")
+		}
+		p.role = scim.PrimaryValue(vs)
+	case "active":
+		if kind == "remove" {
+			return nil
+		}
+		active, ok := scim.ParseBool(value)
+		if !ok {
+			return invalid("active must be a boolean")
+		}
+		return p.checkImmutable("", "", &active)
+	case "username", "externalid":
+		if kind == "remove" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrMutability, path.Attr+" cannot be removed")
+		}
+		var v string
+		if err := json.Unmarshal(value, &v); err != nil {
+			return invalid(path.Attr + " must be a string")
+		}
+		if path.Attr == "username" {
+			return p.checkImmutable(v, "", nil)
+		}
+		return p.checkImmutable("", v, nil)
+	}
+	return nil
+}
+
+// --- Groups ---
+
+func (s *Server) handleSCIMListGroups(c echo.Context) error {
+	d, err := s.loadSCIMDirectory(c.Request().Context())
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to list groups"))
+	}
+	members := !scimExcludes(c, "members")
+	groups := make([]scim.Group, 0, len(d.services))
+	for i := range d.services {
+		groups = append(groups, s.scimGroup(d, &d.services[i], members))
+	}
+	resp, serr := scimPage(c, groups, groupAttrs)
+	if serr != nil {
+		return scimError(c, serr)
+	}
+	return scimJSON(c, http.StatusOK, resp)
+}
+
+func (s *Server) handleSCIMGetGroup(c echo.Context) error {
+	return s.respondSCIMGroup(c, c.Param("id"), !scimExcludes(c, "members"))
+}
+
+func (s *Server) respondSCIMGroup(c echo.Context, id string, members bool) error {
+	d, err := s.loadSCIMDirectory(c.Request().Context())
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to load group"))
+	}
+	svc := d.service(id)
+	if svc == nil {
+		return scimNotFound(c, "group")
+	}
+	return scimJSON(c, http.StatusOK, s.scimGroup(d, svc, members))
+}
+
+// scimExcludes reports whether attr is listed in excludedAttributes.
+func scimExcludes(c echo.Context, attr string) bool {
+	for _, a := range strings.Split(c.QueryParam("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(a), attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSCIMGroupUnsupported answers POST and DELETE on Groups. Groups are
+// noknok services, which need a URL and are managed in the admin panel.
+func (s *Server) handleSCIMGroupUnsupported(c echo.Context) error {
+	return scimError(c, scim.NewError(http.StatusNotImplemented, "",
+		"groups are noknok services; create and delete them in the admin panel"))
+}
+
+func (s *Server) handleSCIMReplaceGroup(c echo.Context) error {
+	d, err := s.loadSCIMDirectory(c.Request().Context())
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to load group"))
+	}
+	svc := d.service(c.Param("id"))
+	if svc == nil {
+		return scimNotFound(c, "group")
+	}
+
+	var req scim.Group
+	if serr := scimBind(c, &req); serr != nil {
+		return scimError(c, serr)
+	}
+	p := newGroupPatch(d, svc)
+	if req.DisplayName != "" {
+		p.name = req.DisplayName
+	}
+	p.members = map[string]bool{}
+	for _, m := range req.Members {
+		p.members[m.Value] = true
+	}
+	return s.finishGroupPatch(c, d, svc, p)
+}
+
+func (s *Server) handleSCIMPatchGroup(c echo.Context) error {
+	d, err := s.loadSCIMDirectory(c.Request().Context())
+	if err != nil {
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to load group"))
+	}
+	svc := d.service(c.Param("id"))
+	if svc == nil {
+		return scimNotFound(c, "group")
+	}
+
+	var req scim.PatchRequest
+	if serr := scimBind(c, &req); serr != nil {
+		return scimError(c, serr)
+	}
+	p := newGroupPatch(d, svc)
+	for _, op := range req.Operations {
+		if serr := p.apply(op); serr != nil {
+			return scimError(c, serr)
+		}
+	}
+	return s.finishGroupPatch(c, d, svc, p)
+}
+
+// finishGroupPatch saves a group PUT or PATCH: renames the service and adds
+// or removes grants to match the new member list, all in one transaction.
+func (s *Server) finishGroupPatch(c echo.Context, d *scimDirectory, svc *database.Service, p *groupPatch) error {
+	caller := adminUser(c)
+	ctx := c.Request().Context()
+
+	for id := range p.members {
+		if d.user(id) == nil {
+			return scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "no user with id "+id))
+		}
+	}
+
+	var remove, add []int64
+	for id := range p.current {
+		if !p.members[id] {
+			uid, _ := strconv.ParseInt(id, 10, 64)
+			remove = append(remove, uid)
+		}
+	}
+	for id := range p.members {
+		if !p.current[id] {
+			uid, _ := strconv.ParseInt(id, 10, 64)
+			add = append(add, uid)
+		}
+	}
+
+	removed, added, err := s.db.SyncServiceMembers(ctx, svc.ID, p.name, caller.ID, remove, add)
+	if err != nil {
+		slog.Warn("scim group update failed", "service_id", svc.ID, "error", err)
+		return scimError(c, scim.NewError(http.StatusInternalServerError, "", "failed to update group"))
+	}
+
+	if p.name != svc.Name {
+		slog.Info("service updated", "service_id", svc.ID, "by", caller.Handle)
+		s.webhooks.Emit(ctx, webhook.EventServiceUpdated, map[string]any{
+			"service_id": svc.ID, "name": p.name, "url": svc.URL, "admin_role": svc.AdminRole, "upstream_url": svc.UpstreamURL, "by": caller.Handle,
+		})
+	}
+	for i := range removed {
+		s.grantRemoved(ctx, caller, &removed[i])
+	}
+	for i := range added {
+		s.grantAdded(ctx, caller, &added[i])
+	}
+
+	return s.respondSCIMGroup(c, strconv.FormatInt(svc.ID, 10), true)
+}
+
+// groupPatch accumulates a group's name and member set (user IDs) while
+// PATCH operations are applied.
+type groupPatch struct {
+	name    string
+	current map[string]bool
+	members map[string]bool
+	display map[string]string // member id -> handle, for value filters
+}
+
+func newGroupPatch(d *scimDirectory, svc *database.Service) *groupPatch {
+	p := &groupPatch{name: svc.Name, current: map[string]bool{}, members: map[string]bool{}, display: map[string]string{}}
+	for _, g := range d.grants {
+		if g.ServiceID == svc.ID {
+			id := strconv.FormatInt(g.UserID, 10)
+			p.current[id] = true
+			p.members[id] = true
+			p.display[id] = g.UserHandle
+		}
+	}
+	return p
+}
+
+func (p *groupPatch) apply(op scim.PatchOperation) *scim.Error {
+	kind := op.NormalizedOp()
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "unknown op "+op.Op)
+	}
+	if op.Path == "" {
+		if kind == "remove" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrNoTarget, "remove requires a path")
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &obj); err != nil {
+			return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "value must be an object when path is omitted")
+		}
+		for k, v := range obj {
+			path, err := scim.ParsePath(k)
+			if err != nil {
+				return scim.NewError(http.StatusBadRequest, scim.ErrInvalidPath, err.Error())
+			}
+			if serr := p.set(kind, path, v); serr != nil {
+				return serr
+			}
+		}
+		return nil
+	}
+	path, err := scim.ParsePath(op.Path)
+	if err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidPath, err.Error())
+	}
+	return p.set(kind, path, op.Value)
+}
+
+func (p *groupPatch) set(kind string, path scim.Path, value json.RawMessage) *scim.Error {
+	switch path.Attr {
+	case "displayname":
+		if kind == "remove" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "displayName is required")
+		}
+		if err := json.Unmarshal(value, &p.name); err != nil || p.name == "" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "displayName must be a non-empty string")
+		}
+	case "members":
+		var vs []scim.MultiValue
+		if len(value) > 0 && string(value) != "null" {
+			var ok bool
+			if vs, ok = scim.ParseValues(value); !ok {
+				return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "members must be a list of {\"value\": user id}")
+			}
+		}
+		switch {
+		case kind == "remove" && path.Filter != nil:
+			for id := range p.members {
+				if path.Filter.Match(scim.Attrs{"value": {id}, "display": {p.display[id]}}) {
+					delete(p.members, id)
+				}
+			}
+		case kind == "remove" && len(vs) > 0:
+			for _, v := range vs {
+				delete(p.members, v.Value)
+			}
+		case kind == "remove", kind == "replace":
+			p.members = map[string]bool{}
+			fallthrough
+		default:
+			for _, v := range vs {
+				if v.Value != "" {
+					p.members[v.Value] = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// --- Discovery ---
+
+func (s *Server) handleSCIMServiceProviderConfig(c echo.Context) error {
+	return scimJSON(c, http.StatusOK, map[string]any{
+		"schemas":        []string{scim.SchemaSPConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The token configured as SCIM_TOKEN.",
+			"primary":     true,
+		}},
+		"meta": scim.Meta{ResourceType: "ServiceProviderConfig", Location: strings.TrimRight(s.cfg.PublicURL, "/") + "/scim/v2/ServiceProviderConfig"},
+	})
+}
+
+func (s *Server) scimResourceTypes() []any {
+	base := strings.TrimRight(s.cfg.PublicURL, "/") + "/scim/v2/ResourceTypes/"
+	return []any{
+		map[string]any{
+			"schemas":          []string{scim.SchemaResourceType},
+			"id":               "User",
+			"name":             "User",
+			"endpoint":         "/Users",
+			"description":      "noknok user; userName is the primary Bluesky handle and externalId its DID",
+			"schema":           scim.SchemaUser,
+			"schemaExtensions": []map[string]any{{"schema": scim.SchemaUserExtension, "required": false}},
+			"meta":             scim.Meta{ResourceType: "ResourceType", Location: base + "User"},
+		},
+		map[string]any{
+			"schemas":     []string{scim.SchemaResourceType},
+			"id":          "Group",
+			"name":        "Group",
+			"endpoint":    "/Groups",
+			"description": "noknok service; members are the users granted access",
+			"schema":      scim.SchemaGroup,
+			"meta":        scim.Meta{ResourceType: "ResourceType", Location: base + "Group"},
+		},
+	}
+}
+
+func scimAttr(name, typ, mutability string, multi, required bool) map[string]any {
+	return map[string]any{
+		"name": name, "type": typ, "multiValued": multi, "required": required,
+		"caseExact": false, "mutability": mutability, "returned": "default", "uniqueness": "none",
+	}
+}
+
+func (s *Server) scimSchemas() []any {
+	base := strings.TrimRight(s.cfg.PublicURL, "/") + "/scim/v2/Schemas/"
+	schema := func(id, name string, attrs ...map[string]any) any {
+		return map[string]any{
+			"schemas": []string{scim.SchemaSchema}, "id": id, "name": name, "attributes": attrs,
+			"meta": scim.Meta{ResourceType: "Schema", Location: base + id},
+		}
+	}
+	return []any{
+		schema(scim.SchemaUser, "User",
+			scimAttr("userName", "string", "immutable", false, true),
+			scimAttr("displayName", "string", "readOnly", false, false),
+			scimAttr("nickName", "string", "readWrite", false, false),
+			scimAttr("active", "boolean", "readOnly", false, false),
+			scimAttr("photos", "complex", "readOnly", true, false),
+			scimAttr("roles", "complex", "readWrite", true, false),
+			scimAttr("groups", "complex", "readOnly", true, false),
+		),
+		schema(scim.SchemaUserExtension, "noknok User",
+			scimAttr("identities", "complex", "readOnly", true, false),
+		),
+		schema(scim.SchemaGroup, "Group",
+			scimAttr("displayName", "string", "readWrite", false, true),
+			scimAttr("members", "complex", "readWrite", true, false),
+		),
+	}
+}
+
+func (s *Server) handleSCIMResourceTypes(c echo.Context) error {
+	return scimDiscoveryList(c, s.scimResourceTypes())
+}
+
+func (s *Server) handleSCIMSchemas(c echo.Context) error {
+	return scimDiscoveryList(c, s.scimSchemas())
+}
+
+func scimDiscoveryList(c echo.Context, items []any) error {
+	if id := c.Param("id"); id != "" {
+		for _, it := range items {
+			if it.(map[string]any)["id"] == id {
+				return scimJSON(c, http.StatusOK, it)
+			}
+		}
+		return scimNotFound(c, "resource")
+	}
+	return scimJSON(c, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: len(items),
+		StartIndex:   1,
+		ItemsPerPage: len(items),
+		Resources:    items,
+	})
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"log/slog"
+	"net/http"
 	"sync"
 	"time"
 
@@ -11,20 +12,36 @@ import (
 	"github.com/primal-host/noknok/internal/atproto"
 	"github.com/primal-host/noknok/internal/config"
 	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/devauth"
+	"github.com/primal-host/noknok/internal/ldap"
+	"github.com/primal-host/noknok/internal/notify"
 	"github.com/primal-host/noknok/internal/session"
+	"github.com/primal-host/noknok/internal/webhook"
+	"google.golang.org/grpc"
 )
 
 // Server wraps the Echo instance and dependencies.
 type Server struct {
-	echo       *echo.Echo
-	db         *database.DB
-	sess       *session.Manager
-	cfg        *config.Config
-	oauth      *atproto.OAuthClient
-	addr       string
-	healthMu   sync.RWMutex
-	healthData map[int64]bool
-	healthStop chan struct{}
+	echo        *echo.Echo
+	db          *database.DB
+	sess        *session.Manager
+	cfg         *config.Config
+	oauth       *atproto.OAuthClient
+	profiles    *atproto.ProfileClient
+	notifier    *notify.Notifier
+	webhooks    *webhook.Dispatcher
+	addr        string
+	healthMu    sync.RWMutex
+	healthData  map[int64]bool
+	healthStop  chan struct{}
+	verifyStop  chan struct{}
+	traefikStop chan struct{}
+	grpc        *grpc.Server // ext_authz, nil unless EXTAUTHZ_GRPC_ADDR is set
+	proxy       *http.Server // built-in reverse proxy, nil unless PROXY_ADDR is set
+	ldap        *ldap.Server // read-only directory, nil unless LDAP_ADDR is set
+
+	proxyTransport *http.Transport
+	traefikWarned  sync.Map // services already reported as left out of the Traefik config
 }
 
 // New creates a configured Echo server.
@@ -36,10 +53,22 @@ func New(db *database.DB, sess *session.Manager, cfg *config.Config, oauth *atpr
 		cfg:   cfg,
 		oauth: oauth,
 		addr:  cfg.ListenAddr,
+
+		profiles: atproto.NewProfileClient(cfg.ProfileAPIURL, "noknok/"+config.Version),
 	}
 
+	var sinks []notify.Sink
+	if cfg.NotifySMTPAddr != "" {
+		sinks = append(sinks, notify.NewSMTP(cfg.NotifySMTPAddr, cfg.NotifySMTPFrom, cfg.NotifySMTPTo,
+			cfg.NotifySMTPUsername, cfg.NotifySMTPPassword))
+	}
+	s.webhooks = webhook.New(db, cfg.WebhookAllowPrivate)
+	s.notifier = notify.New(db, s.webhooks, sinks...)
+	sess.OnRevoke(s.alertSessionRevoked)
+
 	s.echo.HideBanner = true
 	s.echo.HidePort = true
+	s.echo.IPExtractor = echo.ExtractIPFromXFFHeader(trustOptions(cfg)...)
 
 	s.echo.Use(middleware.Recover())
 	s.echo.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
@@ -58,10 +87,24 @@ func New(db *database.DB, sess *session.Manager, cfg *config.Config, oauth *atpr
 
 	s.registerRoutes()
 	s.startHealthPoller()
+	s.startVerifier()
+	s.startExtAuthz()
+	s.startProxy()
+	s.startLDAP()
+	s.startTraefikWriter()
+	s.webhooks.Start()
 
 	return s
 }
 
+// MountDevAuth serves the offline dev auth server's account picker and
+// fetches profiles from its fake PDS. Development only.
+func (s *Server) MountDevAuth(dev *devauth.Server) {
+	s.echo.Any(devauth.AuthorizePath, echo.WrapHandler(http.HandlerFunc(dev.HandleAuthorize)))
+	s.profiles = atproto.NewProfileClient(dev.PDSURL(), "noknok/"+config.Version)
+	s.profiles.SetTransport(dev.Transport())
+}
+
 // Start begins listening for HTTP requests.
 func (s *Server) Start() error {
 	slog.Info("server listening", "addr", s.addr)
@@ -71,6 +114,18 @@ func (s *Server) Start() error {
 // Shutdown gracefully stops the server.
 func (s *Server) Shutdown(ctx context.Context) error {
 	close(s.healthStop)
+	close(s.verifyStop)
+	close(s.traefikStop)
+	s.webhooks.Stop()
+	if s.grpc != nil {
+		s.grpc.GracefulStop()
+	}
+	if s.proxy != nil {
+		_ = s.proxy.Shutdown(ctx)
+	}
+	if s.ldap != nil {
+		_ = s.ldap.Close()
+	}
 	return s.echo.Shutdown(ctx)
 }
 
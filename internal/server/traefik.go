@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// Traefik dynamic configuration, limited to what noknok generates.
+type traefikConfig struct {
+	HTTP traefikHTTP `json:"http" yaml:"http"`
+}
+
+type traefikHTTP struct {
+	Routers     map[string]traefikRouter     `json:"routers" yaml:"routers"`
+	Services    map[string]traefikService    `json:"services" yaml:"services"`
+	Middlewares map[string]traefikMiddleware `json:"middlewares" yaml:"middlewares"`
+}
+
+type traefikRouter struct {
+	Rule        string      `json:"rule" yaml:"rule"`
+	Priority    int         `json:"priority,omitempty" yaml:"priority,omitempty"`
+	EntryPoints []string    `json:"entryPoints,omitempty" yaml:"entryPoints,omitempty"`
+	Service     string      `json:"service" yaml:"service"`
+	Middlewares []string    `json:"middlewares,omitempty" yaml:"middlewares,omitempty"`
+	TLS         *traefikTLS `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+type traefikTLS struct {
+	CertResolver string `json:"certResolver,omitempty" yaml:"certResolver,omitempty"`
+}
+
+type traefikService struct {
+	LoadBalancer traefikLoadBalancer `json:"loadBalancer" yaml:"loadBalancer"`
+}
+
+type traefikLoadBalancer struct {
+	Servers []traefikServer `json:"servers" yaml:"servers"`
+}
+
+type traefikServer struct {
+	URL string `json:"url" yaml:"url"`
+}
+
+type traefikMiddleware struct {
+	ForwardAuth traefikForwardAuth `json:"forwardAuth" yaml:"forwardAuth"`
+}
+
+type traefikForwardAuth struct {
+	Address             string   `json:"address" yaml:"address"`
+	TrustForwardHeader  bool     `json:"trustForwardHeader" yaml:"trustForwardHeader"`
+	AuthResponseHeaders []string `json:"authResponseHeaders" yaml:"authResponseHeaders"`
+}
+
+// traefikAuthMiddleware is the name of the generated forwardAuth middleware.
+const traefikAuthMiddleware = "noknok-auth"
+
+// traefikRouterPriority is set on generated routers so they win over other
+// routers with the same Host rule (Traefik's default priority is the rule
+// length), e.g. docker-label routers without the auth middleware.
+const traefikRouterPriority = 100000
+
+// buildTraefikConfig declares a router and service for every enabled service
+// with an upstream_url. Non-public services get the forwardAuth middleware;
+// disabled services are left out so Traefik stops routing them.
+func (s *Server) buildTraefikConfig(ctx context.Context) (*traefikConfig, error) {
+	svcs, err := s.db.ListServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mw := traefikMiddleware{ForwardAuth: traefikForwardAuth{
+		Address:             s.cfg.TraefikAuthAddress,
+		TrustForwardHeader:  s.cfg.TraefikTrustForward,
+		AuthResponseHeaders: identityHeaders,
+	}}
+
+	cfg := &traefikConfig{HTTP: traefikHTTP{
+		Routers:     map[string]traefikRouter{},
+		Services:    map[string]traefikService{},
+		Middlewares: map[string]traefikMiddleware{traefikAuthMiddleware: mw},
+	}}
+	for _, svc := range svcs {
+		if !svc.Enabled {
+			continue
+		}
+		if svc.UpstreamURL == "" {
+			s.warnTraefikSkip(svc, "traefik config: service has no upstream_url, not routed")
+			continue
+		}
+		host := serviceHost(svc)
+		if host == "" {
+			s.warnTraefikSkip(svc, "traefik config: service URL has no host, not routed")
+			continue
+		}
+
+		name := "noknok-" + svc.Slug
+		router := traefikRouter{
+			Rule:        "Host(`" + host + "`)",
+			Priority:    traefikRouterPriority,
+			EntryPoints: s.cfg.TraefikEntryPoints,
+			Service:     name,
+		}
+		if !svc.Public {
+			router.Middlewares = []string{traefikAuthMiddleware}
+		}
+		if s.cfg.TraefikCertResolver != "" {
+			router.TLS = &traefikTLS{CertResolver: s.cfg.TraefikCertResolver}
+		}
+		cfg.HTTP.Routers[name] = router
+
+		cfg.HTTP.Services[name] = traefikService{LoadBalancer: traefikLoadBalancer{
+			Servers: []traefikServer{{URL: svc.UpstreamURL}},
+		}}
+	}
+	return cfg, nil
+}
+
+// warnTraefikSkip logs a service left out of the generated config once per
+// process; Traefik polls the config every few seconds.
+func (s *Server) warnTraefikSkip(svc database.Service, msg string) {
+	if _, seen := s.traefikWarned.LoadOrStore(svc.Slug+"\x00"+msg, true); seen {
+		return
+	}
+	slog.Warn(msg, "slug", svc.Slug, "url", svc.URL)
+}
+
+// serviceHost returns the host of a service's public URL, or "".
+func serviceHost(svc database.Service) string {
+	u, err := url.Parse(svc.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// handleTraefikConfig serves the catalog to Traefik's HTTP provider:
+//
+//	providers:
+//	  http:
+//	    endpoint: http://noknok:4321/traefik/config
+//	    headers: { Authorization: "Bearer <TRAEFIK_PROVIDER_TOKEN>" }
+func (s *Server) handleTraefikConfig(c echo.Context) error {
+	if !s.cfg.TraefikProvider {
+		return echo.ErrNotFound
+	}
+	if s.cfg.TraefikProviderToken != "" {
+		got := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.TraefikProviderToken)) != 1 {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+	}
+	cfg, err := s.buildTraefikConfig(c.Request().Context())
+	if err != nil {
+		slog.Error("traefik config: failed to list services", "error", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, cfg)
+}
+
+// startTraefikWriter keeps cfg.TraefikConfigFile in sync with the catalog for
+// Traefik's file provider. The file is replaced atomically and only when its
+// content changes, so Traefik's watcher is not woken needlessly.
+func (s *Server) startTraefikWriter() {
+	s.traefikStop = make(chan struct{})
+	if s.cfg.TraefikConfigFile == "" {
+		return
+	}
+	go func() {
+		var last []byte
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			if data, err := s.renderTraefikFile(); err != nil {
+				slog.Error("traefik config: render failed", "error", err)
+			} else if !bytes.Equal(data, last) {
+				if err := writeFileAtomic(s.cfg.TraefikConfigFile, data); err != nil {
+					slog.Error("traefik config: write failed", "path", s.cfg.TraefikConfigFile, "error", err)
+				} else {
+					last = data
+					slog.Info("traefik config written", "path", s.cfg.TraefikConfigFile)
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-s.traefikStop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) renderTraefikFile() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cfg, err := s.buildTraefikConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(cfg)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".noknok-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/config"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// trustOptions decides which X-Forwarded-For hops RealIP skips. With
+// TRUSTED_PROXIES set only those ranges are trusted; otherwise Echo's
+// defaults (loopback, link-local and private networks) apply.
+func trustOptions(cfg *config.Config) []echo.TrustOption {
+	if len(cfg.TrustedProxies) == 0 {
+		return nil
+	}
+	opts := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, p := range cfg.TrustedProxies {
+		_, ipnet, err := net.ParseCIDR(p.Masked().String())
+		if err != nil {
+			slog.Warn("trusted proxies: skipping range", "range", p, "error", err)
+			continue
+		}
+		opts = append(opts, echo.TrustIPRange(ipnet))
+	}
+	return opts
+}
+
+// trustedCaller reports whether a forward-auth request comes from a trusted
+// proxy: its source address must be in TRUSTED_PROXIES (when set) and it must
+// carry the shared secret (when set). With neither configured every caller
+// is trusted, as before.
+func (s *Server) trustedCaller(remoteAddr, secret string) bool {
+	if len(s.cfg.TrustedProxies) > 0 && !s.trustedAddr(remoteAddr) {
+		return false
+	}
+	if s.cfg.TrustedProxySecret != "" {
+		return subtle.ConstantTimeCompare([]byte(secret), []byte(s.cfg.TrustedProxySecret)) == 1
+	}
+	return true
+}
+
+// trustedAddr reports whether remoteAddr is in TRUSTED_PROXIES.
+func (s *Server) trustedAddr(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range s.cfg.TrustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireTrustedProxy rejects forward-auth requests from untrusted callers,
+// whose forwarded headers could otherwise probe decisions for arbitrary hosts
+// or shape redirect URLs. It uses the socket peer, never X-Forwarded-For.
+func (s *Server) requireTrustedProxy(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		if !s.trustedCaller(r.RemoteAddr, r.Header.Get(s.cfg.TrustedProxyHeader)) {
+			s.rejectForwardAuth(r)
+			return c.NoContent(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
+
+// requireTrustedTraefik guards the Traefik endpoint. forwardAuth can only
+// pass a header to noknok by copying it from the client request, which
+// would hand the secret to every upstream, so TRUSTED_PROXY_SECRET does not
+// apply here: the caller must be in TRUSTED_PROXIES. With only a secret
+// configured the endpoint refuses every caller.
+func (s *Server) requireTrustedTraefik(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		ok := s.cfg.TrustedProxySecret == ""
+		if len(s.cfg.TrustedProxies) > 0 {
+			ok = s.trustedAddr(r.RemoteAddr)
+		}
+		if !ok {
+			s.rejectForwardAuth(r)
+			return c.NoContent(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
+
+func (s *Server) rejectForwardAuth(r *http.Request) {
+	slog.Warn("untrusted forward-auth caller rejected",
+		"remote", r.RemoteAddr,
+		"path", r.URL.Path,
+		"forwarded_host", r.Header.Get("X-Forwarded-Host"),
+		"forwarded_uri", r.Header.Get("X-Forwarded-Uri"),
+	)
+}
+
+// trustedGRPCCaller applies the same check to ext_authz calls; the secret is
+// read from gRPC metadata (Envoy grpc_service initial_metadata).
+func (s *Server) trustedGRPCCaller(ctx context.Context) bool {
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	secret := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(s.cfg.TrustedProxyHeader); len(v) > 0 {
+			secret = v[0]
+		}
+	}
+	if !s.trustedCaller(remote, secret) {
+		slog.Warn("untrusted ext_authz caller rejected", "remote", remote)
+		return false
+	}
+	return true
+}
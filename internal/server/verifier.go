@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/primal-host/noknok/internal/atproto"
+	"github.com/primal-host/noknok/internal/session"
+)
+
+// startVerifier periodically re-resolves the identity behind every active
+// session. Accounts that are gone or inactive lose their sessions; handle
+// and profile changes are propagated to identities and sessions.
+func (s *Server) startVerifier() {
+	s.verifyStop = make(chan struct{})
+
+	interval, err := time.ParseDuration(s.cfg.VerifyInterval)
+	if err != nil {
+		slog.Error("invalid VERIFY_INTERVAL, account verification disabled", "error", err)
+		return
+	}
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.verifyAccounts(interval)
+			case <-s.verifyStop:
+				return
+			}
+		}
+	}()
+}
+
+// verifyAccounts checks each account once per cycle. OAuth tokens are only
+// refreshed when they would expire before the next cycle.
+func (s *Server) verifyAccounts(interval time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	sessions, err := s.sess.ListActive(ctx)
+	if err != nil {
+		slog.Error("verifier: failed to list sessions", "error", err)
+		return
+	}
+
+	byDID := make(map[string][]session.Session)
+	for _, sess := range sessions {
+		byDID[sess.DID] = append(byDID[sess.DID], sess)
+	}
+
+	for did, group := range byDID {
+		reason, st, err := s.accountRevocation(ctx, did)
+		if err != nil {
+			// Transient resolution failure — keep sessions and retry next cycle.
+			slog.Warn("verifier: account lookup failed", "did", did, "error", err)
+			continue
+		}
+		if reason != "" {
+			s.revokeOAuthSessions(ctx, group)
+			n, err := s.sess.DestroyByDID(ctx, did)
+			if err != nil {
+				slog.Error("verifier: failed to revoke sessions", "did", did, "error", err)
+				continue
+			}
+			s.revokeAppPasswords(ctx, did, reason)
+			slog.Warn("verifier: revoked sessions", "did", did, "reason", reason, "count", n)
+			continue
+		}
+		if err := s.db.UpdateIdentityPDS(ctx, did, st.PDS); err != nil {
+			slog.Warn("verifier: failed to record PDS", "did", did, "error", err)
+		}
+
+		if st.Handle != "" {
+			for _, sess := range group {
+				if sess.Handle != st.Handle {
+					if err := s.sess.UpdateHandle(ctx, did, st.Handle); err != nil {
+						slog.Warn("verifier: failed to update handle", "did", did, "error", err)
+					} else {
+						slog.Info("verifier: handle changed", "did", did, "old", sess.Handle, "new", st.Handle)
+					}
+					break
+				}
+			}
+		}
+
+		s.refreshProfile(ctx, did)
+
+		for _, sess := range group {
+			if _, err := s.oauth.RefreshSession(ctx, did, sess.OAuthSessionID, interval); err != nil {
+				slog.Warn("verifier: oauth refresh failed", "did", did, "error", err)
+			}
+		}
+	}
+}
+
+// accountRevocation re-resolves did and returns why its access must be
+// revoked: "gone", the account status when inactive, or "denied PDS". It
+// returns "" when the account may keep access, and an error for transient
+// lookup failures.
+func (s *Server) accountRevocation(ctx context.Context, did string) (string, *atproto.AccountStatus, error) {
+	st, err := s.oauth.VerifyAccount(ctx, did)
+	if errors.Is(err, atproto.ErrAccountGone) {
+		return "gone", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !st.Active {
+		if st.Status != "" {
+			return st.Status, st, nil
+		}
+		return "inactive", st, nil
+	}
+	if !s.oauth.PDSAllowed(st.PDS) {
+		return "denied PDS", st, nil
+	}
+	return "", st, nil
+}
+
+// revokeAppPasswords deletes the app passwords of the user owning did.
+func (s *Server) revokeAppPasswords(ctx context.Context, did, reason string) {
+	n, err := s.db.DeleteAppPasswordsByDID(ctx, did)
+	if err != nil {
+		slog.Error("failed to revoke app passwords", "did", did, "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Warn("revoked app passwords", "did", did, "reason", reason, "count", n)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/primal-host/noknok/internal/database"
+	"github.com/primal-host/noknok/internal/webhook"
+)
+
+// webhookRequest is the body of POST and PUT /admin/api/webhooks. On PUT,
+// omitted fields keep their current value.
+type webhookRequest struct {
+	URL          string   `json:"url"`
+	Description  *string  `json:"description"`
+	Events       []string `json:"events"`
+	Enabled      *bool    `json:"enabled"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
+
+func (r *webhookRequest) validate() string {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an absolute http(s) URL"
+	}
+	for _, e := range r.Events {
+		if !webhook.ValidEventFilter(e) {
+			return "unknown event type: " + e
+		}
+	}
+	if r.Events == nil {
+		r.Events = []string{}
+	}
+	return ""
+}
+
+// webhookWithSecret is returned when a secret is created or rotated; it is
+// the only time the secret leaves the server.
+type webhookWithSecret struct {
+	*database.Webhook
+	Secret string `json:"secret"`
+}
+
+func (s *Server) handleListWebhooks(c echo.Context) error {
+	hooks, err := s.db.ListWebhooks(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list webhooks"})
+	}
+	if hooks == nil {
+		hooks = []database.Webhook{}
+	}
+	return c.JSON(http.StatusOK, map[string]any{"webhooks": hooks, "event_types": webhook.EventTypes})
+}
+
+func (s *Server) handleCreateWebhook(c echo.Context) error {
+	caller := adminUser(c)
+
+	var req webhookRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	if err := s.webhooks.CheckURL(c.Request().Context(), req.URL); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "url rejected: " + err.Error()})
+	}
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to generate secret"})
+	}
+
+	desc := ""
+	if req.Description != nil {
+		desc = *req.Description
+	}
+	hook, err := s.db.CreateWebhook(c.Request().Context(), req.URL, desc, req.Events, secret)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create webhook"})
+	}
+
+	slog.Info("webhook created", "webhook_id", hook.ID, "url", hook.URL, "by", caller.Handle)
+	return c.JSON(http.StatusCreated, webhookWithSecret{hook, secret})
+}
+
+func (s *Server) handleUpdateWebhook(c echo.Context) error {
+	caller := adminUser(c)
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid webhook ID"})
+	}
+	hook, err := s.db.GetWebhook(ctx, id)
+	if err != nil {
+		return webhookLookupError(c, err)
+	}
+
+	var req webhookRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	if req.URL == "" {
+		req.URL = hook.URL
+	}
+	if req.Events == nil {
+		req.Events = hook.Events
+	}
+	if msg := req.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+	if err := s.webhooks.CheckURL(c.Request().Context(), req.URL); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "url rejected: " + err.Error()})
+	}
+	if req.Description != nil {
+		hook.Description = *req.Description
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	hook.URL, hook.Events = req.URL, req.Events
+
+	if err := s.db.UpdateWebhook(ctx, id, hook.URL, hook.Description, hook.Events, hook.Enabled); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update webhook"})
+	}
+
+	slog.Info("webhook updated", "webhook_id", id, "by", caller.Handle)
+	if req.RotateSecret {
+		secret, err := webhook.GenerateSecret()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to generate secret"})
+		}
+		if err := s.db.SetWebhookSecret(ctx, id, secret); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to rotate secret"})
+		}
+		slog.Info("webhook secret rotated", "webhook_id", id, "by", caller.Handle)
+		return c.JSON(http.StatusOK, webhookWithSecret{hook, secret})
+	}
+	return c.JSON(http.StatusOK, hook)
+}
+
+func (s *Server) handleDeleteWebhook(c echo.Context) error {
+	caller := adminUser(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid webhook ID"})
+	}
+	if err := s.db.DeleteWebhook(c.Request().Context(), id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete webhook"})
+	}
+
+	slog.Info("webhook deleted", "webhook_id", id, "by", caller.Handle)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleListWebhookDeliveries returns a webhook's delivery log, newest first.
+func (s *Server) handleListWebhookDeliveries(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid webhook ID"})
+	}
+	limit := 50
+	if v, err := strconv.Atoi(c.QueryParam("limit")); err == nil && v > 0 {
+		limit = min(v, 500)
+	}
+	dels, err := s.db.ListWebhookDeliveries(c.Request().Context(), id, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list deliveries"})
+	}
+	if dels == nil {
+		dels = []database.WebhookDelivery{}
+	}
+	return c.JSON(http.StatusOK, dels)
+}
+
+// handleTestWebhook queues a "ping" event for the webhook, even if it is
+// disabled or its filter would not match.
+func (s *Server) handleTestWebhook(c echo.Context) error {
+	caller := adminUser(c)
+	ctx := c.Request().Context()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid webhook ID"})
+	}
+	if _, err := s.db.GetWebhook(ctx, id); err != nil {
+		return webhookLookupError(c, err)
+	}
+	del, err := s.webhooks.SendTest(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to queue test event"})
+	}
+
+	slog.Info("webhook test queued", "webhook_id", id, "delivery_id", del.ID, "by", caller.Handle)
+	return c.JSON(http.StatusAccepted, del)
+}
+
+// handleRetryWebhookDelivery requeues a delivered or failed delivery.
+func (s *Server) handleRetryWebhookDelivery(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid webhook ID"})
+	}
+	delID, err := strconv.ParseInt(c.Param("deliveryId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid delivery ID"})
+	}
+	ok, err := s.db.RetryWebhookDelivery(c.Request().Context(), id, delID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to requeue delivery"})
+	}
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "delivery not found or already pending"})
+	}
+	s.webhooks.Poke()
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// emitLogin raises the login webhook event. switched is set when the browser
+// already held a session for this identity and was switched back to it.
+func (s *Server) emitLogin(c echo.Context, userID int64, did, handle, pdsURL string, switched bool) {
+	client := s.clientBinding(c)
+	s.webhooks.Emit(c.Request().Context(), webhook.EventLogin, map[string]any{
+		"user_id":   userID,
+		"did":       did,
+		"handle":    handle,
+		"pds":       pdsURL,
+		"switched":  switched,
+		"ua_family": client.UAFamily,
+		"ip_prefix": client.IPPrefix,
+	})
+}
+
+func webhookLookupError(c echo.Context, err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "webhook not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load webhook"})
+}
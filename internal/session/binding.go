@@ -0,0 +1,259 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Binding describes the client a session was issued to. Empty fields are
+// unknown: an empty bound field is never checked. A missing IP or UA counts
+// as a change, but hints are only compared when the request carries them,
+// since browsers omit them on plain-http origins and some subrequests.
+type Binding struct {
+	IPPrefix string `json:"ip_prefix"` // client address masked to /24 (IPv4) or /48 (IPv6)
+	UAFamily string `json:"ua_family"` // browser and OS family, e.g. "Firefox/Linux"
+	Hints    string `json:"hints"`     // hash of the low-entropy Sec-CH-UA client hints
+}
+
+// Binding fields, as named in BindingPolicy.Strict and the audit trail.
+const (
+	BindIP    = "ip"
+	BindUA    = "ua"
+	BindHints = "hints"
+)
+
+// BindingAction is what Validate does when a strict binding field changes.
+type BindingAction int
+
+const (
+	BindOff    BindingAction = iota // binding is not checked
+	BindAllow                       // audit the change and rebind to the new client
+	BindStepUp                      // reject the request so the client must log in again
+	BindRevoke                      // destroy the session group and raise an alert
+)
+
+// BindingPolicy configures session binding. Changes to fields not listed in
+// Strict are always allowed (and audited).
+type BindingPolicy struct {
+	Action BindingAction
+	Strict []string
+}
+
+var (
+	// ErrStepUp is returned by Validate when the client must re-authenticate.
+	ErrStepUp = errors.New("session: client changed, re-authentication required")
+	// ErrRevoked is returned by Validate when a client change revoked the session.
+	ErrRevoked = errors.New("session: client changed, session revoked")
+)
+
+// ParseBindingAction parses "off", "allow", "stepup" or "revoke".
+func ParseBindingAction(v string) (BindingAction, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "off":
+		return BindOff, nil
+	case "allow":
+		return BindAllow, nil
+	case "stepup", "step-up":
+		return BindStepUp, nil
+	case "revoke":
+		return BindRevoke, nil
+	}
+	return BindOff, fmt.Errorf("unknown session binding action %q", v)
+}
+
+func (a BindingAction) String() string {
+	switch a {
+	case BindAllow:
+		return "allow"
+	case BindStepUp:
+		return "stepup"
+	case BindRevoke:
+		return "revoke"
+	}
+	return "off"
+}
+
+// NewBinding describes a client from its address and request headers.
+func NewBinding(ip string, h http.Header) Binding {
+	b := Binding{UAFamily: uaFamily(h.Get("User-Agent"))}
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		addr = addr.Unmap()
+		bits := 48
+		if addr.Is4() {
+			bits = 24
+		}
+		if p, err := addr.Prefix(bits); err == nil {
+			b.IPPrefix = p.String()
+		}
+	}
+	var hints []string
+	for _, k := range []string{"Sec-CH-UA", "Sec-CH-UA-Mobile", "Sec-CH-UA-Platform"} {
+		hints = append(hints, h.Get(k))
+	}
+	if joined := strings.Join(hints, "\n"); strings.TrimSpace(joined) != "" {
+		sum := sha256.Sum256([]byte(joined))
+		b.Hints = hex.EncodeToString(sum[:8])
+	}
+	return b
+}
+
+// uaFamily reduces a User-Agent to "browser/os", ignoring versions so that
+// browser updates do not look like a different client.
+func uaFamily(ua string) string {
+	if ua == "" {
+		return ""
+	}
+	browser := "other"
+	for _, f := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, f.token) {
+			browser = f.name
+			break
+		}
+	}
+	os := "other"
+	for _, f := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"CrOS", "ChromeOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, f.token) {
+			os = f.name
+			break
+		}
+	}
+	return browser + "/" + os
+}
+
+// changed lists the fields that differ between b and cur. A field that is
+// empty in b is unknown and ignored. A missing IP or UA in cur has changed,
+// so stripping headers cannot evade the check; hints are compared only when
+// both sides have them.
+func (b Binding) changed(cur Binding) []string {
+	var out []string
+	diff := func(name, a, c string) {
+		if a != "" && a != c {
+			out = append(out, name)
+		}
+	}
+	diff(BindIP, b.IPPrefix, cur.IPPrefix)
+	diff(BindUA, b.UAFamily, cur.UAFamily)
+	if cur.Hints != "" {
+		diff(BindHints, b.Hints, cur.Hints)
+	}
+	return out
+}
+
+// checkBinding applies the binding policy to a validated session. Sessions
+// created before binding existed adopt the first client they are seen from.
+func (m *Manager) checkBinding(ctx context.Context, s *Session, bound, client Binding) error {
+	if bound == (Binding{}) {
+		m.rebind(ctx, s.ID, client)
+		m.audit(ctx, s, "session.bind", map[string]any{"to": client})
+		return nil
+	}
+	changed := bound.changed(client)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	action := BindAllow
+	for _, f := range changed {
+		for _, strict := range m.binding.Strict {
+			if f == strict {
+				action = m.binding.Action
+			}
+		}
+	}
+	m.audit(ctx, s, "session.binding_"+action.String(), map[string]any{
+		"changed": changed, "from": bound, "to": client,
+	})
+
+	switch action {
+	case BindStepUp:
+		return ErrStepUp
+	case BindRevoke:
+		slog.Warn("session binding: client changed, revoking session group",
+			"did", s.DID, "session_id", s.ID, "changed", changed, "from", bound, "to", client)
+		if s.GroupID != "" {
+			_ = m.DestroyGroup(ctx, s.GroupID)
+		} else {
+			_, _ = m.pool.Exec(ctx, `DELETE FROM sessions WHERE id = $1`, s.ID)
+		}
+		if m.onRevoke != nil {
+			m.onRevoke(ctx, RevokeEvent{Session: *s, Changed: changed, From: bound, To: client})
+		}
+		return ErrRevoked
+	}
+	m.rebind(ctx, s.ID, bound.merge(client))
+	return nil
+}
+
+// merge returns b updated with the non-empty fields of cur, so a client that
+// omits a header does not erase what the session is bound to.
+func (b Binding) merge(cur Binding) Binding {
+	if cur.IPPrefix != "" {
+		b.IPPrefix = cur.IPPrefix
+	}
+	if cur.UAFamily != "" {
+		b.UAFamily = cur.UAFamily
+	}
+	if cur.Hints != "" {
+		b.Hints = cur.Hints
+	}
+	return b
+}
+
+// RevokeEvent describes a session group revoked because its client changed.
+type RevokeEvent struct {
+	Session Session
+	Changed []string
+	From    Binding
+	To      Binding
+}
+
+// OnRevoke registers fn to raise the alert when binding revokes a session
+// group, e.g. by notifying the user and owners.
+func (m *Manager) OnRevoke(fn func(ctx context.Context, ev RevokeEvent)) {
+	m.onRevoke = fn
+}
+
+func (m *Manager) rebind(ctx context.Context, sessionID int64, b Binding) {
+	_, err := m.pool.Exec(ctx, `
+		UPDATE sessions SET bind_ip = $2, bind_ua = $3, bind_hints = $4 WHERE id = $1
+	`, sessionID, b.IPPrefix, b.UAFamily, b.Hints)
+	if err != nil {
+		slog.Warn("session binding: update failed", "session_id", sessionID, "error", err)
+	}
+}
+
+// audit records a session event in the audit log.
+func (m *Manager) audit(ctx context.Context, s *Session, action string, detail map[string]any) {
+	data, _ := json.Marshal(detail)
+	_, err := m.pool.Exec(ctx, `
+		INSERT INTO audit_log (action, did, session_id, detail) VALUES ($1, $2, $3, $4)
+	`, action, s.DID, s.ID, data)
+	if err != nil {
+		slog.Warn("audit log write failed", "action", action, "error", err)
+	}
+}
@@ -2,53 +2,80 @@ package session
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-const cookieName = "noknok_session"
-
 // Session represents an active user session.
 type Session struct {
-	ID        int64
-	Token     string
-	DID       string
-	Handle    string
-	Username  string
-	GroupID   string
-	UserID    int64
-	ExpiresAt time.Time
+	ID          int64
+	DID         string
+	Handle      string
+	Username    string
+	DisplayName string
+	GroupID     string
+	UserID      int64
+	ExpiresAt   time.Time
+
+	OAuthSessionID string // linked atproto OAuth session, if any
+}
+
+// CookiePolicy controls how session cookies are named and scoped.
+type CookiePolicy struct {
+	Name             string                   // base cookie name, e.g. "noknok_session"
+	SecurePrefix     bool                     // name the shared cookie "__Secure-<Name>"
+	Secure           bool                     // set the Secure attribute
+	SameSite         http.SameSite            // default SameSite mode
+	SameSiteByDomain map[string]http.SameSite // per cookie domain (or portal host) overrides
+	Partitioned      bool                     // set the Partitioned (CHIPS) attribute
+	PortalHost       string                   // if set, this host uses its own host-only cookie
 }
 
 // Manager handles session creation, validation, and cleanup.
 type Manager struct {
 	pool         *pgxpool.Pool
 	ttl          time.Duration
+	oauthTTL     time.Duration
 	cookieDomain string
-	secure       bool
+	policy       CookiePolicy
+	hashKey      []byte
+	binding      BindingPolicy
+	onRevoke     func(ctx context.Context, ev RevokeEvent)
 	stopCleanup  chan struct{}
 }
 
-// NewManager creates a session manager.
-func NewManager(pool *pgxpool.Pool, ttl time.Duration, cookieDomain string, secure bool) *Manager {
+// NewManager creates a session manager. oauthTTL bounds how long pending
+// OAuth requests and unlinked OAuth sessions are kept before cleanup.
+// hashKey keys the HMAC under which tokens are stored; changing it logs
+// everyone out. binding decides what Validate does when a client changes.
+func NewManager(pool *pgxpool.Pool, ttl, oauthTTL time.Duration, cookieDomain string, policy CookiePolicy, hashKey []byte, binding BindingPolicy) *Manager {
 	return &Manager{
 		pool:         pool,
+		hashKey:      hashKey,
+		binding:      binding,
 		ttl:          ttl,
+		oauthTTL:     oauthTTL,
 		cookieDomain: cookieDomain,
-		secure:       secure,
+		policy:       policy,
 		stopCleanup:  make(chan struct{}),
 	}
 }
 
 // Create inserts a new session and returns a cookie to set on the response.
-// If groupID is empty, a new group is created.
-func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID string) (*http.Cookie, error) {
+// If groupID is empty, a new group is created. oauthSessionID links the
+// session to the atproto OAuth session it was created from; client is the
+// binding later requests are checked against.
+func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID, oauthSessionID string, client Binding) (*http.Cookie, error) {
 	token, err := generateToken()
 	if err != nil {
 		return nil, fmt.Errorf("generate token: %w", err)
@@ -61,18 +88,26 @@ func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID
 		}
 	}
 
-	// Look up username from users table.
-	var username string
+	// Look up username from users table and the cached profile display name.
+	var username, displayName string
 	_ = m.pool.QueryRow(ctx, `SELECT username FROM users WHERE id = $1`, userID).Scan(&username)
+	_ = m.pool.QueryRow(ctx, `SELECT display_name FROM user_identities WHERE did = $1`, did).Scan(&displayName)
 
 	expiresAt := time.Now().Add(m.ttl)
-	_, err = m.pool.Exec(ctx, `
-		INSERT INTO sessions (token, did, handle, username, group_id, user_id, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, token, did, handle, username, groupID, userID, expiresAt)
+	var id int64
+	err = m.pool.QueryRow(ctx, `
+		INSERT INTO sessions (token_hash, did, handle, username, display_name, group_id, user_id, oauth_session_id, expires_at,
+		                      bind_ip, bind_ua, bind_hints)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`, m.hashToken(token), did, handle, username, displayName, groupID, userID, oauthSessionID, expiresAt,
+		client.IPPrefix, client.UAFamily, client.Hints).Scan(&id)
 	if err != nil {
 		return nil, fmt.Errorf("insert session: %w", err)
 	}
+	if m.binding.Action != BindOff {
+		m.audit(ctx, &Session{ID: id, DID: did}, "session.bind", map[string]any{"to": client})
+	}
 
 	// Update the identity's handle if it changed.
 	_, err = m.pool.Exec(ctx, `
@@ -85,22 +120,33 @@ func (m *Manager) Create(ctx context.Context, userID int64, did, handle, groupID
 	return m.makeCookie(token, expiresAt), nil
 }
 
-// Validate checks a session token and returns the session if valid.
-func (m *Manager) Validate(ctx context.Context, token string) (*Session, error) {
+// Validate checks a session token presented by client and returns the
+// session if valid. With session binding enabled it returns ErrStepUp or
+// ErrRevoked when the client no longer matches the one the session is bound to.
+func (m *Manager) Validate(ctx context.Context, token string, client Binding) (*Session, error) {
 	var s Session
+	var bound Binding
 	err := m.pool.QueryRow(ctx, `
-		SELECT id, token, did, handle, username, COALESCE(group_id, ''), user_id, expires_at FROM sessions
-		WHERE token = $1 AND expires_at > now()
-	`, token).Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt)
+		SELECT id, did, handle, username, display_name, COALESCE(group_id, ''), user_id, oauth_session_id, expires_at,
+		       bind_ip, bind_ua, bind_hints
+		FROM sessions
+		WHERE token_hash = $1 AND expires_at > now()
+	`, m.hashToken(token)).Scan(&s.ID, &s.DID, &s.Handle, &s.Username, &s.DisplayName, &s.GroupID, &s.UserID, &s.OAuthSessionID, &s.ExpiresAt,
+		&bound.IPPrefix, &bound.UAFamily, &bound.Hints)
 	if err != nil {
 		return nil, err
 	}
+	if m.binding.Action != BindOff {
+		if err := m.checkBinding(ctx, &s, bound, client); err != nil {
+			return nil, err
+		}
+	}
 
 	// Update last_seen asynchronously.
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		_, _ = m.pool.Exec(ctx, `UPDATE sessions SET last_seen = now() WHERE token = $1`, token)
+		_, _ = m.pool.Exec(ctx, `UPDATE sessions SET last_seen = now() WHERE id = $1`, s.ID)
 	}()
 
 	return &s, nil
@@ -112,7 +158,7 @@ func (m *Manager) ListGroup(ctx context.Context, groupID string) ([]Session, err
 		return nil, nil
 	}
 	rows, err := m.pool.Query(ctx, `
-		SELECT id, token, did, handle, username, group_id, user_id, expires_at FROM sessions
+		SELECT id, did, handle, username, display_name, group_id, user_id, oauth_session_id, expires_at FROM sessions
 		WHERE group_id = $1 AND expires_at > now()
 		ORDER BY created_at
 	`, groupID)
@@ -124,7 +170,7 @@ func (m *Manager) ListGroup(ctx context.Context, groupID string) ([]Session, err
 	var sessions []Session
 	for rows.Next() {
 		var s Session
-		if err := rows.Scan(&s.ID, &s.Token, &s.DID, &s.Handle, &s.Username, &s.GroupID, &s.UserID, &s.ExpiresAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.DID, &s.Handle, &s.Username, &s.DisplayName, &s.GroupID, &s.UserID, &s.OAuthSessionID, &s.ExpiresAt); err != nil {
 			return nil, err
 		}
 		sessions = append(sessions, s)
@@ -133,41 +179,63 @@ func (m *Manager) ListGroup(ctx context.Context, groupID string) ([]Session, err
 }
 
 // GroupHasDID checks if a DID already exists in a group and returns the session ID if so.
-func (m *Manager) GroupHasDID(ctx context.Context, groupID, did string) (int64, string, bool) {
+func (m *Manager) GroupHasDID(ctx context.Context, groupID, did string) (int64, bool) {
 	if groupID == "" {
-		return 0, "", false
+		return 0, false
 	}
 	var id int64
-	var token string
 	err := m.pool.QueryRow(ctx, `
-		SELECT id, token FROM sessions
+		SELECT id FROM sessions
 		WHERE group_id = $1 AND did = $2 AND expires_at > now()
-	`, groupID, did).Scan(&id, &token)
+	`, groupID, did).Scan(&id)
 	if err != nil {
-		return 0, "", false
+		return 0, false
 	}
-	return id, token, true
+	return id, true
 }
 
 // SwitchTo switches the active session within a group. Returns a cookie for the target session.
 func (m *Manager) SwitchTo(ctx context.Context, groupID string, sessionID int64) (*http.Cookie, error) {
-	var token string
-	var expiresAt time.Time
-	err := m.pool.QueryRow(ctx, `
-		SELECT token, expires_at FROM sessions
-		WHERE id = $1 AND group_id = $2 AND expires_at > now()
-	`, sessionID, groupID).Scan(&token, &expiresAt)
+	token, expiresAt, err := m.reissue(ctx, `id = $2 AND group_id = $3`, sessionID, groupID)
 	if err != nil {
 		return nil, fmt.Errorf("session not found in group: %w", err)
 	}
 	return m.makeCookie(token, expiresAt), nil
 }
 
-// DestroyOne deletes one session from a group. If wasActive is true, returns a cookie
-// for the next session in the group, or ClearCookie if none remain.
+// reissue gives the session selected by where (with $2... as args) a fresh
+// token and returns it. Only token hashes are stored, so a session the
+// browser is not currently holding can only be resumed by rotating its token.
+// Copies of the old token relayed to other cookie domains stop working, so
+// callers must relay the new one (the server's relayChain does).
+func (m *Manager) reissue(ctx context.Context, where string, args ...any) (string, time.Time, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("generate token: %w", err)
+	}
+	var expiresAt time.Time
+	err = m.pool.QueryRow(ctx, `
+		UPDATE sessions SET token_hash = $1
+		WHERE id = (SELECT id FROM sessions WHERE `+where+` AND expires_at > now() ORDER BY created_at LIMIT 1)
+		RETURNING expires_at
+	`, append([]any{m.hashToken(token)}, args...)...).Scan(&expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// DestroyOne deletes one session from a group, along with its linked OAuth
+// session. If wasActive is true, returns a cookie for the next session in the
+// group, or ClearCookie if none remain.
 func (m *Manager) DestroyOne(ctx context.Context, groupID string, sessionID int64, wasActive bool) (*http.Cookie, error) {
 	_, err := m.pool.Exec(ctx, `
-		DELETE FROM sessions WHERE id = $1 AND group_id = $2
+		WITH d AS (
+			DELETE FROM sessions WHERE id = $1 AND group_id = $2
+			RETURNING did, oauth_session_id
+		)
+		DELETE FROM oauth_sessions o USING d
+		WHERE o.did = d.did AND o.session_id = d.oauth_session_id
 	`, sessionID, groupID)
 	if err != nil {
 		return nil, fmt.Errorf("delete session: %w", err)
@@ -177,14 +245,8 @@ func (m *Manager) DestroyOne(ctx context.Context, groupID string, sessionID int6
 		return nil, nil // no cookie change needed
 	}
 
-	// Find the next session in the group.
-	var token string
-	var expiresAt time.Time
-	err = m.pool.QueryRow(ctx, `
-		SELECT token, expires_at FROM sessions
-		WHERE group_id = $1 AND expires_at > now()
-		ORDER BY created_at LIMIT 1
-	`, groupID).Scan(&token, &expiresAt)
+	// Resume the next session in the group.
+	token, expiresAt, err := m.reissue(ctx, `group_id = $2`, groupID)
 	if err != nil {
 		// No sessions left — clear cookie.
 		return m.ClearCookie(), nil
@@ -192,41 +254,90 @@ func (m *Manager) DestroyOne(ctx context.Context, groupID string, sessionID int6
 	return m.makeCookie(token, expiresAt), nil
 }
 
-// DestroyGroup deletes all sessions in a group.
+// DestroyGroup deletes all sessions in a group and their linked OAuth sessions.
 func (m *Manager) DestroyGroup(ctx context.Context, groupID string) error {
 	if groupID == "" {
 		return nil
 	}
-	_, err := m.pool.Exec(ctx, `DELETE FROM sessions WHERE group_id = $1`, groupID)
+	_, err := m.pool.Exec(ctx, `
+		WITH d AS (
+			DELETE FROM sessions WHERE group_id = $1
+			RETURNING did, oauth_session_id
+		)
+		DELETE FROM oauth_sessions o USING d
+		WHERE o.did = d.did AND o.session_id = d.oauth_session_id
+	`, groupID)
 	return err
 }
 
-// Destroy removes a session (logout).
+// Destroy removes a session (logout) and its linked OAuth session.
 func (m *Manager) Destroy(ctx context.Context, token string) error {
-	_, err := m.pool.Exec(ctx, `DELETE FROM sessions WHERE token = $1`, token)
+	_, err := m.pool.Exec(ctx, `
+		WITH d AS (
+			DELETE FROM sessions WHERE token_hash = $1
+			RETURNING did, oauth_session_id
+		)
+		DELETE FROM oauth_sessions o USING d
+		WHERE o.did = d.did AND o.session_id = d.oauth_session_id
+	`, m.hashToken(token))
 	return err
 }
 
-// ClearCookie returns a cookie that clears the session cookie.
-func (m *Manager) ClearCookie() *http.Cookie {
-	return &http.Cookie{
-		Name:     cookieName,
-		Value:    "",
-		Path:     "/",
-		Domain:   m.cookieDomain,
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
+// ListActive returns all non-expired sessions.
+func (m *Manager) ListActive(ctx context.Context) ([]Session, error) {
+	rows, err := m.pool.Query(ctx, `
+		SELECT id, did, handle, username, display_name, group_id, user_id, oauth_session_id, expires_at FROM sessions
+		WHERE expires_at > now()
+		ORDER BY did, created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.DID, &s.Handle, &s.Username, &s.DisplayName, &s.GroupID, &s.UserID, &s.OAuthSessionID, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
 	}
+	return sessions, rows.Err()
 }
 
-// CookieName returns the session cookie name.
-func CookieName() string {
-	return cookieName
+// DestroyByDID deletes every session for a DID, in all groups, along with
+// their linked OAuth sessions. Returns the number of sessions removed.
+func (m *Manager) DestroyByDID(ctx context.Context, did string) (int64, error) {
+	var n int64
+	err := m.pool.QueryRow(ctx, `
+		WITH d AS (
+			DELETE FROM sessions WHERE did = $1
+			RETURNING did, oauth_session_id
+		), o AS (
+			DELETE FROM oauth_sessions o USING d
+			WHERE o.did = d.did AND o.session_id = d.oauth_session_id
+		)
+		SELECT count(*) FROM d
+	`, did).Scan(&n)
+	return n, err
+}
+
+// UpdateHandle records a new handle for a DID on its identity and on all of
+// its active sessions.
+func (m *Manager) UpdateHandle(ctx context.Context, did, handle string) error {
+	_, err := m.pool.Exec(ctx, `UPDATE user_identities SET handle = $2 WHERE did = $1`, did, handle)
+	if err != nil {
+		return err
+	}
+	_, err = m.pool.Exec(ctx, `
+		UPDATE sessions SET handle = $2
+		WHERE did = $1 AND expires_at > now()`, did, handle)
+	return err
 }
 
-// StartCleanup starts a background goroutine that deletes expired sessions.
+// StartCleanup starts a background goroutine that deletes expired sessions,
+// abandoned OAuth requests, and OAuth sessions no longer linked to a session.
 func (m *Manager) StartCleanup() {
 	go func() {
 		ticker := time.NewTicker(15 * time.Minute)
@@ -234,14 +345,7 @@ func (m *Manager) StartCleanup() {
 		for {
 			select {
 			case <-ticker.C:
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				result, err := m.pool.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= now()`)
-				cancel()
-				if err != nil {
-					slog.Error("session cleanup failed", "error", err)
-				} else if result.RowsAffected() > 0 {
-					slog.Info("cleaned up expired sessions", "count", result.RowsAffected())
-				}
+				m.cleanup()
 			case <-m.stopCleanup:
 				return
 			}
@@ -249,50 +353,201 @@ func (m *Manager) StartCleanup() {
 	}()
 }
 
+func (m *Manager) cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.pool.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= now()`)
+	if err != nil {
+		slog.Error("session cleanup failed", "error", err)
+	} else if result.RowsAffected() > 0 {
+		slog.Info("cleaned up expired sessions", "count", result.RowsAffected())
+	}
+
+	cutoff := time.Now().Add(-m.oauthTTL)
+
+	result, err = m.pool.Exec(ctx, `DELETE FROM oauth_requests WHERE created_at <= $1`, cutoff)
+	if err != nil {
+		slog.Error("oauth request cleanup failed", "error", err)
+	} else if result.RowsAffected() > 0 {
+		slog.Info("cleaned up abandoned oauth requests", "count", result.RowsAffected())
+	}
+
+	// OAuth sessions are kept only while a live noknok session links to them.
+	// The grace period covers the gap between the token exchange and
+	// session creation in the callback.
+	result, err = m.pool.Exec(ctx, `
+		DELETE FROM oauth_sessions o
+		WHERE o.updated_at <= $1 AND NOT EXISTS (
+			SELECT 1 FROM sessions s
+			WHERE s.did = o.did AND s.oauth_session_id = o.session_id AND s.expires_at > now()
+		)`, cutoff)
+	if err != nil {
+		slog.Error("oauth session cleanup failed", "error", err)
+	} else if result.RowsAffected() > 0 {
+		slog.Info("cleaned up unlinked oauth sessions", "count", result.RowsAffected())
+	}
+}
+
 // StopCleanup signals the cleanup goroutine to stop.
 func (m *Manager) StopCleanup() {
 	close(m.stopCleanup)
 }
 
+// SharedCookieName is the name of the session cookie set on the shared
+// cookie domains.
+func (m *Manager) SharedCookieName() string {
+	if m.policy.SecurePrefix {
+		return "__Secure-" + m.policy.Name
+	}
+	return m.policy.Name
+}
+
+// PortalCookieName is the name of the host-only portal cookie. It can only
+// carry the __Host- prefix when the cookie is Secure.
+func (m *Manager) PortalCookieName() string {
+	if m.policy.Secure {
+		return "__Host-" + m.policy.Name
+	}
+	return m.policy.Name + "_host"
+}
+
+// IsSessionCookie reports whether name is one of the session cookies.
+func (m *Manager) IsSessionCookie(name string) bool {
+	return name == m.SharedCookieName() || (m.policy.PortalHost != "" && name == m.PortalCookieName())
+}
+
+// Token returns the session token a request carries. On the portal host with
+// a portal cookie configured only the host-only cookie counts, so a shared
+// cookie planted by a sibling subdomain cannot replace the portal session.
+func (m *Manager) Token(r *http.Request) string {
+	return m.TokenForHost(r, r.Host)
+}
+
+// TokenForHost is Token for a request the browser sent to host, for
+// forward-auth endpoints where r.Host is noknok's own address.
+func (m *Manager) TokenForHost(r *http.Request, host string) string {
+	name := m.SharedCookieName()
+	if m.policy.PortalHost != "" && strings.EqualFold(hostname(host), m.policy.PortalHost) {
+		name = m.PortalCookieName()
+	}
+	if c, err := r.Cookie(name); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// PortalCookie returns the host-only twin of a shared session cookie (same
+// value and lifetime), or nil when no portal cookie is configured.
+func (m *Manager) PortalCookie(shared *http.Cookie) *http.Cookie {
+	if m.policy.PortalHost == "" {
+		return nil
+	}
+	c := m.cookie(m.PortalCookieName(), shared.Value, "", shared.Expires)
+	c.MaxAge = shared.MaxAge
+	c.SameSite = m.sameSite(m.policy.PortalHost)
+	return c
+}
+
+// ClearCookie returns a cookie that clears the session cookie.
+func (m *Manager) ClearCookie() *http.Cookie {
+	return m.ClearCookieForDomain(m.cookieDomain)
+}
+
 // MakeCookieForDomain creates a session cookie for a specific domain.
 func (m *Manager) MakeCookieForDomain(token string, expiresAt time.Time, domain string) *http.Cookie {
-	return &http.Cookie{
-		Name:     cookieName,
-		Value:    token,
-		Path:     "/",
-		Domain:   domain,
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
-	}
+	return m.cookie(m.SharedCookieName(), token, domain, expiresAt)
 }
 
 // ClearCookieForDomain creates a cookie that clears the session for a specific domain.
 func (m *Manager) ClearCookieForDomain(domain string) *http.Cookie {
-	return &http.Cookie{
-		Name:     cookieName,
-		Value:    "",
-		Path:     "/",
-		Domain:   domain,
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
-	}
+	c := m.cookie(m.SharedCookieName(), "", domain, time.Time{})
+	c.MaxAge = -1
+	return c
 }
 
 func (m *Manager) makeCookie(token string, expiresAt time.Time) *http.Cookie {
+	return m.MakeCookieForDomain(token, expiresAt, m.cookieDomain)
+}
+
+func (m *Manager) cookie(name, value, domain string, expiresAt time.Time) *http.Cookie {
 	return &http.Cookie{
-		Name:     cookieName,
-		Value:    token,
-		Path:     "/",
-		Domain:   m.cookieDomain,
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Secure:   m.secure,
-		SameSite: http.SameSiteLaxMode,
+		Name:        name,
+		Value:       value,
+		Path:        "/",
+		Domain:      domain,
+		Expires:     expiresAt,
+		HttpOnly:    true,
+		Secure:      m.policy.Secure,
+		SameSite:    m.sameSite(domain),
+		Partitioned: m.policy.Partitioned,
+	}
+}
+
+func (m *Manager) sameSite(domain string) http.SameSite {
+	if mode, ok := m.policy.SameSiteByDomain[domain]; ok {
+		return mode
+	}
+	return m.policy.SameSite
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// hashToken returns the HMAC-SHA256 of a token under the manager's key; this
+// is the only form in which tokens are stored.
+func (m *Manager) hashToken(token string) string {
+	mac := hmac.New(sha256.New, m.hashKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashSecret hashes another long-lived secret, such as an app password,
+// the same way session tokens are stored.
+func (m *Manager) HashSecret(secret string) string {
+	return m.hashToken(secret)
+}
+
+// MigrateTokens replaces raw tokens left by older versions with their hashes,
+// so existing sessions stay valid.
+func (m *Manager) MigrateTokens(ctx context.Context) error {
+	rows, err := m.pool.Query(ctx, `SELECT id, token FROM sessions WHERE token IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	type legacy struct {
+		id    int64
+		token string
+	}
+	var pending []legacy
+	for rows.Next() {
+		var l legacy
+		if err := rows.Scan(&l.id, &l.token); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range pending {
+		if _, err := m.pool.Exec(ctx, `
+			UPDATE sessions SET token_hash = $2, token = NULL WHERE id = $1`,
+			l.id, m.hashToken(l.token)); err != nil {
+			return fmt.Errorf("hash session %d: %w", l.id, err)
+		}
+	}
+	if len(pending) > 0 {
+		slog.Info("hashed legacy session tokens", "count", len(pending))
 	}
+	return nil
 }
 
 func generateToken() (string, error) {
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"syscall"
+)
+
+// ErrPrivateTarget is returned for webhook URLs that resolve to a loopback,
+// private or otherwise internal address.
+var ErrPrivateTarget = errors.New("webhook target is not a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether ip is routable on the public internet.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() && ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// CheckURL rejects a webhook URL whose host resolves to a non-public
+// address, unless private targets are allowed. The dialer checks again at
+// send time, so a name that later resolves elsewhere is still refused.
+func (d *Dispatcher) CheckURL(ctx context.Context, rawURL string) error {
+	if d.allowPrivate {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", u.Hostname(), err)
+	}
+	for _, a := range addrs {
+		if !publicAddr(a) {
+			return ErrPrivateTarget
+		}
+	}
+	return nil
+}
+
+// dialControl refuses connections to non-public addresses.
+func dialControl(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ap.Addr()) {
+		return ErrPrivateTarget
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+// Package webhook delivers signed event notifications to admin-configured
+// URLs. Events are queued in webhook_deliveries and sent by a background
+// worker that retries failures with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/primal-host/noknok/internal/database"
+)
+
+// Event types.
+const (
+	EventPing            = "ping"
+	EventLogin           = "login"
+	EventSessionRevoked  = "session.revoked"
+	EventUserCreated     = "user.created"
+	EventUserUpdated     = "user.updated"
+	EventUserRoleChanged = "user.role_changed"
+	EventUserDeleted     = "user.deleted"
+	EventIdentityAdded   = "identity.added"
+	EventIdentityRemoved = "identity.removed"
+	EventGrantCreated    = "grant.created"
+	EventGrantDeleted    = "grant.deleted"
+	EventServiceCreated  = "service.created"
+	EventServiceUpdated  = "service.updated"
+	EventServiceToggled  = "service.toggled"
+	EventServiceDeleted  = "service.deleted"
+	EventNotification    = "notification" // a security notification, see internal/notify
+)
+
+// EventTypes lists every event type a webhook can subscribe to.
+var EventTypes = []string{
+	EventLogin, EventSessionRevoked,
+	EventUserCreated, EventUserUpdated, EventUserRoleChanged, EventUserDeleted,
+	EventIdentityAdded, EventIdentityRemoved,
+	EventGrantCreated, EventGrantDeleted,
+	EventServiceCreated, EventServiceUpdated, EventServiceToggled, EventServiceDeleted,
+	EventNotification,
+}
+
+// Headers set on every delivery. The signature header has the form
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed by the secret>".
+const (
+	HeaderEvent     = "X-Noknok-Event"
+	HeaderEventID   = "X-Noknok-Event-Id"
+	HeaderDelivery  = "X-Noknok-Delivery"
+	HeaderSignature = "X-Noknok-Signature"
+)
+
+const (
+	maxAttempts = 10
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	lease       = 2 * time.Minute // a claimed delivery is not retried before this
+	batchSize   = 20
+	keepLog     = 30 * 24 * time.Hour
+)
+
+// payload is the JSON body of every delivery.
+type payload struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Dispatcher queues events and runs the delivery worker.
+type Dispatcher struct {
+	db           *database.DB
+	client       *http.Client
+	allowPrivate bool
+	wake         chan struct{}
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// New returns a dispatcher; call Start to begin delivering. Unless
+// allowPrivate is set, deliveries to loopback or private addresses are
+// refused.
+func New(db *database.DB, allowPrivate bool) *Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would dial on our behalf and bypass the address check.
+	transport.Proxy = nil
+	if !allowPrivate {
+		dialer := &net.Dialer{Timeout: 5 * time.Second, Control: dialControl}
+		transport.DialContext = dialer.DialContext
+	}
+	return &Dispatcher{
+		db:           db,
+		allowPrivate: allowPrivate,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+			// A redirect is a misconfigured endpoint, not a delivery.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Emit queues an event for every subscribed webhook. Failures are logged,
+// never returned: webhooks must not break the action that raised them.
+func (d *Dispatcher) Emit(ctx context.Context, eventType string, data any) {
+	id, body, err := newPayload(eventType, data)
+	if err != nil {
+		slog.Error("webhook: encode event failed", "type", eventType, "error", err)
+		return
+	}
+	n, err := d.db.EnqueueWebhookEvent(ctx, id, eventType, body)
+	if err != nil {
+		slog.Error("webhook: enqueue failed", "type", eventType, "error", err)
+		return
+	}
+	if n > 0 {
+		d.Poke()
+	}
+}
+
+// SendTest queues a ping event for one webhook and returns the delivery.
+func (d *Dispatcher) SendTest(ctx context.Context, webhookID int64) (*database.WebhookDelivery, error) {
+	id, body, err := newPayload(EventPing, map[string]any{"webhook_id": webhookID})
+	if err != nil {
+		return nil, err
+	}
+	del, err := d.db.EnqueueWebhookDelivery(ctx, webhookID, id, EventPing, body)
+	if err != nil {
+		return nil, err
+	}
+	d.Poke()
+	return del, nil
+}
+
+// Poke wakes the worker, e.g. after a delivery is requeued by hand.
+func (d *Dispatcher) Poke() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the delivery worker until Stop.
+func (d *Dispatcher) Start() {
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+		for {
+			d.drain()
+			if time.Since(lastPrune) > time.Hour {
+				d.prune()
+				lastPrune = time.Now()
+			}
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the worker and waits for the current batch to finish.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+// drain sends due deliveries until none are left.
+func (d *Dispatcher) drain() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		jobs, err := d.db.ClaimWebhookJobs(ctx, batchSize, lease)
+		cancel()
+		if err != nil {
+			slog.Error("webhook: claim failed", "error", err)
+			return
+		}
+		for _, j := range jobs {
+			d.deliver(j)
+		}
+		if len(jobs) < batchSize {
+			return
+		}
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(j database.WebhookJob) {
+	status, err := d.send(j)
+
+	var retryAt *time.Time
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+		if j.Attempts+1 < maxAttempts {
+			t := time.Now().Add(backoff(j.Attempts + 1))
+			retryAt = &t
+		}
+		slog.Warn("webhook: delivery failed", "delivery_id", j.ID, "webhook_id", j.WebhookID,
+			"type", j.EventType, "attempt", j.Attempts+1, "status", status, "error", err, "final", retryAt == nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.db.FinishWebhookDelivery(ctx, j.ID, status, errMsg, retryAt); err != nil {
+		slog.Error("webhook: record attempt failed", "delivery_id", j.ID, "error", err)
+	}
+}
+
+func (d *Dispatcher) send(j database.WebhookJob) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, j.URL, bytes.NewReader(j.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "noknok-webhook")
+	req.Header.Set(HeaderEvent, j.EventType)
+	req.Header.Set(HeaderEventID, j.EventID)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(j.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(j.Secret, time.Now().Unix(), j.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if n, err := d.db.PruneWebhookDeliveries(ctx, keepLog); err != nil {
+		slog.Warn("webhook: prune failed", "error", err)
+	} else if n > 0 {
+		slog.Info("webhook: pruned delivery log", "count", n)
+	}
+}
+
+// backoff returns the delay before the given attempt number (1-based).
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
+
+// Sign returns the signature header value for body sent at unix time ts.
+func Sign(secret string, ts int64, body []byte) string {
+	t := strconv.FormatInt(ts, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateSecret returns a random signing secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// ValidEventFilter reports whether f is a known event type, a "prefix.*"
+// wildcard or "*".
+func ValidEventFilter(f string) bool {
+	if f == "*" {
+		return true
+	}
+	for _, t := range EventTypes {
+		if f == t {
+			return true
+		}
+		if prefix, _, ok := strings.Cut(t, "."); ok && f == prefix+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+func newPayload(eventType string, data any) (string, []byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	id := "evt_" + hex.EncodeToString(b)
+	body, err := json.Marshal(payload{ID: id, Type: eventType, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return "", nil, err
+	}
+	return id, body, nil
+}